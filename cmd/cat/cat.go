@@ -0,0 +1,48 @@
+package cat
+
+import (
+	"io"
+	"os"
+
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/nextcloud"
+)
+
+type ctx struct {
+	n *nextcloud.Nextcloud // Nextcloud クライアント
+}
+
+type Option func(*ctx) error
+
+func Do(n *nextcloud.Nextcloud, opts []Option, paths []string) error {
+	ctx := &ctx{
+		n: n,
+	}
+
+	for _, opt := range opts {
+		if err := opt(ctx); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range paths {
+		if err := cat(ctx, path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cat は path の中身を標準出力に書き出す。分割されたファイルは結合して書き出す
+func cat(ctx *ctx, path string) error {
+	f, err := ctx.n.OpenJoined(path)
+	if err != nil {
+		return err
+	}
+
+	r := f.ReadRange(0, -1)
+	defer r.Close()
+
+	_, err = io.Copy(os.Stdout, r)
+	return err
+}
@@ -0,0 +1,13 @@
+package cp
+
+import (
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/backend"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/nextcloud"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/transfer"
+)
+
+// Do は src の entries を、dst の dir ディレクトリの中にコピーする
+// src と dst は別のサーバーやアカウントでもよい。データはいったん手元を通る
+func Do(src *nextcloud.Nextcloud, dst *nextcloud.Nextcloud, opts []transfer.Option, entries []transfer.Entry, dir string) error {
+	return transfer.Do(backend.NewNextcloud(src), backend.NewNextcloud(dst), opts, entries, dir)
+}
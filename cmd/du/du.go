@@ -0,0 +1,139 @@
+package du
+
+import (
+	"fmt"
+	"math"
+	"os"
+	_path "path"
+	"strconv"
+
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/nextcloud"
+)
+
+type ctx struct {
+	n *nextcloud.Nextcloud // Nextcloud クライアント
+
+	summarize     bool // 引数ごとの合計だけを表示する
+	humanReadable bool // 1K 234M 2G のような単位付きで表示する
+	maxDepth      int  // 表示するディレクトリの深さ。負なら無制限
+}
+
+type Option func(*ctx) error
+
+func Summarize(b bool) Option {
+	return func(ctx *ctx) error {
+		ctx.summarize = b
+		return nil
+	}
+}
+
+func HumanReadable(b bool) Option {
+	return func(ctx *ctx) error {
+		ctx.humanReadable = b
+		return nil
+	}
+}
+
+func MaxDepth(depth int) Option {
+	return func(ctx *ctx) error {
+		ctx.maxDepth = depth
+		return nil
+	}
+}
+
+func Do(n *nextcloud.Nextcloud, opts []Option, paths []string) error {
+	ctx := &ctx{
+		n: n,
+
+		summarize:     false,
+		humanReadable: false,
+		maxDepth:      -1,
+	}
+
+	for _, opt := range opts {
+		if err := opt(ctx); err != nil {
+			return err
+		}
+	}
+
+	if ctx.summarize {
+		if ctx.maxDepth > 0 {
+			return fmt.Errorf("cannot both summarize and show all entries until depth %d", ctx.maxDepth)
+		}
+		ctx.maxDepth = 0
+	}
+
+	for _, path := range paths {
+		path := _path.Clean(path)
+
+		fi, err := ctx.n.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		if err := du(ctx, path, fi, 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// du はディレクトリのサイズを子ディレクトリから順に表示する
+// サイズはサーバーが集計した oc:size を使うので、ファイルを一つずつ足し合わせることはしない
+func du(ctx *ctx, path string, fi os.FileInfo, depth int) error {
+	if fi.IsDir() && (ctx.maxDepth < 0 || depth < ctx.maxDepth) {
+		fl, err := ctx.n.ReadDir(path)
+		if err != nil {
+			return err
+		}
+
+		for _, fi := range fl {
+			if !fi.IsDir() {
+				continue
+			}
+
+			if err := du(ctx, _path.Join(path, fi.Name()), fi, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	fmt.Println(formatSize(ctx, totalSize(fi)) + "\t" + path)
+
+	return nil
+}
+
+func totalSize(fi os.FileInfo) int64 {
+	if nfi, ok := fi.(*nextcloud.FileInfo); ok {
+		return nfi.TotalSize()
+	}
+	return fi.Size()
+}
+
+func formatSize(ctx *ctx, size int64) string {
+	if !ctx.humanReadable {
+		return strconv.FormatInt(size, 10)
+	}
+
+	return FormatSize(size)
+}
+
+// FormatSize は du -h と同じように 1024 単位で 1.5K や 234M のような文字列にする
+func FormatSize(size int64) string {
+	if size < 1024 {
+		return strconv.FormatInt(size, 10)
+	}
+
+	e := math.Floor(math.Log(float64(size)) / math.Log(1024))
+	if e > 6 {
+		e = 6
+	}
+	unit := []string{"", "K", "M", "G", "T", "P", "E"}[int(e)]
+
+	v := math.Ceil(float64(size)/math.Pow(1024, e)*10) / 10
+	if v >= 10 {
+		return fmt.Sprintf("%.0f%s", math.Ceil(v), unit)
+	}
+	return fmt.Sprintf("%.1f%s", v, unit)
+}
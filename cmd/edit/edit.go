@@ -0,0 +1,205 @@
+package edit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	_path "path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/nextcloud"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/webdav"
+)
+
+type ctx struct {
+	n *nextcloud.Nextcloud // Nextcloud クライアント
+
+	editor string // 起動するエディタ。引数を含んでいてもよい
+}
+
+type Option func(*ctx) error
+
+func Editor(editor string) Option {
+	return func(ctx *ctx) error {
+		if strings.TrimSpace(editor) == "" {
+			return errors.New("invalid editor: " + editor)
+		}
+
+		ctx.editor = editor
+		return nil
+	}
+}
+
+func Do(n *nextcloud.Nextcloud, opts []Option, path string) error {
+	ctx := &ctx{
+		n: n,
+
+		editor: defaultEditor(),
+	}
+
+	for _, opt := range opts {
+		if err := opt(ctx); err != nil {
+			return err
+		}
+	}
+
+	return edit(ctx, path)
+}
+
+// defaultEditor は $VISUAL、$EDITOR の順に見て、どちらもなければ OS ごとの標準のエディタを返す
+func defaultEditor() string {
+	if editor := os.Getenv("VISUAL"); editor != "" {
+		return editor
+	}
+
+	if editor := os.Getenv("EDITOR"); editor != "" {
+		return editor
+	}
+
+	if runtime.GOOS == "windows" {
+		return "notepad"
+	}
+	return "vi"
+}
+
+func edit(ctx *ctx, path string) error {
+	// 編集している間に他で変更されていたら上書きしないように、ETag を条件にして書き戻す
+	var cond webdav.Condition
+
+	fi, err := ctx.n.Stat(path)
+	switch {
+	case err == nil && fi.IsDir():
+		return &os.PathError{Op: "Edit", Path: path, Err: errors.New("is a directory")}
+
+	case err == nil:
+		nfi, ok := fi.(*nextcloud.FileInfo)
+		if !ok || nfi.ETag() == "" {
+			return &os.PathError{Op: "Edit", Path: path, Err: errors.New("failed to get etag")}
+		}
+		cond = webdav.IfMatch(nfi.ETag())
+
+	case errors.Is(err, os.ErrNotExist):
+		// 新しく作るときは、その間に他で作られていないことを条件にする
+		cond = webdav.IfNoneMatch("*")
+
+	default:
+		return err
+	}
+
+	// エディタが拡張子でファイルの種類を判断できるように、元のファイル名で一時ファイルを作る
+	dir, err := ioutil.TempDir("", "nextcloud-cli-edit-")
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(dir, _path.Base(path))
+
+	keep := false
+	defer func() {
+		if !keep {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	if fi != nil {
+		header, err := download(ctx, path, tmp)
+		if err != nil {
+			return err
+		}
+
+		// Stat してから取得するまでに変更されていることもあるので、取得したものの ETag があればそれを使う
+		if header.ETag != "" {
+			cond = webdav.IfMatch(header.ETag)
+		}
+	} else {
+		if err := ioutil.WriteFile(tmp, nil, 0600); err != nil {
+			return err
+		}
+	}
+
+	before, err := checksum(tmp)
+	if err != nil {
+		return err
+	}
+
+	args := append(strings.Fields(ctx.editor), tmp)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor exited with error, not uploading: %w", err)
+	}
+
+	after, err := checksum(tmp)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(before, after) {
+		fmt.Println("no changes: " + path)
+		return nil
+	}
+
+	f, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := ctx.n.WriteFile(path, f, cond); err != nil {
+		// 編集した内容を失わないように一時ファイルを残す
+		keep = true
+
+		// 既にあるファイルは If-Match で、新しく作るファイルは If-None-Match: * で、ほかからの変更を検出する
+		if webdav.IsPrecondition(err) || errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%s was modified on the server while editing, not overwriting it: %w\nyour changes are saved in %s", path, err, tmp)
+		}
+		return fmt.Errorf("%w\nyour changes are saved in %s", err, tmp)
+	}
+
+	fmt.Println("updated: " + path)
+	return nil
+}
+
+func download(ctx *ctx, src string, dst string) (*webdav.Header, error) {
+	r, header, err := ctx.n.GetFile(src)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.Copy(f, r)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+
+	return header, err
+}
+
+func checksum(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
@@ -1,6 +1,7 @@
 package find
 
 import (
+	"context"
 	"fmt"
 	"os"
 	_path "path"
@@ -12,6 +13,8 @@ import (
 type ctx struct {
 	n *nextcloud.Nextcloud
 
+	context context.Context // キャンセルされたら探索をやめる
+
 	maxDepth       int
 	minDepth       int
 	ls             bool
@@ -20,6 +23,13 @@ type ctx struct {
 
 type Option func(*ctx) error
 
+func Context(c context.Context) Option {
+	return func(ctx *ctx) error {
+		ctx.context = c
+		return nil
+	}
+}
+
 func MaxDepth(depth int) Option {
 	return func(ctx *ctx) error {
 		ctx.maxDepth = depth
@@ -43,6 +53,8 @@ func Do(n *nextcloud.Nextcloud, opts []Option, paths []string, expressions []str
 	ctx := &ctx{
 		n: n,
 
+		context: context.Background(),
+
 		maxDepth:       -1,
 		minDepth:       -1,
 		ls:             false,
@@ -58,7 +70,7 @@ func Do(n *nextcloud.Nextcloud, opts []Option, paths []string, expressions []str
 	for _, path := range paths {
 		path := _path.Clean(path)
 
-		fi, err := ctx.n.Stat(path)
+		fi, err := ctx.n.StatContext(ctx.context, path)
 		if err != nil {
 			return err
 		}
@@ -91,7 +103,11 @@ func find(ctx *ctx, path string, fi os.FileInfo, expr query.Expr, depth int) err
 		return nil
 	}
 
-	fl, err := ctx.n.ReadDir(path)
+	if err := ctx.context.Err(); err != nil {
+		return err
+	}
+
+	fl, err := ctx.n.ReadDirContext(ctx.context, path)
 	if err != nil {
 		return err
 	}
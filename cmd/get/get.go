@@ -1,35 +1,33 @@
 package get
 
 import (
-	"archive/tar"
-	"bufio"
+	"context"
 	"errors"
 	"fmt"
-	"io"
-	"io/fs"
 	"os"
 	_path "path"
 	"path/filepath"
-	"strings"
-	"time"
 
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/backend"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/events"
 	"github.com/kurusugawa-computer/nextcloud-cli/lib/nextcloud"
-	"github.com/thamaji/pbpool"
-	"golang.org/x/crypto/ssh/terminal"
-	"gopkg.in/cheggaaa/pb.v1"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/transfer"
 )
 
 type ctx struct {
 	n *nextcloud.Nextcloud // Nextcloud クライアント
 
-	pool *pbpool.Pool // プログレスバーのプール
+	context context.Context // キャンセルされたら通信中のものも中断する
 
-	deconflictStrategy int // ファイルが衝突したときの処理方法
+	deconflictStrategy string // ファイルが衝突したときの処理方法
 
-	retry int           // リトライ回数
-	delay time.Duration // リトライ時のディレイ
+	retry int // 途中で失敗したファイルをコピーしなおす回数
 
 	join bool // 分割されていそうなファイルが存在したときに自動で結合するかどうか
+
+	bwlimit string // 転送速度の制限。transfer.BWLimit にそのまま渡す
+
+	events *events.Writer // 進捗を JSON で書き出す。transfer.Events にそのまま渡す
 }
 
 type Option func(*ctx) error
@@ -42,11 +40,8 @@ const (
 func DeconflictStrategy(strategy string) Option {
 	return func(ctx *ctx) error {
 		switch strategy {
-		case DeconflictError:
-			ctx.deconflictStrategy = 0
-
-		case DeconflictOverwrite:
-			ctx.deconflictStrategy = 1
+		case DeconflictError, DeconflictOverwrite:
+			ctx.deconflictStrategy = strategy
 
 		default:
 			return errors.New("invalid strategy: " + strategy)
@@ -56,23 +51,25 @@ func DeconflictStrategy(strategy string) Option {
 	}
 }
 
-func Retry(n int, delay time.Duration) Option {
+func Retry(n int) Option {
 	return func(ctx *ctx) error {
 		if n < 0 {
 			return fmt.Errorf("invalid retry count: %d", n)
 		}
 
-		if delay < 0 {
-			return fmt.Errorf("invalid delay: %s", delay)
-		}
-
 		ctx.retry = n
-		ctx.delay = delay
 
 		return nil
 	}
 }
 
+func Context(c context.Context) Option {
+	return func(ctx *ctx) error {
+		ctx.context = c
+		return nil
+	}
+}
+
 func Join(b bool) Option {
 	return func(ctx *ctx) error {
 		ctx.join = b
@@ -80,16 +77,32 @@ func Join(b bool) Option {
 	}
 }
 
+// BWLimit は転送速度を制限する。指定方法は transfer.BWLimit と同じ
+func BWLimit(spec string) Option {
+	return func(ctx *ctx) error {
+		ctx.bwlimit = spec
+		return nil
+	}
+}
+
+// Events は進捗を w に JSON で書き出す。指定方法は transfer.Events と同じ
+func Events(w *events.Writer) Option {
+	return func(ctx *ctx) error {
+		ctx.events = w
+		return nil
+	}
+}
+
+// Do は src を dst/filename にダウンロードする。src がディレクトリのときは tar にまとめる
 func Do(n *nextcloud.Nextcloud, opts []Option, src string, dst string, filename string) error {
 	ctx := &ctx{
 		n: n,
 
-		pool: nil,
+		context: context.Background(),
 
-		deconflictStrategy: 0,
+		deconflictStrategy: DeconflictError,
 
 		retry: 3,
-		delay: 30 * time.Second,
 
 		join: false,
 	}
@@ -100,378 +113,85 @@ func Do(n *nextcloud.Nextcloud, opts []Option, src string, dst string, filename
 		}
 	}
 
-	if terminal.IsTerminal(int(os.Stdout.Fd())) {
-		ctx.pool = pbpool.New()
-	}
-
-	if ctx.pool != nil {
-		ctx.pool.Start()
+	transferOpts := []transfer.Option{
+		transfer.Context(ctx.context),
+		transfer.DeconflictStrategy(ctx.deconflictStrategy),
+		transfer.Retry(ctx.retry),
+		transfer.Join(ctx.join),
+		transfer.BWLimit(ctx.bwlimit),
+		transfer.Events(ctx.events),
 	}
 
-	if err := download(ctx, src, dst, filename); err != nil {
+	isDir, err := isDir(ctx, src)
+	if err != nil {
 		return err
 	}
 
-	if ctx.pool != nil {
-		ctx.pool.Update()
-		ctx.pool.Stop()
-	}
-	return nil
-}
+	dstPath := filepath.Join(dst, filename)
 
-//file,directory,joinの場合分けしてダウンロードまでの準備をする. src:REMOTE_PATH. dst:出力先ディレクトリ. filename:出力ファイルの名前
-func download(ctx *ctx, src string, dst string, filename string) error {
-	//分割ファイルを結合する処理
-	if ctx.join {
-		// joinした後に同じsrcという名前になるものがないかチェックする
-		fisMap, err := ctx.n.ReadJoinedDir(_path.Dir(src))
-		if err != nil {
-			return err
-		}
-
-		fls := fisMap[_path.Base(src)]
-		if len(fls) == 0 {
-			fi, err := ctx.n.Stat(src)
-			if err != nil {
-				return err
-			}
-			return fmt.Errorf("unexpected: %s not found in %s by ReadJoinedDir, but actually exists", fi.Name(), _path.Dir(src))
-
-		} else if len(fls) != 1 {
-			// joinした後に同じsrcという名前になるものが複数存在する
-			names := []string{}
-			for _, fis := range fls {
-				for _, fi := range fis {
-					names = append(names, fi.Name())
-				}
-			}
-			return fmt.Errorf("name collision detected: %s", strings.Join(names, " "))
-
-		} else if fls[0][0].IsDir() {
-			tarFile, tarWriter, err := createTarFileAndWriter(ctx, src, dst, filename)
-			if err != nil {
-				return err
-			}
-			if err := downloadDir(ctx, src, dst, tarWriter); err != nil {
-				return err
-			}
-			if err := tarWriter.Close(); err != nil {
-				return err
-			}
-			return tarFile.Close()
-
-		} else {
-			srcs := []string{}
-			for _, fi := range fls[0] {
-				srcs = append(srcs, _path.Join(_path.Dir(src), fi.Name()))
-			}
-
-			return downloadAndJoinFiles(ctx, dst, srcs, filepath.Join(dst, filename))
-		}
+	if !isDir {
+		return transfer.DoFile(backend.NewNextcloud(n), backend.NewLocal(), transferOpts, src, filepath.ToSlash(dstPath))
 	}
 
-	fi, err := ctx.n.Stat(src)
+	// ディレクトリは tar に書き込む。tar には順番に書き込むしかないので並列にはしない
+	// tar の中のファイル名はリモートのパスにする
+	tarBackend, err := createTar(ctx, dstPath)
 	if err != nil {
 		return err
 	}
 
-	if !fi.IsDir() {
-		return downloadFile(ctx, dst, src, filepath.Join(dst, filename))
-	}
+	transferOpts = append(transferOpts, transfer.Procs(1))
 
-	tarFile, tarWriter, err := createTarFileAndWriter(ctx, src, dst, filename)
-	if err != nil {
-		return err
-	}
-	if err := downloadDir(ctx, src, dst, tarWriter); err != nil {
+	if err := transfer.DoFile(backend.NewNextcloud(n), tarBackend, transferOpts, src, src); err != nil {
+		tarBackend.Abort()
 		return err
 	}
-	if err := tarWriter.Close(); err != nil {
-		return err
-	}
-	return tarFile.Close()
-}
-
-//directoryダウンロード用のtarFile,tarWriterを作成
-func createTarFileAndWriter(ctx *ctx, src string, dst string, filename string) (*os.File, *tar.Writer, error) {
-	switch ctx.deconflictStrategy {
-	case 0: // DeconflictError
-		if _, err := os.Stat(_path.Join(dst, filename)); err == nil {
-			return nil, nil, fmt.Errorf("local file already exists: " + _path.Join(dst, filename))
-		}
-	case 1: // DeconflictOverwrite
-	}
-
-	fi, err := ctx.n.Stat(src)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	if err := os.MkdirAll(dst, fi.Mode()); err != nil {
-		return nil, nil, err
-	}
-
-	tarFile, err := os.Create(_path.Join(dst, filename))
-	return tarFile, tar.NewWriter(tarFile), err
-}
 
-//join以外からdownloadAndJoinFilesを呼ぶための関数
-func downloadFile(ctx *ctx, dir string, src string, dst string) error {
-	return downloadAndJoinFiles(ctx, dir, []string{src}, dst)
+	return tarBackend.Close()
 }
 
-//出力先の親ディレクトリがない場合作成し、ダウンロード. dir:出力先ディレクトリ. srcs:REMOTE_PATH_LIST. dst:出力先ファイル
-func downloadAndJoinFiles(ctx *ctx, dir string, srcs []string, dst string) error {
-	if len(srcs) == 0 {
-		return errors.New("unexpected: tried to download empty file set")
-	}
-
-	switch ctx.deconflictStrategy {
-	case 0: // DeconflictError
-		if _, err := os.Stat(dst); err == nil {
-			return errors.New("local file already exists: " + dst)
-		}
-
-	case 1: // DeconflictOverwrite
-	}
-
-	totalSize := int64(0)
-	for _, src := range srcs {
-		fi, err := ctx.n.Stat(src)
+// isDir は src がディレクトリかどうかを返す。join するときは結合した後の名前で調べる
+func isDir(ctx *ctx, src string) (bool, error) {
+	if ctx.join && src != "/" {
+		fisMap, err := ctx.n.ReadJoinedDirContext(ctx.context, _path.Dir(src))
 		if err != nil {
-			return err
+			return false, err
 		}
-		totalSize += fi.Size()
-	}
 
-	try := func() error {
-		var bar *pbpool.ProgressBar
-
-		if ctx.pool == nil {
-			fmt.Fprintln(os.Stdout, dst)
-		} else {
-			bar = ctx.pool.Get()
-			bar.SetTotal64(totalSize).Prefix(dst).SetUnits(pb.U_BYTES).Start()
-			defer func() {
-				bar.Finish()
-				ctx.pool.Put(bar)
-			}()
+		if fls := fisMap[_path.Base(src)]; len(fls) == 1 {
+			return fls[0][0].IsDir(), nil
 		}
 
-		srcFirstFileInfo, err := ctx.n.Stat(srcs[0])
-		if err != nil {
-			return err
-		}
-
-		if fi, err := os.Stat(dir); err != nil {
-			if errors.Is(err, fs.ErrNotExist) {
-				if err1 := os.MkdirAll(dir, 0775); err1 != nil {
-					return err1
-				}
-			} else {
-				return err
-			}
-		} else if !fi.IsDir() {
-			return errors.New(dir + " directory could not be created because " + dir + " already exists.")
-		}
-
-		dstFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, srcFirstFileInfo.Mode())
-		if err != nil {
-			return err
-		}
-		defer dstFile.Close()
-
-		var w io.Writer = dstFile
-		if bar != nil {
-			w = io.MultiWriter(dstFile, bar)
-		}
-
-		bw := bufio.NewWriter(w)
-
-		for _, src := range srcs {
-			srcFile, err := ctx.n.ReadFile(src)
-			if err != nil {
-				return err
-			}
-
-			if _, err := io.Copy(bw, srcFile); err != nil {
-				srcFile.Close()
-				return err
-			}
-			srcFile.Close()
-
-			if err := bw.Flush(); err != nil {
-				return err
-			}
-
-			if err := dstFile.Sync(); err != nil {
-				return err
-			}
-		}
-		return nil
+		// 見つからなかったり衝突しているときのエラーはダウンロードするときに返す
+		return false, nil
 	}
 
-	var n int
-	var err error
-	for n, err = 0, try(); err != nil && ctx.retry > n; n, err = n+1, try() {
-		fmt.Println("error! retry after " + ctx.delay.String() + "...")
-		fmt.Println("  " + err.Error() + "\n")
-		time.Sleep(ctx.delay)
-	}
-	return err
-}
-
-//ダウンロードするディレクトリ内のファイル一覧を作成して１つずつdownloadWithTarに渡す
-func downloadDir(ctx *ctx, src string, dst string, tarWriter *tar.Writer) error {
-	fi, err := ctx.n.Stat(src)
+	fi, err := ctx.n.StatContext(ctx.context, src)
 	if err != nil {
-		return err
-	}
-
-	if !fi.IsDir() {
-		return fmt.Errorf("unexpected: %s is expected to directory", src)
-	}
-
-	type task struct {
-		srcs []string
-		name string
+		return false, err
 	}
-	tasks := []*task{}
 
-	if ctx.join {
-		fisMap, err := ctx.n.ReadJoinedDir(src)
-		if err != nil {
-			return err
-		}
-
-		for name, fls := range fisMap {
-			if len(fls) != 1 {
-				// joinした後に同じsrcという名前になるものが複数存在する
-				names := []string{}
-				for _, fis := range fls {
-					for _, fi := range fis {
-						names = append(names, fi.Name())
-					}
-				}
-				return fmt.Errorf("name collision detected: %s", strings.Join(names, " "))
-			}
-
-			fl := fls[0]
-
-			if fl[0].IsDir() {
-				if err := downloadDir(ctx, _path.Join(src, fl[0].Name()), filepath.Join(dst, fl[0].Name()), tarWriter); err != nil {
-					return err
-				}
-				continue
-			}
-
-			task := &task{
-				srcs: []string{},
-				name: name,
-			}
-			for _, fi := range fl {
-				task.srcs = append(task.srcs, _path.Join(src, fi.Name()))
-			}
-			tasks = append(tasks, task)
-		}
-
-	} else {
-		fl, err := ctx.n.ReadDir(src)
-		if err != nil {
-			return err
-		}
+	return fi.IsDir(), nil
+}
 
-		for _, fi := range fl {
-			if fi.IsDir() {
-				downloadDir(ctx, _path.Join(src, fi.Name()), filepath.Join(dst, fi.Name()), tarWriter)
-				continue
-			}
-			tasks = append(tasks, &task{
-				srcs: []string{_path.Join(src, fi.Name())},
-				name: fi.Name(),
-			})
+// createTar はディレクトリをダウンロードするための tar ファイルを作る
+func createTar(ctx *ctx, path string) (*tarBackend, error) {
+	switch ctx.deconflictStrategy {
+	case DeconflictError:
+		if _, err := os.Stat(path); err == nil {
+			return nil, fmt.Errorf("local file already exists: %s: %w", path, os.ErrExist)
 		}
+	case DeconflictOverwrite:
 	}
 
-	for _, task := range tasks {
-		if err := downloadWithTar(ctx, task.srcs, task.name, tarWriter); err != nil {
-			return err
-		}
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		return nil, err
 	}
-	return nil
-}
 
-//downloadDirから受け取ったREMOTE_PATH_LISTのファイルをtarWriterに書き込み
-func downloadWithTar(ctx *ctx, srcs []string, fileName string, tarWriter *tar.Writer) error {
-	if len(srcs) == 0 {
-		return errors.New("unexpected: tried to download empty file set")
-	}
-
-	try := func() error {
-		totalSize := int64(0)
-		for _, src := range srcs {
-			fi, err := ctx.n.Stat(src)
-			if err != nil {
-				return err
-			}
-			totalSize += fi.Size()
-		}
-
-		fi, err := ctx.n.Stat(srcs[0])
-		if err != nil {
-			return err
-		}
-		header := &tar.Header{
-			Name:    _path.Join(_path.Dir(srcs[0]), fileName),
-			Mode:    int64(fi.Mode()),
-			ModTime: fi.ModTime(),
-			Size:    totalSize,
-		}
-
-		if err := tarWriter.WriteHeader(header); err != nil {
-			return err
-		}
-
-		var bar *pbpool.ProgressBar
-
-		if ctx.pool == nil {
-			fmt.Fprintln(os.Stdout, _path.Join(_path.Dir(srcs[0]), fileName))
-		} else {
-			bar = ctx.pool.Get()
-			bar.SetTotal64(totalSize).Prefix(_path.Join(_path.Dir(srcs[0]), fileName)).SetUnits(pb.U_BYTES).Start()
-			defer func() {
-				bar.Finish()
-				ctx.pool.Put(bar)
-			}()
-		}
-
-		var w io.Writer = tarWriter
-		if bar != nil {
-			w = io.MultiWriter(tarWriter, bar)
-		}
-
-		for _, src := range srcs {
-			srcFile, err := ctx.n.ReadFile(src)
-			if err != nil {
-				return err
-			}
-
-			_, err = io.Copy(w, srcFile)
-
-			srcFile.Close()
-			if err != nil {
-				return err
-			}
-		}
-		return err
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
 	}
 
-	var n int
-	var err error
-	for n, err = 0, try(); err != nil && ctx.retry > n; n, err = n+1, try() {
-		fmt.Println("error! retry after " + ctx.delay.String() + "...")
-		fmt.Println("  " + err.Error() + "\n")
-		time.Sleep(ctx.delay)
-	}
-	return err
+	return newTarBackend(f), nil
 }
@@ -0,0 +1,96 @@
+package get
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/backend"
+)
+
+var errNotSupported = errors.New("not supported by tar")
+
+// tarBackend は tar ファイルへの書き込みだけができる backend.Backend
+type tarBackend struct {
+	f *os.File
+	w *tar.Writer
+}
+
+func newTarBackend(f *os.File) *tarBackend {
+	return &tarBackend{f: f, w: tar.NewWriter(f)}
+}
+
+// Stat は常に存在しないものとする。同じ tar に同じファイルを書くことはない
+func (b *tarBackend) Stat(ctx context.Context, path string) (os.FileInfo, error) {
+	return nil, &os.PathError{Op: "Stat", Path: path, Err: os.ErrNotExist}
+}
+
+func (b *tarBackend) ReadDir(ctx context.Context, path string) ([]os.FileInfo, error) {
+	return nil, &os.PathError{Op: "ReadDir", Path: path, Err: errNotSupported}
+}
+
+func (b *tarBackend) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	return nil, &os.PathError{Op: "Open", Path: path, Err: errNotSupported}
+}
+
+func (b *tarBackend) OpenRange(ctx context.Context, path string, offset int64, length int64) (io.ReadCloser, error) {
+	return nil, &os.PathError{Op: "OpenRange", Path: path, Err: errNotSupported}
+}
+
+func (b *tarBackend) Create(ctx context.Context, path string, fi os.FileInfo, exclusive bool) (backend.Writer, error) {
+	header := &tar.Header{
+		Name:    path,
+		Mode:    int64(fi.Mode()),
+		ModTime: fi.ModTime(),
+		Size:    fi.Size(),
+	}
+
+	if err := b.w.WriteHeader(header); err != nil {
+		return nil, err
+	}
+
+	return &tarWriter{w: b.w}, nil
+}
+
+// MkdirAll は何もしない。tar にはファイルだけを書き込む
+func (b *tarBackend) MkdirAll(ctx context.Context, path string) error {
+	return nil
+}
+
+func (b *tarBackend) Remove(ctx context.Context, path string) error {
+	return &os.PathError{Op: "Remove", Path: path, Err: errNotSupported}
+}
+
+func (b *tarBackend) Close() error {
+	if err := b.w.Close(); err != nil {
+		b.f.Close()
+		return err
+	}
+
+	return b.f.Close()
+}
+
+// Abort は書きかけの tar ファイルを消す
+func (b *tarBackend) Abort() error {
+	b.f.Close()
+	return os.Remove(b.f.Name())
+}
+
+type tarWriter struct {
+	w *tar.Writer
+}
+
+func (w *tarWriter) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+func (w *tarWriter) Close() error {
+	return w.w.Flush()
+}
+
+// Abort はできない。書いてしまったヘッダーは取り消せないので、リトライもしない
+func (w *tarWriter) Abort() error {
+	return errors.New("cannot abort writing to tar")
+}
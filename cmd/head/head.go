@@ -0,0 +1,134 @@
+package head
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/nextcloud"
+)
+
+// 行数で読むときに一度に Range リクエストで取得するバイト数
+const blockSize = 64 * 1024
+
+type ctx struct {
+	n *nextcloud.Nextcloud // Nextcloud クライアント
+
+	lines int64 // 先頭から表示する行数
+	bytes int64 // 先頭から表示するバイト数。負なら lines を使う
+}
+
+type Option func(*ctx) error
+
+func Lines(n int64) Option {
+	return func(ctx *ctx) error {
+		if n < 0 {
+			return fmt.Errorf("invalid number of lines: %d", n)
+		}
+
+		ctx.lines = n
+		return nil
+	}
+}
+
+func Bytes(n int64) Option {
+	return func(ctx *ctx) error {
+		ctx.bytes = n
+		return nil
+	}
+}
+
+func Do(n *nextcloud.Nextcloud, opts []Option, paths []string) error {
+	ctx := &ctx{
+		n: n,
+
+		lines: 10,
+		bytes: -1,
+	}
+
+	for _, opt := range opts {
+		if err := opt(ctx); err != nil {
+			return err
+		}
+	}
+
+	for i, path := range paths {
+		if len(paths) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Println("==> " + path + " <==")
+		}
+
+		if err := head(ctx, path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func head(ctx *ctx, path string) error {
+	f, err := ctx.n.OpenJoined(path)
+	if err != nil {
+		return err
+	}
+
+	if ctx.bytes >= 0 {
+		r := f.ReadRange(0, ctx.bytes)
+		defer r.Close()
+
+		_, err := io.Copy(os.Stdout, r)
+		return err
+	}
+
+	// 必要な行数が揃うまで先頭から少しずつ取得する
+	lines := ctx.lines
+	for offset := int64(0); lines > 0 && offset < f.Size(); offset += blockSize {
+		block, err := readBlock(f, offset, blockSize)
+		if err != nil {
+			return err
+		}
+
+		for lines > 0 {
+			i := bytes.IndexByte(block, '\n')
+			if i < 0 {
+				break
+			}
+
+			if _, err := os.Stdout.Write(block[:i+1]); err != nil {
+				return err
+			}
+
+			block = block[i+1:]
+			lines--
+		}
+
+		if lines > 0 {
+			if _, err := os.Stdout.Write(block); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func readBlock(f *nextcloud.JoinedFile, offset int64, length int64) ([]byte, error) {
+	r := f.ReadRange(offset, length)
+	defer r.Close()
+
+	block, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(block) == 0 {
+		return nil, errors.New("unexpected: file was truncated while reading")
+	}
+
+	return block, nil
+}
@@ -0,0 +1,88 @@
+package mkdir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/nextcloud"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/retry"
+)
+
+type ctx struct {
+	n *nextcloud.Nextcloud // Nextcloud クライアント
+
+	parents bool // 親ディレクトリも作る。すでに存在していてもエラーにしない
+	verbose bool // 作ったものを報告する
+}
+
+type Option func(*ctx) error
+
+func Parents(b bool) Option {
+	return func(ctx *ctx) error {
+		ctx.parents = b
+		return nil
+	}
+}
+
+func Verbose(b bool) Option {
+	return func(ctx *ctx) error {
+		ctx.verbose = b
+		return nil
+	}
+}
+
+func Do(n *nextcloud.Nextcloud, opts []Option, dirs []string) error {
+	ctx := &ctx{
+		n: n,
+
+		parents: false,
+		verbose: false,
+	}
+
+	for _, opt := range opts {
+		if err := opt(ctx); err != nil {
+			return err
+		}
+	}
+
+	for _, dir := range dirs {
+		if err := mkdir(ctx, dir); err != nil {
+			return fmt.Errorf("cannot create directory '%v': %w", dir, err)
+		}
+	}
+
+	return nil
+}
+
+func mkdir(ctx *ctx, dir string) error {
+	if !ctx.parents {
+		// 送りなおした MKCOL が 405 になったときは、前に送ったもので作られているので作れたものとする
+		c, retried := retry.Retried(context.Background())
+		if err := ctx.n.MkdirContext(c, dir); err != nil && !(errors.Is(err, os.ErrExist) && retried()) {
+			return err
+		}
+
+		if ctx.verbose {
+			fmt.Printf("created directory '%v'\n", dir)
+		}
+		return nil
+	}
+
+	// MkdirAll はすでに存在していても成功するので、作ったかどうかは先に調べておく
+	_, err := ctx.n.Stat(dir)
+	exists := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := ctx.n.MkdirAll(dir); err != nil {
+		return err
+	}
+
+	if ctx.verbose && !exists {
+		fmt.Printf("created directory '%v'\n", dir)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package mkdir
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/nextcloud"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/retry"
+)
+
+// fakeServer は MKCOL だけに答えるサーバー
+// 最初の MKCOL は作ってから mkcolStatus を返す。作った後の MKCOL は 405 を返す
+type fakeServer struct {
+	m           sync.Mutex
+	exists      bool
+	mkcolStatus int
+	mkcols      int
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if r.Method != "MKCOL" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mkcols++
+	if s.exists {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.exists = true
+	w.WriteHeader(s.mkcolStatus)
+}
+
+func newNextcloud(t *testing.T, s *fakeServer, max int) *nextcloud.Nextcloud {
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	client := &http.Client{Transport: &retry.Transport{Policy: retry.Policy{Max: max}}}
+	return nextcloud.New(srv.URL+"/", client, nil)
+}
+
+func TestRetriedMkcol(t *testing.T) {
+	// 一回目で作られていれば、送りなおした MKCOL が 405 になっても作れたものとする
+	s := &fakeServer{mkcolStatus: http.StatusGatewayTimeout}
+	if err := Do(newNextcloud(t, s, 2), nil, []string{"/dir"}); err != nil {
+		t.Errorf("error = %v, want nil", err)
+	}
+	if s.mkcols != 2 {
+		t.Errorf("sent %d MKCOL, want 2", s.mkcols)
+	}
+
+	// 送りなおしていない MKCOL の 405 は、既にあるので失敗にする
+	s = &fakeServer{exists: true}
+	err := Do(newNextcloud(t, s, 2), nil, []string{"/dir"})
+	if !errors.Is(err, os.ErrExist) {
+		t.Errorf("error = %v, want os.ErrExist", err)
+	}
+	if s.mkcols != 1 {
+		t.Errorf("sent %d MKCOL, want 1", s.mkcols)
+	}
+
+	// リトライしないときは 504 のまま失敗する
+	s = &fakeServer{mkcolStatus: http.StatusGatewayTimeout}
+	if err := Do(newNextcloud(t, s, 0), nil, []string{"/dir"}); err == nil {
+		t.Error("error = nil, want an error")
+	}
+}
@@ -0,0 +1,317 @@
+package put
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	_path "path"
+	"time"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/nextcloud"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/webdav"
+	"github.com/thamaji/pbpool"
+	"golang.org/x/crypto/ssh/terminal"
+	"gopkg.in/cheggaaa/pb.v1"
+)
+
+// Stdin は標準入力から読むときに LOCAL の代わりに指定するもの
+const Stdin = "-"
+
+type ctx struct {
+	n *nextcloud.Nextcloud // Nextcloud クライアント
+
+	pool *pbpool.Pool // プログレスバーのプール
+
+	deconflictStrategy int // ファイルが衝突したときの処理方法
+
+	chunkSize int64 // これより大きいものは chunked upload で送る。送り直せるようにこのバイト数までメモリに持つ
+}
+
+type Option func(*ctx) error
+
+const (
+	DeconflictError     = "error"
+	DeconflictSkip      = "skip"
+	DeconflictOverwrite = "overwrite"
+	DeconflictNewest    = "newest"
+	DeconflictLarger    = "larger"
+)
+
+func DeconflictStrategy(strategy string) Option {
+	return func(ctx *ctx) error {
+		switch strategy {
+		case DeconflictError:
+			ctx.deconflictStrategy = 0
+
+		case DeconflictSkip:
+			ctx.deconflictStrategy = 1
+
+		case DeconflictOverwrite:
+			ctx.deconflictStrategy = 2
+
+		case DeconflictNewest:
+			ctx.deconflictStrategy = 3
+
+		case DeconflictLarger:
+			ctx.deconflictStrategy = 4
+
+		default:
+			return errors.New("invalid strategy: " + strategy)
+		}
+
+		return nil
+	}
+}
+
+func ChunkSize(size string) Option {
+	return func(ctx *ctx) error {
+		var bytesize datasize.ByteSize
+		if err := bytesize.UnmarshalText([]byte(size)); err != nil {
+			return fmt.Errorf("invalid chunk size: %s", size)
+		}
+
+		if bytesize.Bytes() <= 0 {
+			return fmt.Errorf("invalid chunk size: %s", size)
+		}
+
+		ctx.chunkSize = int64(bytesize.Bytes())
+		return nil
+	}
+}
+
+func Do(n *nextcloud.Nextcloud, opts []Option, src string, dst string) error {
+	ctx := &ctx{
+		n: n,
+
+		pool: nil,
+
+		deconflictStrategy: 0,
+
+		chunkSize: 10 * 1024 * 1024,
+	}
+
+	for _, opt := range opts {
+		if err := opt(ctx); err != nil {
+			return err
+		}
+	}
+
+	var r io.Reader
+	size := int64(-1) // 標準入力のときは不明
+	modTime := time.Now()
+
+	if src == Stdin {
+		r = os.Stdin
+
+	} else {
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			return errors.New("local path is a directory: " + src)
+		}
+
+		r = f
+		size = fi.Size()
+		modTime = fi.ModTime()
+	}
+
+	switch fi, err := ctx.n.Stat(dst); {
+	case err == nil && fi.IsDir():
+		return errors.New("remote path is a directory: " + dst)
+
+	case err == nil:
+		switch ctx.deconflictStrategy {
+		case 0: // DeconflictError
+			return fmt.Errorf("remote file already exists: %s: %w", dst, os.ErrExist)
+
+		case 1: // DeconflictSkip
+			fmt.Println("skip already exists file: " + dst)
+			return nil
+
+		case 2: // DeconflictOverwrite
+
+		case 3: // DeconflictNewest
+			if !modTime.After(fi.ModTime()) {
+				fmt.Println("skip older file: " + dst)
+				return nil
+			}
+
+		case 4: // DeconflictLarger
+			if size < 0 {
+				return errors.New("deconflict strategy larger cannot be used with stdin")
+			}
+
+			if size <= fi.Size() {
+				fmt.Println("skip not larger file: " + dst)
+				return nil
+			}
+		}
+
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	if err := ctx.n.MkdirAll(_path.Dir(dst)); err != nil {
+		return err
+	}
+
+	if terminal.IsTerminal(int(os.Stdout.Fd())) {
+		ctx.pool = pbpool.New()
+	}
+
+	var bar *pbpool.ProgressBar
+
+	if ctx.pool == nil {
+		fmt.Fprintln(os.Stdout, dst)
+	} else {
+		ctx.pool.Start()
+		defer func() {
+			ctx.pool.Update()
+			ctx.pool.Stop()
+		}()
+
+		bar = ctx.pool.Get()
+		if size >= 0 {
+			bar.SetTotal64(size)
+		}
+		bar.Prefix(dst)
+		bar.SetUnits(pb.U_BYTES)
+		bar.Start()
+		defer func() {
+			bar.Finish()
+			ctx.pool.Put(bar)
+		}()
+	}
+
+	return put(ctx, bufio.NewReaderSize(r, 64*1024), dst, bar)
+}
+
+// put は r を dst に書き込む
+// chunkSize までに読み終われば一度の PUT で、そうでなければ chunked upload で送る
+func put(ctx *ctx, r *bufio.Reader, dst string, bar *pbpool.ProgressBar) error {
+	buf := make([]byte, ctx.chunkSize)
+	written := int64(0)
+
+	chunk, last, err := readChunk(r, buf)
+	if err != nil {
+		return err
+	}
+
+	// 衝突したらエラーにするときは、存在しないときだけ書き込むことで他からの書き込みとの競合も検出する
+	overwrite := ctx.deconflictStrategy != 0
+
+	if last {
+		conds := []webdav.Condition{}
+		if !overwrite {
+			conds = append(conds, webdav.IfNoneMatch("*"))
+		}
+
+		err := ctx.n.WriteFile(dst, newProgressReader(chunk, bar, written), conds...)
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("remote file already exists: %s: %w", dst, err)
+		}
+		return err
+	}
+
+	u, err := ctx.n.NewChunkedUpload(dst)
+	if err != nil {
+		return err
+	}
+
+	for index := 1; ; index++ {
+		if err := u.WriteChunk(index, newProgressReader(chunk, bar, written)); err != nil {
+			u.Abort()
+			return err
+		}
+
+		written += int64(len(chunk))
+
+		if last {
+			break
+		}
+
+		chunk, last, err = readChunk(r, buf)
+		if err != nil {
+			u.Abort()
+			return err
+		}
+	}
+
+	if err := u.Commit(overwrite); err != nil {
+		u.Abort()
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("remote file already exists: %s: %w", dst, err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// readChunk は buf がいっぱいになるか r の終わりまで読む。r を読み終わったら last が true になる
+func readChunk(r *bufio.Reader, buf []byte) (chunk []byte, last bool, err error) {
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return buf[:n], true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if _, err := r.Peek(1); err != nil {
+		if err == io.EOF {
+			return buf[:n], true, nil
+		}
+		return nil, false, err
+	}
+
+	return buf[:n], false, nil
+}
+
+// progressReader は読んだ分だけプログレスバーを進める
+// Transport がリトライするときに先頭に戻せるように Seek もでき、戻したらプログレスバーも戻す
+type progressReader struct {
+	r      *bytes.Reader
+	bar    *pbpool.ProgressBar
+	offset int64 // このチャンクより前に送ったバイト数
+}
+
+func newProgressReader(chunk []byte, bar *pbpool.ProgressBar, offset int64) *progressReader {
+	setProgress(bar, offset)
+	return &progressReader{r: bytes.NewReader(chunk), bar: bar, offset: offset}
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if r.bar != nil {
+		r.bar.Add(n)
+	}
+	return n, err
+}
+
+func (r *progressReader) Seek(offset int64, whence int) (int64, error) {
+	n, err := r.r.Seek(offset, whence)
+	if err == nil {
+		setProgress(r.bar, r.offset+n)
+	}
+	return n, err
+}
+
+func setProgress(bar *pbpool.ProgressBar, n int64) {
+	if bar != nil {
+		bar.Set64(n)
+	}
+}
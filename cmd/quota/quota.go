@@ -0,0 +1,146 @@
+package quota
+
+import (
+	"encoding/json"
+	"os"
+	_path "path"
+	"strconv"
+
+	"github.com/kurusugawa-computer/nextcloud-cli/cmd/du"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/nextcloud"
+	"github.com/thamaji/tablewriter"
+)
+
+type ctx struct {
+	n *nextcloud.Nextcloud // Nextcloud クライアント
+
+	humanReadable bool // 1K 234M 2G のような単位付きで表示する
+	json          bool // JSON で出力する
+}
+
+type Option func(*ctx) error
+
+func HumanReadable(b bool) Option {
+	return func(ctx *ctx) error {
+		ctx.humanReadable = b
+		return nil
+	}
+}
+
+func JSON(b bool) Option {
+	return func(ctx *ctx) error {
+		ctx.json = b
+		return nil
+	}
+}
+
+// Usage はアカウントまたは外部ストレージの使用量
+// 無制限や不明のとき Available と Total は nil になる
+type Usage struct {
+	Path      string `json:"path"`
+	MountType string `json:"mount_type"`
+	Used      int64  `json:"used"`
+	Available *int64 `json:"available"`
+	Total     *int64 `json:"total"`
+}
+
+func Do(n *nextcloud.Nextcloud, opts []Option) error {
+	ctx := &ctx{
+		n: n,
+
+		humanReadable: false,
+		json:          false,
+	}
+
+	for _, opt := range opts {
+		if err := opt(ctx); err != nil {
+			return err
+		}
+	}
+
+	root, err := ctx.n.Stat("/")
+	if err != nil {
+		return err
+	}
+
+	usages := []*Usage{usage("/", root)}
+
+	// 外部ストレージはマウントポイントごとに容量が異なる
+	fl, err := ctx.n.ReadDir("/")
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range fl {
+		nfi, ok := fi.(*nextcloud.FileInfo)
+		if !ok || nfi.MountType() != "external" {
+			continue
+		}
+
+		usages = append(usages, usage(_path.Join("/", fi.Name()), fi))
+	}
+
+	if ctx.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(usages)
+	}
+
+	writer := tablewriter.New(os.Stdout)
+	writer.SetAligns(tablewriter.AlignLeft, tablewriter.AlignRight, tablewriter.AlignRight, tablewriter.AlignRight, tablewriter.AlignRight)
+	writer.Add("Mounted on", "Size", "Used", "Avail", "Use%")
+	for _, usage := range usages {
+		size, avail, percent := "-", "-", "-"
+		if usage.Total != nil {
+			size = formatSize(ctx, *usage.Total)
+			if *usage.Total > 0 {
+				percent = strconv.FormatInt((usage.Used*100+*usage.Total-1) / *usage.Total, 10) + "%"
+			}
+		}
+		if usage.Available != nil {
+			avail = formatSize(ctx, *usage.Available)
+		}
+
+		writer.Add(usage.Path, size, formatSize(ctx, usage.Used), avail, percent)
+	}
+	writer.Flush()
+
+	return nil
+}
+
+func usage(path string, fi os.FileInfo) *Usage {
+	usage := &Usage{
+		Path:      path,
+		MountType: "",
+		Used:      fi.Size(),
+		Available: nil,
+		Total:     nil,
+	}
+
+	nfi, ok := fi.(*nextcloud.FileInfo)
+	if !ok {
+		return usage
+	}
+
+	usage.MountType = nfi.MountType()
+
+	usage.Used = nfi.QuotaUsedBytes()
+	if usage.Used < 0 {
+		usage.Used = nfi.TotalSize()
+	}
+
+	if available := nfi.QuotaAvailableBytes(); available >= 0 {
+		total := usage.Used + available
+		usage.Available = &available
+		usage.Total = &total
+	}
+
+	return usage
+}
+
+func formatSize(ctx *ctx, size int64) string {
+	if ctx.humanReadable {
+		return du.FormatSize(size)
+	}
+	return strconv.FormatInt(size, 10)
+}
@@ -1,27 +1,37 @@
 package rm
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io/fs"
+	"io"
 	"os"
 	"path"
 	"strings"
 	"time"
 
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/events"
 	"github.com/kurusugawa-computer/nextcloud-cli/lib/nextcloud"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/retry"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
 type ctx struct {
 	n *nextcloud.Nextcloud // Nextcloud クライアント
 
-	retry int           // リトライ回数
-	delay time.Duration // リトライ時のディレイ
+	context context.Context // キャンセルされたら残りを消さずに終了する
 
 	recursive bool // ディレクトリとその中身を再帰的に削除
 	force     bool // 操作の際に確認を取らない
 	verbose   bool // 消したものを報告する
+
+	events *events.Writer // 進捗を JSON で書き出す。nil なら書き出さない
+	out    io.Writer      // 確認や消したものの報告など、人が読むメッセージの出力先
+
+	failureList  string              // 消せなかったものを書き出すファイル。空なら書き出さない
+	targetName   func(string) string // 消せなかったものを書き出すときに、パスを変換する
+	failures     []string            // 消せなかったもの
+	firstFailure error               // 最初に消せなかったときのエラー
 }
 
 type Option func(*ctx) error
@@ -32,19 +42,27 @@ func (e *ErrUserRefused) Error() string {
 	return "the user refused to delete"
 }
 
-func Retry(n int, delay time.Duration) Option {
-	return func(ctx *ctx) error {
-		if n < 0 {
-			return fmt.Errorf("invalid retry count: %d", n)
-		}
+// ErrFailed は消せなかったものがあったときに返すエラー
+// 最初に消せなかったときのエラーを Unwrap で返す
+type ErrFailed struct {
+	Targets []string // 消せなかったもの
+	Err     error    // 最初に消せなかったときのエラー
+}
 
-		if delay < 0 {
-			return fmt.Errorf("invalid delay: %s", delay)
-		}
+func (e *ErrFailed) Error() string {
+	return fmt.Sprintf("failed to remove %d files", len(e.Targets))
+}
+
+func (e *ErrFailed) Unwrap() error {
+	return e.Err
+}
 
-		ctx.retry = n
-		ctx.delay = delay
+// errNotEmpty は中身を消せなかったので、ディレクトリを消さなかったことを表す
+var errNotEmpty = errors.New("directory not empty")
 
+func Context(c context.Context) Option {
+	return func(ctx *ctx) error {
+		ctx.context = c
 		return nil
 	}
 }
@@ -70,13 +88,46 @@ func Verbose(b bool) Option {
 	}
 }
 
+// Events は消したものや失敗したものを w に JSON で書き出す。人が読むメッセージは標準エラー出力に出す
+func Events(w *events.Writer) Option {
+	return func(ctx *ctx) error {
+		ctx.events = w
+		if w != nil {
+			ctx.out = os.Stderr
+		}
+		return nil
+	}
+}
+
+// FailureList は終わったときに、消せなかったものを一行に一つずつ path に書き出す。消せなかったものがなければ空のファイルにする
+func FailureList(path string) Option {
+	return func(ctx *ctx) error {
+		ctx.failureList = path
+		return nil
+	}
+}
+
+// TargetName は消せなかったものを書き出すときに、パスを name で変換する
+// "work:/path" のように接続先を付けて、--files-from で同じところを消せるようにするのに使う
+func TargetName(name func(path string) string) Option {
+	return func(ctx *ctx) error {
+		ctx.targetName = name
+		return nil
+	}
+}
+
+// Do は targets を消す。消せなかったものがあっても残りを消してから ErrFailed を返す
 func Do(n *nextcloud.Nextcloud, opts []Option, targets []string) error {
 	ctx := &ctx{
-		n:         n,
-		retry:     3,
-		delay:     30 * time.Second,
-		recursive: false,
-		force:     false,
+		n:           n,
+		context:     context.Background(),
+		recursive:   false,
+		force:       false,
+		events:      nil,
+		out:         os.Stdout,
+		failureList: "",
+		targetName:  func(path string) string { return path },
+		failures:    []string{},
 	}
 
 	for _, opt := range opts {
@@ -90,19 +141,73 @@ func Do(n *nextcloud.Nextcloud, opts []Option, targets []string) error {
 	}
 
 	for _, target := range targets {
-		if err := remove(ctx, target); err != nil {
-			fmt.Printf("%v", err.Error())
+		if ctx.context.Err() != nil {
+			break
 		}
+
+		remove(ctx, target)
 	}
 
-	return nil
+	err := ctx.context.Err()
+	if err == nil && len(ctx.failures) > 0 {
+		err = &ErrFailed{Targets: ctx.failures, Err: ctx.firstFailure}
+	}
+
+	ctx.events.Summary(err)
+
+	if ctx.failureList != "" {
+		if err1 := writeFailureList(ctx); err1 != nil && err == nil {
+			err = err1
+		}
+	}
+
+	return err
 }
 
-func askYesOrNo(format string, a ...interface{}) bool {
-	fmt.Printf(format+" y/[n]: ", a...)
-	var response string
-	_, err := fmt.Fscanln(os.Stdin, &response)
+// fail は target を消せなかったことを記録して、エラーを表示する
+func fail(ctx *ctx, target string, err error) {
+	ctx.failures = append(ctx.failures, target)
+	if ctx.firstFailure == nil {
+		ctx.firstFailure = err
+	}
+
+	fmt.Fprintf(os.Stderr, "cannot remove '%v': %v\n", target, err.Error())
+	ctx.events.Failed(target, err)
+}
+
+// writeFailureList は消せなかったものを ctx.failureList に書き出す
+func writeFailureList(ctx *ctx) error {
+	f, err := os.Create(ctx.failureList)
 	if err != nil {
+		return err
+	}
+
+	for _, target := range ctx.failures {
+		if _, err := fmt.Fprintln(f, ctx.targetName(target)); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	return f.Close()
+}
+
+func askYesOrNo(ctx *ctx, format string, a ...interface{}) bool {
+	fmt.Fprintf(ctx.out, format+" y/[n]: ", a...)
+	var response string
+	// 入力を待っている間に中断されたら no とみなす
+	done := make(chan error, 1)
+	go func() {
+		_, err := fmt.Fscanln(os.Stdin, &response)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return false
+		}
+	case <-ctx.context.Done():
+		fmt.Fprintln(ctx.out)
 		return false
 	}
 	response = strings.ToLower(strings.TrimSpace(response))
@@ -112,94 +217,43 @@ func askYesOrNo(format string, a ...interface{}) bool {
 	return false
 }
 
-func remove(ctx *ctx, target string) error {
-	var fi fs.FileInfo
-	var err error
-	n := 0
-	for {
-		fi, err = ctx.n.Stat(target)
-		if err == nil {
-			break
-		}
-		if errors.Is(err, os.ErrNotExist) {
-			return fmt.Errorf("cannot remove '%v': %w", target, err)
-		}
-		n++
-		if ctx.retry > 0 && ctx.retry > n {
-			fmt.Println("error! retry after " + ctx.delay.String() + "...")
-			fmt.Println("  " + err.Error())
-			time.Sleep(ctx.delay)
-			continue
-		}
-		return fmt.Errorf("cannot remove '%v': %w", target, err)
+func remove(ctx *ctx, target string) {
+	fi, err := ctx.n.StatContext(ctx.context, target)
+	if err != nil {
+		fail(ctx, target, err)
+		return
 	}
 	if fi.IsDir() {
-		err = removeDir(ctx, target)
+		removeDir(ctx, target)
 	} else {
-		err = removeFile(ctx, target)
-	}
-	if err != nil && errors.Is(err, &ErrUserRefused{}) {
-		return err
-	}
-	return nil
-}
-
-func retryReadDir(ctx *ctx, target string) ([]os.FileInfo, error) {
-	var fis []fs.FileInfo
-	var err error
-	n := 0
-	for {
-		fis, err = ctx.n.ReadDir(target)
-		if err == nil {
-			return fis, nil
-		}
-		n++
-		if ctx.retry > 0 && ctx.retry > n {
-			fmt.Println("error! retry after " + ctx.delay.String() + "...")
-			fmt.Println("  " + err.Error())
-			time.Sleep(ctx.delay)
-			continue
-		}
-		return nil, err
-	}
-}
-
-func retryDelete(ctx *ctx, target string) error {
-	n := 0
-	for {
-		err := ctx.n.Delete(target)
-		if err == nil {
-			return nil
-		}
-		n++
-		if ctx.retry > 0 && ctx.retry > n {
-			fmt.Println("error! retry after " + ctx.delay.String() + "...")
-			fmt.Println("  " + err.Error())
-			time.Sleep(ctx.delay)
-			continue
-		}
-		return err
+		removeFile(ctx, target)
 	}
 }
 
+// removeDir は target を中身ごと消す。消さなかったときはエラーを返す
+// 中身を一つでも消さなかったら、target も消さない
 func removeDir(ctx *ctx, target string) error {
 	if !ctx.recursive {
-		fmt.Printf("cannot remove '%v': Is a directory\n", target)
+		fmt.Fprintf(ctx.out, "cannot remove '%v': Is a directory\n", target)
+		ctx.events.Skipped(target, events.SkipDirectory)
 		return nil
 	}
 
 	var fis []os.FileInfo
 	var err error
-	if fis, err = retryReadDir(ctx, target); err != nil {
-		fmt.Printf("cannot remove '%v': %v\n", target, err.Error())
-		return nil
+	if fis, err = ctx.n.ReadDirContext(ctx.context, target); err != nil {
+		fail(ctx, target, err)
+		return err
 	}
 
 	remainingContentsCount := 0
 
 	if len(fis) != 0 {
-		if ctx.force || askYesOrNo("descend into directory '%v'?", target) {
+		if ctx.force || askYesOrNo(ctx, "descend into directory '%v'?", target) {
 			for _, fi := range fis {
+				if ctx.context.Err() != nil {
+					return ctx.context.Err()
+				}
 				tTarget := path.Join(target, fi.Name())
 				if fi.IsDir() {
 					if err := removeDir(ctx, tTarget); err != nil {
@@ -215,31 +269,51 @@ func removeDir(ctx *ctx, target string) error {
 	}
 
 	if remainingContentsCount != 0 {
-		return nil
+		return errNotEmpty
 	}
 
-	if !(ctx.force || askYesOrNo("remove directory '%v'?", target)) {
+	if !(ctx.force || askYesOrNo(ctx, "remove directory '%v'?", target)) {
+		ctx.events.Skipped(target, events.SkipRefused)
 		return &ErrUserRefused{}
 	}
 
-	if err := retryDelete(ctx, target); err != nil {
-		return fmt.Errorf("cannot remove '%v': %w", target, err)
+	start := time.Now()
+	if err := deleteTarget(ctx, target); err != nil {
+		fail(ctx, target, err)
+		return err
 	}
 	if ctx.verbose {
-		fmt.Printf("removed directory '%v'\n", target)
+		fmt.Fprintf(ctx.out, "removed directory '%v'\n", target)
 	}
+	ctx.events.Finished(target, "", 0, "", time.Since(start))
 	return nil
 }
 
+// removeFile は target を消す。消さなかったときはエラーを返す
 func removeFile(ctx *ctx, target string) error {
-	if !(ctx.force || askYesOrNo("remove file '%v'?", target)) {
+	if !(ctx.force || askYesOrNo(ctx, "remove file '%v'?", target)) {
+		ctx.events.Skipped(target, events.SkipRefused)
 		return &ErrUserRefused{}
 	}
-	if err := retryDelete(ctx, target); err != nil {
-		return fmt.Errorf("cannot remove '%v': %w", target, err)
+	start := time.Now()
+	if err := deleteTarget(ctx, target); err != nil {
+		fail(ctx, target, err)
+		return err
 	}
 	if ctx.verbose {
-		fmt.Printf("removed '%v'\n", target)
+		fmt.Fprintf(ctx.out, "removed '%v'\n", target)
 	}
+	ctx.events.Finished(target, "", 0, "", time.Since(start))
 	return nil
 }
+
+// deleteTarget は target を消す
+// 送りなおした DELETE が 404 になったときは、前に送ったもので消えているので消せたものとする
+func deleteTarget(ctx *ctx, target string) error {
+	c, retried := retry.Retried(ctx.context)
+	err := ctx.n.DeleteContext(c, target)
+	if err != nil && errors.Is(err, os.ErrNotExist) && retried() {
+		return nil
+	}
+	return err
+}
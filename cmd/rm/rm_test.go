@@ -0,0 +1,88 @@
+package rm
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/nextcloud"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/retry"
+)
+
+// fakeServer は /a.txt だけがあるサーバー
+// 最初の DELETE は消してから deleteStatus を返す。消した後の DELETE は 404 を返す
+type fakeServer struct {
+	m            sync.Mutex
+	exists       bool
+	deleteStatus int
+	deletes      int
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if r.URL.Path != "/a.txt" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "PROPFIND":
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(`<?xml version="1.0"?><d:multistatus xmlns:d="DAV:"><d:response><d:href>/a.txt</d:href>` +
+			`<d:propstat><d:prop><d:resourcetype/><d:getcontentlength>1</d:getcontentlength></d:prop>` +
+			`<d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response></d:multistatus>`))
+
+	case http.MethodDelete:
+		s.deletes++
+		if !s.exists {
+			http.NotFound(w, r)
+			return
+		}
+		s.exists = false
+		w.WriteHeader(s.deleteStatus)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newNextcloud(t *testing.T, s *fakeServer, max int) *nextcloud.Nextcloud {
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	client := &http.Client{Transport: &retry.Transport{Policy: retry.Policy{Max: max}}}
+	return nextcloud.New(srv.URL+"/", client, nil)
+}
+
+func TestRetriedDelete(t *testing.T) {
+	// 一回目で消えていれば、送りなおした DELETE が 404 になっても消せたものとする
+	s := &fakeServer{exists: true, deleteStatus: http.StatusBadGateway}
+	if err := Do(newNextcloud(t, s, 2), []Option{Force(true)}, []string{"/a.txt"}); err != nil {
+		t.Errorf("error = %v, want nil", err)
+	}
+	if s.deletes != 2 {
+		t.Errorf("sent %d DELETE, want 2", s.deletes)
+	}
+
+	// 送りなおしていない DELETE の 404 は失敗にする
+	s = &fakeServer{exists: false}
+	err := Do(newNextcloud(t, s, 2), []Option{Force(true)}, []string{"/a.txt"})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("error = %v, want os.ErrNotExist", err)
+	}
+	if s.deletes != 1 {
+		t.Errorf("sent %d DELETE, want 1", s.deletes)
+	}
+
+	// リトライしないときは 502 のまま失敗する
+	s = &fakeServer{exists: true, deleteStatus: http.StatusBadGateway}
+	var failed *ErrFailed
+	if err := Do(newNextcloud(t, s, 0), []Option{Force(true)}, []string{"/a.txt"}); !errors.As(err, &failed) {
+		t.Errorf("error = %v, want *ErrFailed", err)
+	}
+}
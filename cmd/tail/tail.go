@@ -0,0 +1,248 @@
+package tail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/nextcloud"
+)
+
+// 行数で読むときに一度に Range リクエストで取得するバイト数
+const blockSize = 64 * 1024
+
+type ctx struct {
+	n *nextcloud.Nextcloud // Nextcloud クライアント
+
+	lines int64 // 末尾から表示する行数
+	bytes int64 // 末尾から表示するバイト数。負なら lines を使う
+
+	follow   bool          // 追記されたものを出力し続ける
+	interval time.Duration // follow するときにファイルの変化を確認する間隔
+}
+
+type Option func(*ctx) error
+
+func Lines(n int64) Option {
+	return func(ctx *ctx) error {
+		if n < 0 {
+			return fmt.Errorf("invalid number of lines: %d", n)
+		}
+
+		ctx.lines = n
+		return nil
+	}
+}
+
+func Bytes(n int64) Option {
+	return func(ctx *ctx) error {
+		ctx.bytes = n
+		return nil
+	}
+}
+
+func Follow(b bool) Option {
+	return func(ctx *ctx) error {
+		ctx.follow = b
+		return nil
+	}
+}
+
+func SleepInterval(interval time.Duration) Option {
+	return func(ctx *ctx) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid sleep interval: %s", interval)
+		}
+
+		ctx.interval = interval
+		return nil
+	}
+}
+
+// 追記を監視しているファイルの状態
+type target struct {
+	path   string
+	offset int64  // ここまで出力した
+	etag   string // 最後に確認したときの ETag
+}
+
+func Do(n *nextcloud.Nextcloud, opts []Option, paths []string) error {
+	ctx := &ctx{
+		n: n,
+
+		lines: 10,
+		bytes: -1,
+
+		follow:   false,
+		interval: 1 * time.Second,
+	}
+
+	for _, opt := range opts {
+		if err := opt(ctx); err != nil {
+			return err
+		}
+	}
+
+	targets := make([]*target, 0, len(paths))
+
+	for i, path := range paths {
+		if len(paths) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Println("==> " + path + " <==")
+		}
+
+		target, err := tail(ctx, path)
+		if err != nil {
+			return err
+		}
+
+		targets = append(targets, target)
+	}
+
+	if !ctx.follow {
+		return nil
+	}
+
+	last := targets[len(targets)-1]
+	for {
+		time.Sleep(ctx.interval)
+
+		for _, target := range targets {
+			header := ""
+			if len(targets) > 1 && target != last {
+				header = "\n==> " + target.path + " <==\n"
+			}
+
+			written, err := follow(ctx, target, header)
+			if err != nil {
+				return err
+			}
+
+			if written {
+				last = target
+			}
+		}
+	}
+}
+
+func tail(ctx *ctx, path string) (*target, error) {
+	f, err := ctx.n.OpenJoined(path)
+	if err != nil {
+		return nil, err
+	}
+
+	size := f.Size()
+	target := &target{path: path, offset: size, etag: f.ETag()}
+
+	if ctx.bytes >= 0 {
+		offset := size - ctx.bytes
+		if offset < 0 {
+			offset = 0
+		}
+
+		r := f.ReadRange(offset, size-offset)
+		defer r.Close()
+
+		_, err := io.Copy(os.Stdout, r)
+		return target, err
+	}
+
+	if ctx.lines == 0 {
+		return target, nil
+	}
+
+	// 必要な行数が揃うまで末尾から少しずつ取得する
+	// 最後の改行はファイルの終わりなので数えない
+	buf := []byte{}
+	lines := int64(0)
+	offset := size
+	for offset > 0 {
+		length := int64(blockSize)
+		if offset < length {
+			length = offset
+		}
+		offset -= length
+
+		block, err := readBlock(f, offset, length)
+		if err != nil {
+			return nil, err
+		}
+
+		buf = append(block, buf...)
+
+		end := len(block)
+		if offset+length == size && end > 0 && block[end-1] == '\n' {
+			end--
+		}
+
+		i := bytes.LastIndexByte(block[:end], '\n')
+		for ; i >= 0; i = bytes.LastIndexByte(block[:i], '\n') {
+			lines++
+			if lines == ctx.lines {
+				// この改行より後ろが出力する範囲になる
+				buf = buf[i+1:]
+				offset = 0
+				break
+			}
+		}
+	}
+
+	_, err = os.Stdout.Write(buf)
+	return target, err
+}
+
+// follow は前回から追記された分を出力する。何か出力したら true を返す
+func follow(ctx *ctx, target *target, header string) (bool, error) {
+	f, err := ctx.n.OpenJoined(target.path)
+	if err != nil {
+		return false, err
+	}
+
+	if f.ETag() != "" && f.ETag() == target.etag {
+		return false, nil
+	}
+	target.etag = f.ETag()
+
+	size := f.Size()
+	if size < target.offset {
+		fmt.Fprintln(os.Stderr, "tail: "+target.path+": file truncated")
+		target.offset = 0
+	}
+
+	if size == target.offset {
+		return false, nil
+	}
+
+	r := f.ReadRange(target.offset, size-target.offset)
+	defer r.Close()
+
+	if header != "" {
+		fmt.Print(header)
+	}
+
+	n, err := io.Copy(os.Stdout, r)
+	target.offset += n
+	return true, err
+}
+
+func readBlock(f *nextcloud.JoinedFile, offset int64, length int64) ([]byte, error) {
+	r := f.ReadRange(offset, length)
+	defer r.Close()
+
+	block, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(block)) != length {
+		return nil, errors.New("unexpected: file was truncated while reading")
+	}
+
+	return block, nil
+}
@@ -0,0 +1,103 @@
+package touch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/nextcloud"
+)
+
+type ctx struct {
+	n *nextcloud.Nextcloud // Nextcloud クライアント
+
+	mtime    time.Time // 設定する更新日時
+	noCreate bool      // 存在しないファイルを作らない
+}
+
+type Option func(*ctx) error
+
+// 日時として受け付ける書式。上から順に試す
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// Date は更新日時を指定する。@ から始まる場合は UNIX 時間として扱う
+func Date(date string) Option {
+	return func(ctx *ctx) error {
+		if strings.HasPrefix(date, "@") {
+			sec, err := strconv.ParseInt(date[1:], 10, 64)
+			if err != nil {
+				return errors.New("invalid date format: " + date)
+			}
+
+			ctx.mtime = time.Unix(sec, 0)
+			return nil
+		}
+
+		for _, layout := range dateLayouts {
+			if t, err := time.ParseInLocation(layout, date, time.Local); err == nil {
+				ctx.mtime = t
+				return nil
+			}
+		}
+
+		return errors.New("invalid date format: " + date)
+	}
+}
+
+func NoCreate(b bool) Option {
+	return func(ctx *ctx) error {
+		ctx.noCreate = b
+		return nil
+	}
+}
+
+func Do(n *nextcloud.Nextcloud, opts []Option, paths []string) error {
+	ctx := &ctx{
+		n: n,
+
+		mtime:    time.Now(),
+		noCreate: false,
+	}
+
+	for _, opt := range opts {
+		if err := opt(ctx); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range paths {
+		if err := touch(ctx, path); err != nil {
+			return fmt.Errorf("cannot touch '%v': %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+func touch(ctx *ctx, path string) error {
+	if _, err := ctx.n.Stat(path); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		if ctx.noCreate {
+			return nil
+		}
+
+		if err := ctx.n.WriteFile(path, bytes.NewReader(nil)); err != nil {
+			return err
+		}
+	}
+
+	return ctx.n.Chtimes(path, ctx.mtime)
+}
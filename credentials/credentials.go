@@ -3,213 +3,450 @@ package credentials
 import (
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/hmac"
-	"crypto/md5"
 	"crypto/rand"
-	"encoding/base64"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"io/ioutil"
 	"os"
-	"os/user"
 	"path/filepath"
-	"strconv"
+	"runtime"
 	"time"
 
 	"github.com/thamaji/cachedir"
+	"golang.org/x/crypto/scrypt"
 )
 
-var secretkey = []byte("12345678")
+// storeVersion は保存するファイルの形式のバージョン
+// 以前の形式 (secret.key で AES-CTR) のファイルにはバージョンがないので 0 になる
+const storeVersion = 2
 
-func Clean(appname string) error {
-	dir, err := cachedir.Dir()
-	if err != nil {
-		return err
+const (
+	kdfKeyFile = "keyfile" // credential.key に保存したランダムな鍵を使う
+	kdfScrypt  = "scrypt"  // ユーザーのパスフレーズから scrypt で鍵を作る
+)
+
+// scrypt のパラメータ。ファイルにも保存するので、後から変えても古いファイルは読める
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// ErrPassphrase はパスフレーズが違うか、ファイルが壊れていてパスワードを復号できないときのエラー
+var ErrPassphrase = errors.New("failed to decrypt password: wrong passphrase or corrupted credential")
+
+type ctx struct {
+	passphrase     []byte
+	passphraseFunc func() ([]byte, error)
+	helper         string
+	skipPassword   bool
+}
+
+type Option func(*ctx) error
+
+// Passphrase は保存するときに、パスワードを passphrase から作った鍵で暗号化する
+// 指定しなければ credential.key に保存したランダムな鍵で暗号化する
+func Passphrase(passphrase []byte) Option {
+	return func(ctx *ctx) error {
+		ctx.passphrase = passphrase
+		return nil
+	}
+}
+
+// PassphraseFunc は読み込むときに、パスフレーズで暗号化されていれば f でパスフレーズを取得する
+func PassphraseFunc(f func() ([]byte, error)) Option {
+	return func(ctx *ctx) error {
+		ctx.passphraseFunc = f
+		return nil
+	}
+}
+
+// Helper は保存するときに、パスワードをファイルではなく credential helper に保存する
+// helper の指定方法は git の credential.helper と同じ
+func Helper(helper string) Option {
+	return func(ctx *ctx) error {
+		ctx.helper = helper
+		return nil
+	}
+}
+
+// SkipPassword は読み込むときに、パスワードを復号しない。URL とユーザー名だけがほしいときに使う
+func SkipPassword() Option {
+	return func(ctx *ctx) error {
+		ctx.skipPassword = true
+		return nil
+	}
+}
+
+type Password []byte
+
+func (p *Password) String() string {
+	return string(*p)
+}
+
+type Credential struct {
+	URL      string
+	Username string
+	Password Password
+	OAuth2   *OAuth2 // OAuth2 でログインしたときのトークン。nil ならパスワードでログインしている
+
+	Connection *Connection // login で指定した接続の設定。nil なら指定していない
+}
+
+// Connection は TLS やプロキシなど、サーバーへの接続の設定
+// 秘密の情報ではないので、暗号化せずにプロファイルと一緒に保存する
+type Connection struct {
+	CACert      string `json:"ca_cert,omitempty"`      // 追加で信頼する CA 証明書 (PEM) のパス
+	ClientCert  string `json:"client_cert,omitempty"`  // クライアント証明書 (PEM) のパス
+	ClientKey   string `json:"client_key,omitempty"`   // クライアント証明書の秘密鍵 (PEM) のパス
+	Insecure    bool   `json:"insecure,omitempty"`     // サーバー証明書を検証しない
+	Proxy       string `json:"proxy,omitempty"`        // プロキシの URL。空なら環境変数に従い、"direct" なら使わない
+	Timeout     string `json:"timeout,omitempty"`      // 接続と TLS ハンドシェイクのタイムアウト
+	IdleTimeout string `json:"idle_timeout,omitempty"` // 使っていない接続を閉じるまでの時間
+	MaxConns    int    `json:"max_conns,omitempty"`    // ホストごとの接続数の上限。0 なら無制限
+	HTTP2       bool   `json:"http2,omitempty"`        // HTTP/2 を使う
+}
+
+// OAuth2 は OAuth2 でログインしたときのクライアントとトークン
+type OAuth2 struct {
+	ClientID     string    `json:"client_id"`
+	ClientSecret string    `json:"client_secret"`
+	TokenURL     string    `json:"token_url"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+const authOAuth2 = "oauth2"
+
+// auth はログイン方法を返す。パスワードのときは空
+func (c *Credential) auth() string {
+	if c.OAuth2 != nil {
+		return authOAuth2
+	}
+	return ""
+}
+
+// secret は暗号化して保存するものを返す。OAuth2 のときはトークンなどを JSON にしたもの
+func (c *Credential) secret() ([]byte, error) {
+	if c.OAuth2 != nil {
+		return json.Marshal(c.OAuth2)
 	}
+	return c.Password, nil
+}
 
-	return os.RemoveAll(filepath.Join(dir, appname))
+// setSecret は secret で保存したものを c に戻す
+func (c *Credential) setSecret(auth string, secret []byte) error {
+	switch auth {
+	case "":
+		c.Password = secret
+	case authOAuth2:
+		c.OAuth2 = &OAuth2{}
+		if err := json.Unmarshal(secret, c.OAuth2); err != nil {
+			return fmt.Errorf("invalid oauth2 token: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported auth: %s", auth)
+	}
+	return nil
 }
 
-func Init(appname string) error {
+// storedCredential は保存するファイルの中身
+type storedCredential struct {
+	Version  int             `json:"version"`
+	URL      string          `json:"url"`
+	Username string          `json:"username"`
+	Auth     string          `json:"auth,omitempty"` // ログイン方法。パスワードのときは空、OAuth2 のときは "oauth2"
+	Password *sealedPassword `json:"password,omitempty"`
+	Helper   string          `json:"helper,omitempty"` // パスワードを credential helper に保存したときのヘルパー
+
+	Connection *Connection `json:"connection,omitempty"`
+}
+
+// sealedPassword は AES-256-GCM で暗号化したパスワード (OAuth2 のときはトークン)
+// URL とユーザー名を追加データにしているので、別のファイルに付け替えると復号できない
+type sealedPassword struct {
+	Cipher string `json:"cipher"`
+	KDF    string `json:"kdf"`
+	Salt   []byte `json:"salt,omitempty"`
+	N      int    `json:"n,omitempty"`
+	R      int    `json:"r,omitempty"`
+	P      int    `json:"p,omitempty"`
+	Nonce  []byte `json:"nonce"`
+	Data   []byte `json:"data"`
+}
+
+// appDir はログイン情報などを保存するディレクトリを返す
+func appDir(appname string) (string, error) {
 	dir, err := cachedir.Dir()
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	dir = filepath.Join(dir, appname)
-	path := filepath.Join(dir, "secret.key")
+	return filepath.Join(dir, appname), nil
+}
 
-	encoded, err := ioutil.ReadFile(path)
+func Clean(appname string) error {
+	dir, err := appDir(appname)
 	if err != nil {
-		if !errors.Is(err, fs.ErrNotExist) {
-			return err
+		return err
+	}
+
+	// credential helper に保存したパスワードも消す
+	profiles, _ := Profiles(appname)
+	for _, profile := range profiles {
+		stored, err := readStored(profilePath(dir, profile))
+		if err != nil || stored.Helper == "" {
+			continue
 		}
 
-		if err := os.MkdirAll(dir, 0755); err != nil {
+		if err := eraseHelper(stored.Helper, &Credential{URL: stored.URL, Username: stored.Username}); err != nil {
 			return err
 		}
+	}
+
+	return os.RemoveAll(dir)
+}
+
+func Save(appname string, credential *Credential, opts ...Option) error {
+	return SaveProfile(appname, CurrentProfile(appname), credential, opts...)
+}
+
+func Load(appname string, opts ...Option) (*Credential, error) {
+	return LoadProfile(appname, CurrentProfile(appname), opts...)
+}
 
-		secretkey = []byte(strconv.Itoa(time.Now().Nanosecond()))
+// seal はパスワードを暗号化する
+func seal(appname string, credential *Credential, passphrase []byte) (*sealedPassword, error) {
+	sealed := &sealedPassword{Cipher: "aes-256-gcm"}
 
-		u, err := user.Current()
+	var key []byte
+	if len(passphrase) > 0 {
+		sealed.KDF = kdfScrypt
+		sealed.Salt = make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, sealed.Salt); err != nil {
+			return nil, err
+		}
+		sealed.N, sealed.R, sealed.P = scryptN, scryptR, scryptP
+
+		k, err := scrypt.Key(passphrase, sealed.Salt, sealed.N, sealed.R, sealed.P, 32)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		key = k
+	} else {
+		sealed.KDF = kdfKeyFile
 
-		encoded, err := Encode(secretkey, []byte(appname+u.Username))
+		k, err := keyFile(appname, true)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		key = k
+	}
 
-		return ioutil.WriteFile(path, encoded, 0644)
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
 	}
 
-	u, err := user.Current()
+	sealed.Nonce = make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, sealed.Nonce); err != nil {
+		return nil, err
+	}
+
+	secret, err := credential.secret()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	secretkey, err = Decode(encoded, []byte(appname+u.Username))
+	sealed.Data = aead.Seal(nil, sealed.Nonce, secret, additionalData(credential.URL, credential.Username))
 
-	return err
+	return sealed, nil
 }
 
-type Password []byte
+// open は seal で暗号化したものを復号する
+func open(appname string, stored *storedCredential, passphraseFunc func() ([]byte, error)) ([]byte, error) {
+	sealed := stored.Password
+	if sealed.Cipher != "aes-256-gcm" {
+		return nil, fmt.Errorf("unsupported cipher: %s", sealed.Cipher)
+	}
 
-func (p *Password) String() string {
-	return string(*p)
-}
+	var key []byte
+	switch sealed.KDF {
+	case kdfKeyFile:
+		k, err := keyFile(appname, false)
+		if err != nil {
+			return nil, err
+		}
+		key = k
 
-func (p *Password) MarshalJSON() ([]byte, error) {
-	encoded, err := Encode(*p, secretkey)
+	case kdfScrypt:
+		if passphraseFunc == nil {
+			return nil, errors.New("passphrase is required")
+		}
+
+		passphrase, err := passphraseFunc()
+		if err != nil {
+			return nil, err
+		}
+
+		k, err := scrypt.Key(passphrase, sealed.Salt, sealed.N, sealed.R, sealed.P, 32)
+		if err != nil {
+			return nil, err
+		}
+		key = k
+
+	default:
+		return nil, fmt.Errorf("unsupported key derivation: %s", sealed.KDF)
+	}
+
+	aead, err := newGCM(key)
 	if err != nil {
 		return nil, err
 	}
 
-	return json.Marshal(string(encoded))
-}
-
-func (p *Password) UnmarshalJSON(data []byte) error {
-	var encoded string
-	if err := json.Unmarshal(data, &encoded); err != nil {
-		return err
+	if len(sealed.Nonce) != aead.NonceSize() {
+		return nil, ErrPassphrase
 	}
 
-	decoded, err := Decode([]byte(encoded), secretkey)
+	secret, err := aead.Open(nil, sealed.Nonce, sealed.Data, additionalData(stored.URL, stored.Username))
 	if err != nil {
-		return err
+		return nil, ErrPassphrase
 	}
 
-	*p = decoded
-
-	return nil
+	return secret, nil
 }
 
-type Credential struct {
-	URL      string   `json:"url"`
-	Username string   `json:"username"`
-	Password Password `json:"password"`
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
 }
 
-func Save(appname string, credential *Credential) error {
-	if err := Init(appname); err != nil {
-		return err
-	}
+func additionalData(url string, username string) []byte {
+	return []byte(url + "\n" + username)
+}
 
-	dir, err := cachedir.Dir()
+// keyFile はパスフレーズを使わないときの鍵を credential.key から読む
+// create が true でファイルがなければ、新しく作る
+func keyFile(appname string, create bool) ([]byte, error) {
+	dir, err := appDir(appname)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	dir = filepath.Join(dir, appname)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
-	}
+	path := filepath.Join(dir, "credential.key")
 
-	f, err := os.OpenFile(filepath.Join(dir, "credential.json"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		return err
-	}
+	key, err := ioutil.ReadFile(path)
+	if err == nil {
+		checkPermission(path)
 
-	err = json.NewEncoder(f).Encode(credential)
-	if err1 := f.Close(); err == nil {
-		err = err1
+		if len(key) != 32 {
+			return nil, fmt.Errorf("invalid key file: %s", path)
+		}
+		return key, nil
 	}
 
-	return err
-}
+	if !errors.Is(err, fs.ErrNotExist) || !create {
+		return nil, err
+	}
 
-func Load(appname string) (*Credential, error) {
-	if err := Init(appname); err != nil {
+	key = make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
 		return nil, err
 	}
 
-	dir, err := cachedir.Dir()
-	if err != nil {
+	if err := writeFile(path, key); err != nil {
 		return nil, err
 	}
 
-	f, err := os.OpenFile(filepath.Join(dir, appname, "credential.json"), os.O_RDONLY, 0)
+	return key, nil
+}
+
+// readStored は保存したファイルを読み込む
+func readStored(path string) (*storedCredential, error) {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	credential := Credential{}
+	checkPermission(filepath.Dir(path))
+	checkPermission(path)
 
-	err = json.NewDecoder(f).Decode(&credential)
-	if err1 := f.Close(); err == nil {
-		err = err1
+	stored := storedCredential{}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("invalid credential file %#v: %w", path, err)
 	}
 
-	return &credential, err
+	if stored.Version != storeVersion {
+		return nil, fmt.Errorf("unsupported credential file version %d: %s", stored.Version, path)
+	}
+
+	return &stored, nil
 }
 
-func Encode(value []byte, key []byte) ([]byte, error) {
-	sum := hmac.New(md5.New, key).Sum(nil)
-	k := make([]byte, hex.EncodedLen(len(sum)))
-	hex.Encode(k, sum)
+// writeFile は path を自分だけが読み書きできるファイルとして書き込む
+// 途中で失敗しても壊れたファイルが残らないように、一時ファイルに書いてから置き換える
+func writeFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
 
-	block, err := aes.NewCipher(k)
+	f, err := ioutil.TempFile(dir, ".tmp-")
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	cipherText := make([]byte, aes.BlockSize+len(value))
-	iv := cipherText[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		return nil, err
+	// TempFile は 0600 で作るが、念のため明示する
+	if err := f.Chmod(0600); err != nil && runtime.GOOS != "windows" {
+		f.Close()
+		os.Remove(f.Name())
+		return err
 	}
 
-	stream := cipher.NewCTR(block, iv)
-	stream.XORKeyStream(cipherText[aes.BlockSize:], value)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
 
-	buf := make([]byte, base64.StdEncoding.EncodedLen(len(cipherText)))
-	base64.StdEncoding.Encode(buf, cipherText)
-	return buf, nil
-}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
 
-func Decode(value []byte, key []byte) ([]byte, error) {
-	sum := hmac.New(md5.New, key).Sum(nil)
-	k := make([]byte, hex.EncodedLen(len(sum)))
-	hex.Encode(k, sum)
+	return nil
+}
 
-	block, err := aes.NewCipher(k)
-	if err != nil {
-		return nil, err
+// checkPermission は path がほかのユーザーから読めるようになっていたら警告する
+// Windows ではパーミッションで判断できないので何もしない
+func checkPermission(path string) {
+	if runtime.GOOS == "windows" {
+		return
 	}
 
-	cipherBytes := make([]byte, base64.StdEncoding.DecodedLen(len(value)))
-	n, err := base64.StdEncoding.Decode(cipherBytes, value)
+	fi, err := os.Stat(path)
 	if err != nil {
-		return nil, err
+		return
 	}
-	cipherBytes = cipherBytes[:n]
 
-	v := make([]byte, len(cipherBytes[aes.BlockSize:]))
-	stream := cipher.NewCTR(block, cipherBytes[:aes.BlockSize])
-	stream.XORKeyStream(v, cipherBytes[aes.BlockSize:])
-	return v, nil
+	if perm := fi.Mode().Perm(); perm&0077 != 0 {
+		want := os.FileMode(0600)
+		if fi.IsDir() {
+			want = 0700
+		}
+		fmt.Fprintf(os.Stderr, "warning: %s is accessible by other users (%#o), run: chmod %#o %s\n", path, perm, want, path)
+	}
 }
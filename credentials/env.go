@@ -0,0 +1,140 @@
+package credentials
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// FromEnv は環境変数からログイン情報を作る。NEXTCLOUD_URL が設定されていなければ nil を返す
+//
+//	NEXTCLOUD_URL           : Nextcloud の URL
+//	NEXTCLOUD_USER          : ユーザー名
+//	NEXTCLOUD_PASSWORD      : パスワード
+//	NEXTCLOUD_PASSWORD_FILE : パスワードを書いたファイル。NEXTCLOUD_PASSWORD がないときに使う
+//
+// ユーザー名かパスワードが設定されていなければ、URL のホストで .netrc から探す
+func FromEnv() (*Credential, error) {
+	rawURL := os.Getenv("NEXTCLOUD_URL")
+	if rawURL == "" {
+		return nil, nil
+	}
+
+	credential := &Credential{
+		URL:      rawURL,
+		Username: os.Getenv("NEXTCLOUD_USER"),
+		Password: Password(os.Getenv("NEXTCLOUD_PASSWORD")),
+	}
+
+	if len(credential.Password) <= 0 {
+		if path := os.Getenv("NEXTCLOUD_PASSWORD_FILE"); path != "" {
+			password, err := readPasswordFile(path)
+			if err != nil {
+				return nil, err
+			}
+			credential.Password = password
+		}
+	}
+
+	if err := fillFromNetrc(credential); err != nil {
+		return nil, err
+	}
+
+	if credential.Username == "" {
+		return nil, errors.New("NEXTCLOUD_USER is not set and no entry found in netrc")
+	}
+
+	if len(credential.Password) <= 0 {
+		return nil, errors.New("NEXTCLOUD_PASSWORD is not set and no entry found in netrc")
+	}
+
+	return credential, nil
+}
+
+// ReadFile は path からログイン情報を読み込む
+// ファイルは login で保存するものと違い、パスワードを平文で書いた JSON
+//
+//	{"url": "https://nextcloud.example.com/", "username": "user", "password": "pass"}
+//
+// username か password がなければ、URL のホストで .netrc から探す
+func ReadFile(path string) (*Credential, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	v := struct {
+		URL      string `json:"url"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("invalid credentials file %#v: %w", path, err)
+	}
+
+	if v.URL == "" {
+		return nil, fmt.Errorf("invalid credentials file %#v: url is not set", path)
+	}
+
+	credential := &Credential{
+		URL:      v.URL,
+		Username: v.Username,
+		Password: Password(v.Password),
+	}
+
+	if err := fillFromNetrc(credential); err != nil {
+		return nil, err
+	}
+
+	if credential.Username == "" || len(credential.Password) <= 0 {
+		return nil, fmt.Errorf("invalid credentials file %#v: username or password is not set and no entry found in netrc", path)
+	}
+
+	return credential, nil
+}
+
+// readPasswordFile はパスワードを書いたファイルを読む。末尾の改行は取り除く
+func readPasswordFile(path string) (Password, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Password(strings.TrimRight(string(data), "\r\n")), nil
+}
+
+// fillFromNetrc は credential に足りないユーザー名とパスワードを .netrc から補う
+// ユーザー名が決まっているときは、それと同じ login のものだけを使う
+func fillFromNetrc(credential *Credential) error {
+	if credential.Username != "" && len(credential.Password) > 0 {
+		return nil
+	}
+
+	u, err := url.Parse(credential.URL)
+	if err != nil {
+		return err
+	}
+
+	login, password, err := lookupNetrc(u.Hostname())
+	if err != nil {
+		return err
+	}
+
+	if credential.Username != "" && credential.Username != login {
+		return nil
+	}
+
+	if credential.Username == "" {
+		credential.Username = login
+	}
+
+	if len(credential.Password) <= 0 {
+		credential.Password = Password(password)
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package credentials
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// credential helper は git の credential helper と同じ手順で呼び出す
+// そのため git-credential-store や git-credential-osxkeychain などをそのまま使える
+//
+// helper の指定方法も git の credential.helper と同じで
+//
+//	"!" で始まるとき : 残りをシェルのコマンドとして実行する
+//	絶対パスのとき   : そのまま実行する
+//	それ以外         : "git credential-" を前に付けて実行する
+//
+// Windows には sh がないことが多いので、シェルを使うのは "!" で始まるときだけにする
+// それ以外のときは空白で区切って引数にする。git と違ってクォートは使えない
+//
+// 実行するときは引数に get / store / erase を付け、標準入力に key=value の行を渡す
+// 値に改行や NUL があると別の行を差し込めてしまうので、git と同じくそういう値は渡さない
+
+func storeHelper(helper string, credential *Credential) error {
+	_, err := runHelper(helper, "store", credential, true)
+	return err
+}
+
+func getHelper(helper string, credential *Credential) ([]byte, error) {
+	values, err := runHelper(helper, "get", credential, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if username, ok := values["username"]; ok && username != credential.Username {
+		return nil, fmt.Errorf("credential helper returned another user: %s", username)
+	}
+
+	password, ok := values["password"]
+	if !ok || password == "" {
+		return nil, errors.New("credential helper returned no password: " + helper)
+	}
+
+	return []byte(password), nil
+}
+
+func eraseHelper(helper string, credential *Credential) error {
+	_, err := runHelper(helper, "erase", credential, false)
+	return err
+}
+
+func runHelper(helper string, action string, credential *Credential, withPassword bool) (map[string]string, error) {
+	u, err := url.Parse(credential.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	input := bytes.Buffer{}
+	write := func(key string, value string) error {
+		if strings.ContainsAny(value, "\n\x00") {
+			return fmt.Errorf("credential helper: %s must not contain a newline or NUL", key)
+		}
+		fmt.Fprintf(&input, "%s=%s\n", key, value)
+		return nil
+	}
+
+	if err := write("protocol", u.Scheme); err != nil {
+		return nil, err
+	}
+	if err := write("host", u.Host); err != nil {
+		return nil, err
+	}
+	if err := write("path", strings.TrimPrefix(u.Path, "/")); err != nil {
+		return nil, err
+	}
+	if err := write("username", credential.Username); err != nil {
+		return nil, err
+	}
+	if withPassword {
+		secret, err := credential.secret()
+		if err != nil {
+			return nil, err
+		}
+		if err := write("password", string(secret)); err != nil {
+			return nil, err
+		}
+	}
+	input.WriteString("\n")
+
+	cmd, err := helperCommand(helper, action)
+	if err != nil {
+		return nil, err
+	}
+
+	output := bytes.Buffer{}
+	cmd.Stdin = &input
+	cmd.Stdout = &output
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("credential helper %#v failed: %w", helper, err)
+	}
+
+	values := map[string]string{}
+
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+
+		if i := strings.Index(line, "="); i > 0 {
+			values[line[:i]] = line[i+1:]
+		}
+	}
+
+	return values, scanner.Err()
+}
+
+// helperCommand は helper に action を付けて実行するコマンドを作る
+func helperCommand(helper string, action string) (*exec.Cmd, error) {
+	if strings.HasPrefix(helper, "!") {
+		return exec.Command("sh", "-c", strings.TrimPrefix(helper, "!")+" "+action), nil
+	}
+
+	args := strings.Fields(helper)
+	if len(args) == 0 {
+		return nil, errors.New("empty credential helper")
+	}
+	args = append(args, action)
+
+	if filepath.IsAbs(args[0]) {
+		return exec.Command(args[0], args[1:]...), nil
+	}
+
+	return exec.Command("git", append([]string{"credential-" + args[0]}, args[1:]...)...), nil
+}
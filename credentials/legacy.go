@@ -0,0 +1,150 @@
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+)
+
+// migrate は以前の形式で保存したログイン情報を、新しい形式に書き換える
+// 以前の形式はパスワードを secret.key の鍵で AES-CTR で暗号化していて、secret.key もほかのユーザーから読めた
+// すべて書き換えたら secret.key は削除する
+func migrate(appname string) error {
+	dir, err := appDir(appname)
+	if err != nil {
+		return err
+	}
+
+	keyPath := filepath.Join(dir, "secret.key")
+
+	encoded, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return err
+	}
+
+	secretkey, err := legacyDecode(encoded, []byte(appname+u.Username))
+	if err != nil {
+		return err
+	}
+
+	for _, d := range []string{dir, filepath.Join(dir, "profiles")} {
+		if err := os.Chmod(d, 0700); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	profiles, err := Profiles(appname)
+	if err != nil {
+		return err
+	}
+
+	for _, profile := range profiles {
+		if err := migrateProfile(appname, profilePath(dir, profile), secretkey); err != nil {
+			return fmt.Errorf("failed to migrate credential of profile %s: %w", profile, err)
+		}
+	}
+
+	return os.Remove(keyPath)
+}
+
+func migrateProfile(appname string, path string, secretkey []byte) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	legacy := struct {
+		Version  int             `json:"version"`
+		URL      string          `json:"url"`
+		Username string          `json:"username"`
+		Password json.RawMessage `json:"password"`
+	}{}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+
+	if legacy.Version != 0 {
+		// 書き換え済み
+		return nil
+	}
+
+	var encoded string
+	if err := json.Unmarshal(legacy.Password, &encoded); err != nil {
+		return err
+	}
+
+	password, err := legacyDecode([]byte(encoded), secretkey)
+	if err != nil {
+		return err
+	}
+
+	credential := &Credential{
+		URL:      legacy.URL,
+		Username: legacy.Username,
+		Password: password,
+	}
+
+	sealed, err := seal(appname, credential, nil)
+	if err != nil {
+		return err
+	}
+
+	data, err = json.MarshalIndent(&storedCredential{
+		Version:  storeVersion,
+		URL:      credential.URL,
+		Username: credential.Username,
+		Password: sealed,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFile(path, data)
+}
+
+// legacyDecode は以前の形式で暗号化した value を復号する
+func legacyDecode(value []byte, key []byte) ([]byte, error) {
+	sum := hmac.New(md5.New, key).Sum(nil)
+	k := make([]byte, hex.EncodedLen(len(sum)))
+	hex.Encode(k, sum)
+
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+
+	cipherBytes := make([]byte, base64.StdEncoding.DecodedLen(len(value)))
+	n, err := base64.StdEncoding.Decode(cipherBytes, value)
+	if err != nil {
+		return nil, err
+	}
+	cipherBytes = cipherBytes[:n]
+
+	if len(cipherBytes) < aes.BlockSize {
+		return nil, errors.New("invalid encoded value")
+	}
+
+	v := make([]byte, len(cipherBytes[aes.BlockSize:]))
+	stream := cipher.NewCTR(block, cipherBytes[:aes.BlockSize])
+	stream.XORKeyStream(v, cipherBytes[aes.BlockSize:])
+	return v, nil
+}
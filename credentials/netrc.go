@@ -0,0 +1,128 @@
+package credentials
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// netrcEntry は .netrc の machine (または default) 一つ分
+type netrcEntry struct {
+	machine  string // default のときは空
+	login    string
+	password string
+}
+
+// netrcPath は $NETRC があればそれを、なければ ~/.netrc を返す
+func netrcPath() (string, error) {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".netrc"), nil
+}
+
+// lookupNetrc は .netrc から host のログイン名とパスワードを探す
+// host の machine がなければ default を使う。.netrc がなければ空を返す
+func lookupNetrc(host string) (string, string, error) {
+	path, err := netrcPath()
+	if err != nil {
+		return "", "", err
+	}
+
+	entries, err := readNetrc(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", "", nil
+		}
+		return "", "", err
+	}
+
+	for _, entry := range entries {
+		if entry.machine == host {
+			return entry.login, entry.password, nil
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.machine == "" {
+			return entry.login, entry.password, nil
+		}
+	}
+
+	return "", "", nil
+}
+
+// readNetrc は .netrc を読み込む。macdef の中身は読み飛ばす
+func readNetrc(path string) ([]*netrcEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []*netrcEntry{}
+	var entry *netrcEntry
+
+	scanner := bufio.NewScanner(f)
+	macdef := false
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// macdef は空行までがマクロの定義
+		if macdef {
+			if line == "" {
+				macdef = false
+			}
+			continue
+		}
+
+		tokens := bufio.NewScanner(strings.NewReader(line))
+		tokens.Split(bufio.ScanWords)
+
+		next := func() string {
+			if tokens.Scan() {
+				return tokens.Text()
+			}
+			return ""
+		}
+
+		for tokens.Scan() {
+			switch token := tokens.Text(); {
+			case token == "machine":
+				entry = &netrcEntry{machine: next()}
+				entries = append(entries, entry)
+			case token == "default":
+				entry = &netrcEntry{}
+				entries = append(entries, entry)
+			case token == "login" && entry != nil:
+				entry.login = next()
+			case token == "password" && entry != nil:
+				entry.password = next()
+			case token == "account":
+				next()
+			case token == "macdef":
+				next()
+				macdef = true
+			case len(token) > 0 && token[0] == '#':
+				// 行末までコメント
+				for tokens.Scan() {
+				}
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,318 @@
+package credentials
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultProfile はプロファイルを指定しなかったときに使うプロファイル
+// 以前からある credential.json をそのまま使う
+const DefaultProfile = "default"
+
+var profileNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ValidProfile はプロファイル名として使えるかどうかを調べる
+// "work:path" のようにパスの前に付けて使うので、":" や "/" は使えない
+func ValidProfile(profile string) error {
+	if !profileNameRegexp.MatchString(profile) {
+		return fmt.Errorf("invalid profile name: %#v", profile)
+	}
+
+	return nil
+}
+
+func profilePath(dir string, profile string) string {
+	if profile == DefaultProfile {
+		return filepath.Join(dir, "credential.json")
+	}
+
+	return filepath.Join(dir, "profiles", profile+".json")
+}
+
+// SaveProfile は credential を profile という名前で保存する
+// パスワードは暗号化して保存する。Helper を指定したときは credential helper に保存して、ファイルには書かない
+func SaveProfile(appname string, profile string, credential *Credential, opts ...Option) error {
+	if err := ValidProfile(profile); err != nil {
+		return err
+	}
+
+	ctx := &ctx{}
+	for _, opt := range opts {
+		if err := opt(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := migrate(appname); err != nil {
+		return err
+	}
+
+	return saveProfile(appname, profile, credential, ctx)
+}
+
+// UpdateProfile は保存済みの profile を、保存したときと同じ方法で credential に書き換える
+// OAuth2 のトークンをリフレッシュしたときなどに使う。パスフレーズで暗号化していたときは PassphraseFunc で取得する
+func UpdateProfile(appname string, profile string, credential *Credential, opts ...Option) error {
+	if err := ValidProfile(profile); err != nil {
+		return err
+	}
+
+	ctx := &ctx{}
+	for _, opt := range opts {
+		if err := opt(ctx); err != nil {
+			return err
+		}
+	}
+
+	dir, err := appDir(appname)
+	if err != nil {
+		return err
+	}
+
+	stored, err := readStored(profilePath(dir, profile))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case stored.Helper != "":
+		ctx.helper = stored.Helper
+
+	case stored.Password != nil && stored.Password.KDF == kdfScrypt:
+		if ctx.passphraseFunc == nil {
+			return errors.New("passphrase is required")
+		}
+
+		passphrase, err := ctx.passphraseFunc()
+		if err != nil {
+			return err
+		}
+		ctx.passphrase = passphrase
+	}
+
+	return saveProfile(appname, profile, credential, ctx)
+}
+
+func saveProfile(appname string, profile string, credential *Credential, ctx *ctx) error {
+	dir, err := appDir(appname)
+	if err != nil {
+		return err
+	}
+
+	stored := &storedCredential{
+		Version:  storeVersion,
+		URL:      credential.URL,
+		Username: credential.Username,
+		Auth:     credential.auth(),
+
+		Connection: credential.Connection,
+	}
+
+	if ctx.helper != "" {
+		if err := storeHelper(ctx.helper, credential); err != nil {
+			return err
+		}
+		stored.Helper = ctx.helper
+	} else {
+		sealed, err := seal(appname, credential, ctx.passphrase)
+		if err != nil {
+			return err
+		}
+		stored.Password = sealed
+	}
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFile(profilePath(dir, profile), data)
+}
+
+// LoadProfile は profile という名前で保存したログイン情報を読み込む
+// 以前の形式で保存されていたら、新しい形式に書き換えてから読み込む
+func LoadProfile(appname string, profile string, opts ...Option) (*Credential, error) {
+	if err := ValidProfile(profile); err != nil {
+		return nil, err
+	}
+
+	ctx := &ctx{}
+	for _, opt := range opts {
+		if err := opt(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := migrate(appname); err != nil {
+		return nil, err
+	}
+
+	dir, err := appDir(appname)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := readStored(profilePath(dir, profile))
+	if err != nil {
+		return nil, err
+	}
+
+	credential := &Credential{
+		URL:      stored.URL,
+		Username: stored.Username,
+
+		Connection: stored.Connection,
+	}
+
+	if ctx.skipPassword {
+		return credential, nil
+	}
+
+	var secret []byte
+	switch {
+	case stored.Helper != "":
+		s, err := getHelper(stored.Helper, credential)
+		if err != nil {
+			return nil, err
+		}
+		secret = s
+
+	case stored.Password != nil:
+		s, err := open(appname, stored, ctx.passphraseFunc)
+		if err != nil {
+			return nil, err
+		}
+		secret = s
+
+	default:
+		return nil, errors.New("password is not saved: profile " + profile)
+	}
+
+	if err := credential.setSecret(stored.Auth, secret); err != nil {
+		return nil, err
+	}
+
+	return credential, nil
+}
+
+// RemoveProfile は profile のログイン情報を削除する
+// 選択中のプロファイルを削除したときは DefaultProfile に戻す
+func RemoveProfile(appname string, profile string) error {
+	if err := ValidProfile(profile); err != nil {
+		return err
+	}
+
+	dir, err := appDir(appname)
+	if err != nil {
+		return err
+	}
+
+	path := profilePath(dir, profile)
+
+	if stored, err := readStored(path); err == nil && stored.Helper != "" {
+		if err := eraseHelper(stored.Helper, &Credential{URL: stored.URL, Username: stored.Username}); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+
+	if CurrentProfile(appname) == profile {
+		if err := os.Remove(filepath.Join(dir, "profile")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HasProfile は profile のログイン情報が保存されているかどうかを返す
+func HasProfile(appname string, profile string) bool {
+	if ValidProfile(profile) != nil {
+		return false
+	}
+
+	dir, err := appDir(appname)
+	if err != nil {
+		return false
+	}
+
+	_, err = os.Stat(profilePath(dir, profile))
+	return err == nil
+}
+
+// Profiles は保存されているプロファイルの名前を名前順に返す
+func Profiles(appname string) ([]string, error) {
+	dir, err := appDir(appname)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := []string{}
+
+	if HasProfile(appname, DefaultProfile) {
+		profiles = append(profiles, DefaultProfile)
+	}
+
+	fl, err := ioutil.ReadDir(filepath.Join(dir, "profiles"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, fi := range fl {
+		profile := strings.TrimSuffix(fi.Name(), ".json")
+		if fi.IsDir() || profile == fi.Name() || ValidProfile(profile) != nil {
+			continue
+		}
+
+		profiles = append(profiles, profile)
+	}
+
+	sort.Strings(profiles)
+
+	return profiles, nil
+}
+
+// CurrentProfile は UseProfile で選択したプロファイルを返す。選択していなければ DefaultProfile を返す
+func CurrentProfile(appname string) string {
+	dir, err := appDir(appname)
+	if err != nil {
+		return DefaultProfile
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "profile"))
+	if err != nil {
+		return DefaultProfile
+	}
+
+	profile := strings.TrimSpace(string(data))
+	if ValidProfile(profile) != nil {
+		return DefaultProfile
+	}
+
+	return profile
+}
+
+// UseProfile は profile をプロファイルを指定しなかったときに使うようにする
+func UseProfile(appname string, profile string) error {
+	if !HasProfile(appname, profile) {
+		return fmt.Errorf("profile not found: %s", profile)
+	}
+
+	dir, err := appDir(appname)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(filepath.Join(dir, "profile"), []byte(profile+"\n"))
+}
@@ -3,6 +3,7 @@ module github.com/kurusugawa-computer/nextcloud-cli
 go 1.16
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/c2h5oh/datasize v0.0.0-20200825124411-48ed595a09d2
 	github.com/fatih/color v1.7.0
 	github.com/mattn/go-colorable v0.0.9 // indirect
@@ -0,0 +1,43 @@
+// Package backend はローカルのディスクや Nextcloud を、同じ操作で読み書きできるようにする
+//
+// パスはどのバックエンドでも / 区切りで扱う。ローカルのパスは filepath.ToSlash してから渡すこと
+package backend
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+type Backend interface {
+	Stat(ctx context.Context, path string) (os.FileInfo, error)
+
+	// ReadDir は path の中身を返す。順番は決まっていない
+	ReadDir(ctx context.Context, path string) ([]os.FileInfo, error)
+
+	Open(ctx context.Context, path string) (io.ReadCloser, error)
+
+	// OpenRange は path の offset から length バイトを読む。length が負ならファイルの終わりまで読む
+	OpenRange(ctx context.Context, path string, offset int64, length int64) (io.ReadCloser, error)
+
+	// Create は path に書き込む Writer を返す。fi には書き込むもののサイズや更新日時を渡す
+	// 書き込んだファイルの更新日時は fi の更新日時にする
+	// exclusive が true のときは、path がすでに存在したら os.ErrExist のエラーにする
+	// バックエンドによっては Write や Close のときにエラーになることもある
+	Create(ctx context.Context, path string, fi os.FileInfo, exclusive bool) (Writer, error)
+
+	MkdirAll(ctx context.Context, path string) error
+
+	Remove(ctx context.Context, path string) error
+}
+
+// Writer は Create で作ったファイルに書き込む
+// 最後まで書いたら Close で確定し、途中で失敗したら Abort で書いたものを捨てる
+type Writer interface {
+	io.Writer
+
+	Close() error
+
+	// Abort は書いたものを捨てる。捨てられなかったときはエラーを返すので、同じ場所に書き直してはいけない
+	Abort() error
+}
@@ -0,0 +1,103 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Local はローカルのディスク
+type Local struct{}
+
+func NewLocal() *Local {
+	return &Local{}
+}
+
+func (l *Local) Stat(ctx context.Context, path string) (os.FileInfo, error) {
+	return os.Stat(filepath.FromSlash(path))
+}
+
+func (l *Local) ReadDir(ctx context.Context, path string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(filepath.FromSlash(path))
+}
+
+func (l *Local) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	return os.Open(filepath.FromSlash(path))
+}
+
+func (l *Local) OpenRange(ctx context.Context, path string, offset int64, length int64) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.FromSlash(path))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if length < 0 {
+		return f, nil
+	}
+
+	return readCloser{Reader: io.LimitReader(f, length), Closer: f}, nil
+}
+
+func (l *Local) Create(ctx context.Context, path string, fi os.FileInfo, exclusive bool) (Writer, error) {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if exclusive {
+		flag |= os.O_EXCL
+	}
+
+	f, err := os.OpenFile(filepath.FromSlash(path), flag, fi.Mode().Perm())
+	if err != nil {
+		return nil, err
+	}
+
+	return &localWriter{File: f, mtime: fi.ModTime()}, nil
+}
+
+func (l *Local) MkdirAll(ctx context.Context, path string) error {
+	return os.MkdirAll(filepath.FromSlash(path), 0775)
+}
+
+func (l *Local) Remove(ctx context.Context, path string) error {
+	return os.Remove(filepath.FromSlash(path))
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type localWriter struct {
+	*os.File
+	mtime time.Time // 閉じた後に設定する更新日時
+}
+
+func (w *localWriter) Close() error {
+	if err := w.File.Sync(); err != nil {
+		w.File.Close()
+		return err
+	}
+
+	if err := w.File.Close(); err != nil {
+		return err
+	}
+
+	return os.Chtimes(w.File.Name(), w.mtime, w.mtime)
+}
+
+// Abort は書きかけのファイルを消す
+func (w *localWriter) Abort() error {
+	w.File.Close()
+
+	if err := os.Remove(w.File.Name()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
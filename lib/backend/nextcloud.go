@@ -0,0 +1,120 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/nextcloud"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/webdav"
+)
+
+// Nextcloud は Nextcloud のファイル
+type Nextcloud struct {
+	n *nextcloud.Nextcloud
+}
+
+func NewNextcloud(n *nextcloud.Nextcloud) *Nextcloud {
+	return &Nextcloud{n: n}
+}
+
+func (b *Nextcloud) Stat(ctx context.Context, path string) (os.FileInfo, error) {
+	return b.n.StatContext(ctx, path)
+}
+
+func (b *Nextcloud) ReadDir(ctx context.Context, path string) ([]os.FileInfo, error) {
+	return b.n.ReadDirContext(ctx, path)
+}
+
+func (b *Nextcloud) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	return b.n.ReadFileContext(ctx, path)
+}
+
+// OpenRange は Range リクエストで必要な部分だけを取得する
+func (b *Nextcloud) OpenRange(ctx context.Context, path string, offset int64, length int64) (io.ReadCloser, error) {
+	return b.n.ReadFileRangeContext(ctx, path, offset, length)
+}
+
+// Create は書き込んだものをそのまま PUT のリクエストボディとして送る
+// サーバーのエラーは、送っている途中なら Write で、そうでなければ Close で返す
+// 更新日時は PROPPATCH をしなくて済むように、PUT のヘッダーで設定する
+func (b *Nextcloud) Create(ctx context.Context, path string, fi os.FileInfo, exclusive bool) (Writer, error) {
+	conds := []webdav.Condition{webdav.MTime(fi.ModTime())}
+	if exclusive {
+		conds = append(conds, webdav.IfNoneMatch("*"))
+	}
+
+	pr, pw := io.Pipe()
+
+	w := &nextcloudWriter{
+		pw:   pw,
+		done: make(chan error, 1),
+	}
+
+	go func() {
+		err := b.n.WriteFileContext(ctx, path, pr, conds...)
+
+		// レスポンスが先に返ってきたときに、書き込む側が待ち続けないようにする
+		if err != nil {
+			pr.CloseWithError(err)
+		} else {
+			pr.CloseWithError(errResponded)
+		}
+
+		w.done <- err
+	}()
+
+	return w, nil
+}
+
+func (b *Nextcloud) MkdirAll(ctx context.Context, path string) error {
+	return b.n.MkdirAllContext(ctx, path)
+}
+
+func (b *Nextcloud) Remove(ctx context.Context, path string) error {
+	return b.n.DeleteContext(ctx, path)
+}
+
+var (
+	errResponded = errors.New("the server responded before the request body was sent")
+	errAborted   = errors.New("aborted")
+)
+
+type nextcloudWriter struct {
+	pw *io.PipeWriter
+
+	done     chan error // PUT の結果
+	finished bool
+	err      error
+}
+
+func (w *nextcloudWriter) Write(p []byte) (int, error) {
+	n, err := w.pw.Write(p)
+	if err != nil {
+		if err1 := w.wait(); err1 != nil {
+			return n, err1
+		}
+	}
+	return n, err
+}
+
+func (w *nextcloudWriter) Close() error {
+	w.pw.Close()
+	return w.wait()
+}
+
+// Abort は送っている途中のリクエストを中断する。サーバーには書き込まれない
+func (w *nextcloudWriter) Abort() error {
+	w.pw.CloseWithError(errAborted)
+	w.wait()
+	return nil
+}
+
+func (w *nextcloudWriter) wait() error {
+	if !w.finished {
+		w.err = <-w.done
+		w.finished = true
+	}
+	return w.err
+}
@@ -0,0 +1,204 @@
+package bwlimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c2h5oh/datasize"
+)
+
+// chunkSize は一度に読むバイト数の上限
+// 大きく読んでから待つと、帯域を制限していても瞬間的には速くなってしまうので小さく区切る
+const chunkSize = 16 * 1024
+
+// Limiter はトークンバケットで転送速度を制限する
+// 一つの Limiter を並列に使えば、全体の合計の速度を制限できる
+type Limiter struct {
+	schedule []entry // 時刻の順に並べた時間割。一つだけなら一日中同じ速度
+
+	m      sync.Mutex
+	tokens float64   // 今すぐ送ってよいバイト数。他が予約した分だけ負になる
+	last   time.Time // 最後に tokens を補充した時刻
+}
+
+// entry は at (0 時からの経過時間) 以降の速度。rate が 0 なら制限しない
+type entry struct {
+	at   time.Duration
+	rate int64
+}
+
+// Parse は "10M" のような速度 (バイト/秒) か、"08:00,2M 19:00,off" のような時間割から Limiter を作る
+// 時間割では、その時刻から次の時刻まで指定した速度にする。最初の時刻より前は、前の日の最後の速度を使う
+// "off" や "0" は制限しない。制限しないときは nil を返す
+func Parse(spec string) (*Limiter, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	schedule := []entry{}
+	if !strings.Contains(spec, ",") {
+		rate, err := parseRate(spec)
+		if err != nil {
+			return nil, err
+		}
+		schedule = append(schedule, entry{at: 0, rate: rate})
+	} else {
+		for _, field := range strings.Fields(spec) {
+			i := strings.Index(field, ",")
+			if i < 0 {
+				return nil, errors.New("invalid bwlimit timetable: " + field)
+			}
+
+			at, err := parseTime(field[:i])
+			if err != nil {
+				return nil, err
+			}
+
+			rate, err := parseRate(field[i+1:])
+			if err != nil {
+				return nil, err
+			}
+
+			schedule = append(schedule, entry{at: at, rate: rate})
+		}
+		sort.SliceStable(schedule, func(i, j int) bool { return schedule[i].at < schedule[j].at })
+	}
+
+	unlimited := true
+	for _, e := range schedule {
+		if e.rate > 0 {
+			unlimited = false
+		}
+	}
+	if unlimited {
+		return nil, nil
+	}
+
+	return &Limiter{schedule: schedule, last: time.Now()}, nil
+}
+
+func parseRate(s string) (int64, error) {
+	if s == "off" || s == "0" {
+		return 0, nil
+	}
+
+	var rate datasize.ByteSize
+	if err := rate.UnmarshalText([]byte(s)); err != nil || rate.Bytes() <= 0 {
+		return 0, errors.New("invalid bwlimit: " + s)
+	}
+
+	return int64(rate.Bytes()), nil
+}
+
+func parseTime(s string) (time.Duration, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, errors.New("invalid bwlimit time: " + s)
+	}
+
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// Rate は t の時点での速度 (バイト/秒) を返す。制限しないときは 0 を返す
+func (l *Limiter) Rate(t time.Time) int64 {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	at := t.Sub(midnight)
+
+	// 最初の時刻より前なら、前の日の最後の速度
+	rate := l.schedule[len(l.schedule)-1].rate
+	for _, e := range l.schedule {
+		if e.at > at {
+			break
+		}
+		rate = e.rate
+	}
+
+	return rate
+}
+
+// WaitN は n バイト送ってよくなるまで待つ
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	for n > 0 {
+		l.m.Lock()
+
+		now := time.Now()
+		rate := l.Rate(now)
+		if rate <= 0 {
+			l.last = now
+			l.tokens = 0
+			l.m.Unlock()
+			return nil
+		}
+
+		// 一秒分までためておける
+		burst := float64(rate)
+		l.tokens += now.Sub(l.last).Seconds() * float64(rate)
+		if l.tokens > burst {
+			l.tokens = burst
+		}
+		l.last = now
+
+		take := n
+		if float64(take) > burst {
+			take = int(burst)
+		}
+		n -= take
+
+		// 先に予約してから待つので、並列に呼ばれても順番に送ることになる
+		l.tokens -= float64(take)
+		wait := time.Duration(0)
+		if l.tokens < 0 {
+			wait = time.Duration(-l.tokens / float64(rate) * float64(time.Second))
+		}
+
+		l.m.Unlock()
+
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+
+	return nil
+}
+
+// Reader は r から読む速さを l で制限する。l が nil なら r をそのまま返す
+func Reader(ctx context.Context, l *Limiter, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &reader{ctx: ctx, l: l, r: r}
+}
+
+type reader struct {
+	ctx context.Context
+	l   *Limiter
+	r   io.Reader
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if err := r.l.WaitN(r.ctx, n); err != nil {
+			return n, err
+		}
+	}
+
+	return n, err
+}
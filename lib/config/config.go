@@ -0,0 +1,214 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config は TOML の設定ファイル
+// 値はテーブルを入れ子にした map で持ち、キーは ["profile", "work", "upload", "procs"] のようにテーブルの名前を並べて指定する
+type Config struct {
+	path   string
+	values map[string]interface{}
+}
+
+// ErrInvalid は設定ファイルを TOML として読めなかったことを表す
+var ErrInvalid = errors.New("invalid config file")
+
+// DefaultPath は設定ファイルの既定の場所を返す
+// Linux では $XDG_CONFIG_HOME/appname/config.toml (なければ ~/.config/appname/config.toml)
+func DefaultPath(appname string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, appname, "config.toml"), nil
+}
+
+// New は path に保存する空の設定を作る
+func New(path string) *Config {
+	return &Config{path: path, values: map[string]interface{}{}}
+}
+
+// Load は path の設定ファイルを読み込む。ファイルがなければ空の設定を返す
+// TOML として読めなければ ErrInvalid のエラーを返す
+func Load(path string) (*Config, error) {
+	c := New(path)
+
+	if _, err := toml.DecodeFile(path, &c.values); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return c, nil
+		}
+		return nil, fmt.Errorf("%w: %s: %s", ErrInvalid, path, err.Error())
+	}
+
+	return c, nil
+}
+
+// Path は設定ファイルのパスを返す
+func (c *Config) Path() string {
+	return c.path
+}
+
+// Lookup は keys の値を返す。なければ false を返す。テーブルは値として扱わない
+func (c *Config) Lookup(keys ...string) (interface{}, bool) {
+	table := c.values
+	for i, key := range keys {
+		v, ok := table[key]
+		if !ok {
+			return nil, false
+		}
+
+		if i == len(keys)-1 {
+			if _, ok := v.(map[string]interface{}); ok {
+				return nil, false
+			}
+			return v, true
+		}
+
+		if table, ok = v.(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+
+	return nil, false
+}
+
+// Set は keys に value を設定する。途中のテーブルがなければ作る
+func (c *Config) Set(value interface{}, keys ...string) error {
+	table := c.values
+	for _, key := range keys[:len(keys)-1] {
+		v, ok := table[key]
+		if !ok {
+			v = map[string]interface{}{}
+			table[key] = v
+		}
+
+		t, ok := v.(map[string]interface{})
+		if !ok {
+			return errors.New("not a table: " + strings.Join(keys, "."))
+		}
+		table = t
+	}
+
+	table[keys[len(keys)-1]] = value
+	return nil
+}
+
+// Unset は keys の値を消す。空になったテーブルも消す。値がなければ false を返す
+func (c *Config) Unset(keys ...string) bool {
+	return unset(c.values, keys)
+}
+
+func unset(table map[string]interface{}, keys []string) bool {
+	v, ok := table[keys[0]]
+	if !ok {
+		return false
+	}
+
+	if len(keys) == 1 {
+		if _, ok := v.(map[string]interface{}); ok {
+			return false
+		}
+		delete(table, keys[0])
+		return true
+	}
+
+	t, ok := v.(map[string]interface{})
+	if !ok || !unset(t, keys[1:]) {
+		return false
+	}
+
+	if len(t) == 0 {
+		delete(table, keys[0])
+	}
+	return true
+}
+
+// Walk は設定されているすべての値について、キーの順に fn を呼ぶ
+func (c *Config) Walk(fn func(keys []string, value interface{}) error) error {
+	return walk(c.values, nil, fn)
+}
+
+func walk(table map[string]interface{}, parent []string, fn func(keys []string, value interface{}) error) error {
+	names := make([]string, 0, len(table))
+	for name := range table {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// テーブルより先に値を並べる (TOML で書くときと同じ順)
+	sort.SliceStable(names, func(i, j int) bool {
+		_, ti := table[names[i]].(map[string]interface{})
+		_, tj := table[names[j]].(map[string]interface{})
+		return !ti && tj
+	})
+
+	for _, name := range names {
+		keys := append(append([]string{}, parent...), name)
+		if t, ok := table[name].(map[string]interface{}); ok {
+			if err := walk(t, keys, fn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := fn(keys, table[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Save は設定ファイルに書き込む。ディレクトリがなければ作る
+// 書き込み中に失敗しても元のファイルが壊れないように、一時ファイルに書いてから置き換える
+// コメントや並び順は残らない
+func (c *Config) Save() error {
+	buf := &bytes.Buffer{}
+	enc := toml.NewEncoder(buf)
+	enc.Indent = ""
+	if err := enc.Encode(c.values); err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(c.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), c.path)
+}
+
+// Format は値を設定ファイルと同じ書き方の文字列にする
+func Format(value interface{}) string {
+	buf := &bytes.Buffer{}
+	if err := toml.NewEncoder(buf).Encode(map[string]interface{}{"v": value}); err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(buf.String(), "v = "))
+}
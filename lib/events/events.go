@@ -0,0 +1,225 @@
+// Package events はファイルの転送や削除の進捗を、一行に一つの JSON (NDJSON) で書き出す
+// GUI のラッパーや CI のログのように、プログレスバーを表示できないところから使うためのもの
+//
+//	{"time":"...","event":"start","path":"a.txt","dst":"/backup/a.txt","size":1024}
+//	{"time":"...","event":"progress","path":"a.txt","bytes":512,"size":1024}
+//	{"time":"...","event":"retry","path":"a.txt","attempt":1,"delay":1,"error":"..."}
+//	{"time":"...","event":"retry","request":"PUT https://...","delay":1,"error":"..."}
+//	{"time":"...","event":"skip","path":"b.txt","reason":"exists"}
+//	{"time":"...","event":"finish","path":"a.txt","dst":"/backup/a.txt","size":1024,"sha256":"...","duration":0.5}
+//	{"time":"...","event":"fail","path":"c.txt","error":"..."}
+//	{"time":"...","event":"summary","files":1,"skipped":1,"failed":1,"bytes":1024,"duration":0.6}
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// スキップした理由
+const (
+	SkipExists    = "exists"     // コピー先に既にある (--deconflict skip)
+	SkipNotNewer  = "not_newer"  // コピー先のほうが新しいか同じ (--deconflict newest)
+	SkipNotLarger = "not_larger" // コピー先のほうが大きいか同じ (--deconflict larger)
+	SkipIgnored   = "ignored"    // .nextcloudignore に書かれている
+	SkipDirectory = "directory"  // ディレクトリなので消さない (rm で -r がない)
+	SkipRefused   = "refused"    // 確認で no と答えた
+)
+
+// Writer はイベントを書き出す。並列に呼んでも一行ずつ書き出す
+// nil の Writer のメソッドは何もしないので、イベントを出さないときは nil のまま使える
+type Writer struct {
+	m   sync.Mutex
+	enc *json.Encoder
+
+	interval time.Duration // progress を出す間隔
+	start    time.Time     // summary の duration を測り始めた時刻
+
+	files   int   // 終わったファイルの数
+	skipped int   // スキップしたファイルの数
+	failed  int   // 失敗したファイルの数
+	bytes   int64 // 終わったファイルのバイト数の合計
+}
+
+// NewWriter は w にイベントを書き出す Writer を作る
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{enc: json.NewEncoder(w), interval: time.Second, start: time.Now()}
+}
+
+type header struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+}
+
+func (w *Writer) write(v interface{}) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	// 書き出せなくても転送は続ける
+	w.enc.Encode(v)
+}
+
+// Started はファイルの転送を始めたときに呼ぶ
+func (w *Writer) Started(path string, dst string, size int64) {
+	if w == nil {
+		return
+	}
+
+	w.write(struct {
+		header
+		Path string `json:"path"`
+		Dst  string `json:"dst"`
+		Size int64  `json:"size"`
+	}{header{time.Now(), "start"}, path, dst, size})
+}
+
+// Retried はファイルの転送に失敗して、delay だけ待って attempt 回目のリトライをするときに呼ぶ
+func (w *Writer) Retried(path string, attempt int, delay time.Duration, err error) {
+	if w == nil {
+		return
+	}
+
+	w.write(retryEvent{header: header{time.Now(), "retry"}, Path: path, Attempt: attempt, Delay: delay.Seconds(), Error: err.Error()})
+}
+
+// RequestRetried は HTTP のリクエストを delay だけ待って送りなおすときに呼ぶ
+// どのファイルのリクエストかはわからないので、path のかわりに request にメソッドと URL を入れる
+func (w *Writer) RequestRetried(method string, url string, delay time.Duration, err error) {
+	if w == nil {
+		return
+	}
+
+	w.write(retryEvent{header: header{time.Now(), "retry"}, Request: method + " " + url, Delay: delay.Seconds(), Error: err.Error()})
+}
+
+type retryEvent struct {
+	header
+	Path    string  `json:"path,omitempty"`
+	Request string  `json:"request,omitempty"`
+	Attempt int     `json:"attempt,omitempty"`
+	Delay   float64 `json:"delay"`
+	Error   string  `json:"error"`
+}
+
+// Skipped はファイルをスキップしたときに reason (SkipExists など) をつけて呼ぶ
+func (w *Writer) Skipped(path string, reason string) {
+	if w == nil {
+		return
+	}
+
+	w.m.Lock()
+	w.skipped++
+	w.m.Unlock()
+
+	w.write(struct {
+		header
+		Path   string `json:"path"`
+		Reason string `json:"reason"`
+	}{header{time.Now(), "skip"}, path, reason})
+}
+
+// Finished はファイルの転送や削除が終わったときに呼ぶ。sha256 は転送した内容のハッシュで、削除のときは空
+func (w *Writer) Finished(path string, dst string, size int64, sha256 string, duration time.Duration) {
+	if w == nil {
+		return
+	}
+
+	w.m.Lock()
+	w.files++
+	w.bytes += size
+	w.m.Unlock()
+
+	w.write(struct {
+		header
+		Path     string  `json:"path"`
+		Dst      string  `json:"dst,omitempty"`
+		Size     int64   `json:"size"`
+		SHA256   string  `json:"sha256,omitempty"`
+		Duration float64 `json:"duration"`
+	}{header{time.Now(), "finish"}, path, dst, size, sha256, duration.Seconds()})
+}
+
+// Failed はファイルの転送や削除に失敗したときに呼ぶ
+func (w *Writer) Failed(path string, err error) {
+	if w == nil {
+		return
+	}
+
+	w.m.Lock()
+	w.failed++
+	w.m.Unlock()
+
+	w.write(struct {
+		header
+		Path  string `json:"path"`
+		Error string `json:"error"`
+	}{header{time.Now(), "fail"}, path, err.Error()})
+}
+
+// Summary は全体の結果を書き出す。err は全体を中断したエラーで、なければ nil
+func (w *Writer) Summary(err error) {
+	if w == nil {
+		return
+	}
+
+	w.m.Lock()
+	v := struct {
+		header
+		Files    int     `json:"files"`
+		Skipped  int     `json:"skipped"`
+		Failed   int     `json:"failed"`
+		Bytes    int64   `json:"bytes"`
+		Duration float64 `json:"duration"`
+		Error    string  `json:"error,omitempty"`
+	}{header{time.Now(), "summary"}, w.files, w.skipped, w.failed, w.bytes, time.Since(w.start).Seconds(), ""}
+	w.m.Unlock()
+
+	if err != nil {
+		v.Error = err.Error()
+	}
+
+	w.write(v)
+}
+
+// Track は path の転送したバイト数を数えて、一定の間隔で progress を書き出す io.Writer を返す
+// w が nil なら nil を返す
+func (w *Writer) Track(path string, size int64) *Tracker {
+	if w == nil {
+		return nil
+	}
+
+	return &Tracker{w: w, path: path, size: size, last: time.Now()}
+}
+
+// Tracker は転送したバイト数を数える io.Writer
+type Tracker struct {
+	w    *Writer
+	path string
+	size int64
+
+	n    int64     // 転送したバイト数
+	last time.Time // 最後に progress を書き出した時刻
+}
+
+func (t *Tracker) Write(p []byte) (int, error) {
+	t.n += int64(len(p))
+
+	if now := time.Now(); now.Sub(t.last) >= t.w.interval {
+		t.last = now
+		t.w.write(struct {
+			header
+			Path  string `json:"path"`
+			Bytes int64  `json:"bytes"`
+			Size  int64  `json:"size"`
+		}{header{now, "progress"}, t.path, t.n, t.size})
+	}
+
+	return len(p), nil
+}
+
+// Reset はリトライするときに、数えたバイト数を 0 に戻す
+func (t *Tracker) Reset() {
+	t.n = 0
+}
@@ -0,0 +1,197 @@
+package httplog
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HAR はやりとりを HAR 1.2 の形式で記録する
+// バグ報告に付けられるように、Authorization などの認証情報は記録しない
+//
+// http://www.softwareishard.com/blog/har-12-spec/
+type HAR struct {
+	m       sync.Mutex
+	creator harCreator
+	entries []harEntry
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []struct{}     `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []struct{}     `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// NewHAR は name と version のアプリが記録する HAR を作る
+func NewHAR(name string, version string) *HAR {
+	return &HAR{creator: harCreator{Name: name, Version: version}}
+}
+
+func (h *HAR) add(start time.Time, elapsed time.Duration, req *http.Request, reqBody []byte, resp *http.Response, respBody []byte) {
+	ms := float64(elapsed) / float64(time.Millisecond)
+
+	entry := harEntry{
+		StartedDateTime: start,
+		Time:            ms,
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         req.URL.Redacted(),
+			HTTPVersion: req.Proto,
+			Cookies:     []struct{}{},
+			Headers:     harHeaders(req.Header),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    req.ContentLength,
+		},
+		Timings: harTimings{Send: 0, Wait: ms, Receive: 0},
+	}
+
+	for name, values := range req.URL.Query() {
+		for _, v := range values {
+			entry.Request.QueryString = append(entry.Request.QueryString, harNameValue{Name: name, Value: v})
+		}
+	}
+
+	if len(reqBody) > 0 {
+		entry.Request.PostData = &harPostData{MimeType: req.Header.Get("Content-Type"), Text: string(reqBody)}
+	}
+
+	if resp == nil {
+		// レスポンスがなかったときは、HAR の決まりに従ってステータスを 0 にする
+		entry.Response = harResponse{
+			HTTPVersion: req.Proto,
+			Cookies:     []struct{}{},
+			Headers:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		}
+		entry.Comment = "no response"
+	} else {
+		statusText := resp.Status
+		if len(statusText) > 4 {
+			statusText = statusText[4:]
+		}
+
+		entry.Response = harResponse{
+			Status:      resp.StatusCode,
+			StatusText:  statusText,
+			HTTPVersion: resp.Proto,
+			Cookies:     []struct{}{},
+			Headers:     harHeaders(resp.Header),
+			Content: harContent{
+				Size:     resp.ContentLength,
+				MimeType: resp.Header.Get("Content-Type"),
+				Text:     string(respBody),
+			},
+			RedirectURL: resp.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    resp.ContentLength,
+		}
+	}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.entries = append(h.entries, entry)
+}
+
+// harHeaders はヘッダーを HAR の形式にする。認証情報は隠す
+func harHeaders(header http.Header) []harNameValue {
+	values := []harNameValue{}
+	for name, vs := range header {
+		for _, v := range vs {
+			switch http.CanonicalHeaderKey(name) {
+			case "Authorization":
+				v = redact(name, v)
+			case "Cookie", "Set-Cookie":
+				v = "[REDACTED]"
+			}
+			values = append(values, harNameValue{Name: name, Value: v})
+		}
+	}
+	return values
+}
+
+// WriteFile は記録したものを path に書き込む
+func (h *HAR) WriteFile(path string) error {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	v := struct {
+		Log struct {
+			Version string     `json:"version"`
+			Creator harCreator `json:"creator"`
+			Entries []harEntry `json:"entries"`
+		} `json:"log"`
+	}{}
+	v.Log.Version = "1.2"
+	v.Log.Creator = h.creator
+	v.Log.Entries = h.entries
+	if v.Log.Entries == nil {
+		v.Log.Entries = []harEntry{}
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// 認証情報は隠しているが、ファイルのパスなどが入るので自分だけが読めるようにする
+	return ioutil.WriteFile(path, data, 0600)
+}
@@ -0,0 +1,153 @@
+package httplog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBody はボディを表示したり HAR に記録したりするときの上限のバイト数
+const maxBody = 1024 * 1024
+
+// logHeaders はログに出すヘッダー。Authorization はあるかどうかだけを出す
+var logHeaders = []string{
+	"Authorization",
+	"Content-Type",
+	"Content-Length",
+	"Content-Range",
+	"Range",
+	"Depth",
+	"Destination",
+	"Overwrite",
+	"If-Match",
+	"If-None-Match",
+	"ETag",
+	"OC-ETag",
+	"OC-FileId",
+	"X-OC-Mtime",
+	"Location",
+	"Retry-After",
+	"X-Request-Id",
+	"User-Agent",
+}
+
+// Transport はリクエストとレスポンスをログに出す http.RoundTripper
+// リトライする Transport の下に置けば、送りなおしたリクエストも一つずつ出る
+type Transport struct {
+	Base     http.RoundTripper // nil なら http.DefaultTransport
+	Out      io.Writer         // ログの出力先
+	DumpBody bool              // XML のボディも出す (PROPFIND の中身などを見るとき)
+	HAR      *HAR              // nil でなければ、やりとりを記録する
+
+	m sync.Mutex // 並列に送ったときにログが混ざらないようにする
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+	return t.Base
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if (t.DumpBody || t.HAR != nil) && isXML(req.Header.Get("Content-Type")) && req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			reqBody, _ = ioutil.ReadAll(io.LimitReader(body, maxBody))
+			body.Close()
+		}
+	}
+
+	start := time.Now()
+	resp, err := t.base().RoundTrip(req)
+	elapsed := time.Since(start)
+
+	var respBody []byte
+	if err == nil && (t.DumpBody || t.HAR != nil) && isXML(resp.Header.Get("Content-Type")) {
+		respBody, _ = ioutil.ReadAll(io.LimitReader(resp.Body, maxBody))
+		resp.Body = &readCloser{Reader: io.MultiReader(bytes.NewReader(respBody), resp.Body), Closer: resp.Body}
+	}
+
+	t.log(req, reqBody, resp, respBody, err, elapsed)
+
+	if t.HAR != nil {
+		t.HAR.add(start, elapsed, req, reqBody, resp, respBody)
+	}
+
+	return resp, err
+}
+
+func (t *Transport) log(req *http.Request, reqBody []byte, resp *http.Response, respBody []byte, err error, elapsed time.Duration) {
+	if t.Out == nil {
+		return
+	}
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "> %s %s\n", req.Method, req.URL.Redacted())
+	writeHeaders(b, "> ", req.Header)
+	if t.DumpBody && len(reqBody) > 0 {
+		writeBody(b, "> ", reqBody)
+	}
+
+	if err != nil {
+		fmt.Fprintf(b, "< error after %s: %s\n", elapsed.Round(time.Millisecond), err.Error())
+	} else {
+		fmt.Fprintf(b, "< %s %s (%s)\n", resp.Proto, resp.Status, elapsed.Round(time.Millisecond))
+		writeHeaders(b, "< ", resp.Header)
+		if t.DumpBody && len(respBody) > 0 {
+			writeBody(b, "< ", respBody)
+		}
+	}
+
+	t.m.Lock()
+	defer t.m.Unlock()
+	io.WriteString(t.Out, b.String())
+}
+
+func writeHeaders(w io.Writer, prefix string, header http.Header) {
+	for _, name := range logHeaders {
+		for _, v := range header.Values(name) {
+			fmt.Fprintf(w, "%s%s: %s\n", prefix, name, redact(name, v))
+		}
+	}
+}
+
+func writeBody(w io.Writer, prefix string, body []byte) {
+	for _, line := range strings.Split(strings.TrimRight(string(body), "\n"), "\n") {
+		fmt.Fprintf(w, "%s  %s\n", prefix, line)
+	}
+}
+
+// redact は Authorization の中身を隠す。認証方式だけは残す
+func redact(name string, value string) string {
+	if !strings.EqualFold(name, "Authorization") {
+		return value
+	}
+
+	if i := strings.Index(value, " "); i > 0 {
+		return value[:i] + " [REDACTED]"
+	}
+	return "[REDACTED]"
+}
+
+// isXML は WebDAV の XML のボディかどうかを返す
+// トークンやアプリパスワードが入る OAuth2 や Login Flow の JSON は出さない
+func isXML(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasSuffix(mediaType, "/xml") || strings.HasSuffix(mediaType, "+xml")
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
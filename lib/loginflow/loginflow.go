@@ -0,0 +1,211 @@
+package loginflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	_path "path"
+	"strings"
+	"time"
+)
+
+// Flow は Nextcloud の Login Flow v2 でログイン中の状態
+// ユーザーがブラウザで LoginURL を開いて許可すると、Wait でアプリパスワードが取得できる
+//
+// https://docs.nextcloud.com/server/latest/developer_manual/client_apis/LoginFlow/index.html#login-flow-v2
+type Flow struct {
+	LoginURL string // ユーザーがブラウザで開く URL
+
+	c         *http.Client
+	userAgent string
+	endpoint  string // ポーリングする URL
+	token     string
+}
+
+// Result はログインに成功したときに取得できるアプリパスワード
+type Result struct {
+	Server      string `json:"server"`
+	LoginName   string `json:"loginName"`
+	AppPassword string `json:"appPassword"`
+}
+
+// BaseURL は remote.php/webdav などが付いた URL から、Nextcloud のルートの URL を返す
+func BaseURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	p := strings.TrimSuffix(u.Path, "/")
+	if i := strings.Index(p, "/remote.php/"); i >= 0 {
+		p = p[:i]
+	}
+	u.Path = p
+	u.RawQuery = ""
+	u.Fragment = ""
+
+	return u.String(), nil
+}
+
+// Start は Login Flow v2 を始める
+// userAgent はサーバー上でアプリパスワードの名前として表示される
+func Start(ctx context.Context, c *http.Client, rawURL string, userAgent string) (*Flow, error) {
+	base, err := BaseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", base+"/index.php/login/v2", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, fmt.Errorf("failed to start login flow: %s", resp.Status)
+	}
+
+	v := struct {
+		Poll struct {
+			Token    string `json:"token"`
+			Endpoint string `json:"endpoint"`
+		} `json:"poll"`
+		Login string `json:"login"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return nil, fmt.Errorf("failed to start login flow: %w", err)
+	}
+
+	if v.Login == "" || v.Poll.Endpoint == "" || v.Poll.Token == "" {
+		return nil, errors.New("failed to start login flow: invalid response")
+	}
+
+	flow := &Flow{
+		LoginURL: v.Login,
+
+		c:         c,
+		userAgent: userAgent,
+		endpoint:  v.Poll.Endpoint,
+		token:     v.Poll.Token,
+	}
+
+	return flow, nil
+}
+
+// Wait はユーザーがブラウザでログインを許可するまで、interval ごとにポーリングして待つ
+// サーバー側ではフローは 20 分で期限切れになるので、それまでに終わらなければエラーにする
+func (f *Flow) Wait(ctx context.Context, interval time.Duration) (*Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Minute)
+	defer cancel()
+
+	for {
+		result, err := f.poll(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if result != nil {
+			return result, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, errors.New("login flow timed out")
+			}
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// poll はログインが終わっていれば Result を、まだなら nil を返す
+func (f *Flow) poll(ctx context.Context) (*Result, error) {
+	form := url.Values{}
+	form.Set("token", f.token)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", f.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", f.userAgent)
+
+	resp, err := f.c.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		// まだブラウザでログインしていない
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, nil
+	default:
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, fmt.Errorf("failed to poll login flow: %s", resp.Status)
+	}
+
+	result := Result{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to poll login flow: %w", err)
+	}
+
+	return &result, nil
+}
+
+// Revoke はアプリパスワードをサーバーから削除する
+// 通常のパスワードでログインしているときは、サーバーがエラーを返す
+func Revoke(ctx context.Context, c *http.Client, rawURL string, username string, appPassword string, userAgent string) error {
+	base, err := BaseURL(rawURL)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(base)
+	if err != nil {
+		return err
+	}
+	u.Path = _path.Join(u.Path, "/ocs/v2.php/core/apppassword")
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(username, appPassword)
+	req.Header.Set("OCS-APIREQUEST", "true")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusForbidden:
+		return errors.New("failed to revoke app password: the saved password is not an app password")
+	default:
+		return fmt.Errorf("failed to revoke app password: %s", resp.Status)
+	}
+}
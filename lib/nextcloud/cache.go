@@ -0,0 +1,341 @@
+package nextcloud
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	_path "path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Cache は ReadDir の結果をディスクに保存しておき、次回以降の Stat や ReadDir で使うためのもの
+//
+// ディレクトリの ETag は配下のどれかが変わると変化するので、保存したときと ETag が同じなら中身も同じとみなせる。
+// 一度 ETag を確認したディレクトリの子ディレクトリは、親の一覧にある ETag と比べるだけで確認できるので、
+// 変更のない部分木はルートで一度 Depth 0 の PROPFIND をするだけで手元から返せる。
+//
+// 保存したものはディレクトリごとに一つのファイルになる。消したディレクトリのものも残るので、
+// Prune で長い間使っていないものを消す。
+type Cache struct {
+	dir string // 保存先のディレクトリ
+
+	m         sync.Mutex
+	validated map[string]*cacheEntry // この実行中に ETag を確認したディレクトリ
+}
+
+type cacheEntry struct {
+	ETag     string      `json:"etag"`
+	Self     *FileInfo   `json:"self"`
+	Children []*FileInfo `json:"children"`
+
+	byName map[string]*FileInfo // Children を名前で引くための map。markValidated で作る
+}
+
+// NewCache は dir に保存するキャッシュを作る
+// dir は接続先やユーザーごとに分けること
+func NewCache(dir string) *Cache {
+	return &Cache{
+		dir:       dir,
+		validated: map[string]*cacheEntry{},
+	}
+}
+
+// SetCache は Stat と ReadDir で cache を使うようにする。nil なら使わない
+func (n *Nextcloud) SetCache(cache *Cache) {
+	n.cache = cache
+}
+
+// Clear は保存したものをすべて消す
+func (c *Cache) Clear() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.validated = map[string]*cacheEntry{}
+	return os.RemoveAll(c.dir)
+}
+
+// lookup は確認済みのディレクトリの一覧から path を探す
+// 親ディレクトリが確認済みでないときは ok が false になる。確認済みで見つからないときは fi が nil になる
+func (c *Cache) lookup(path string) (fi *FileInfo, ok bool) {
+	path = cleanPath(path)
+	if path == "/" {
+		return nil, false
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	parent, ok := c.validated[_path.Dir(path)]
+	if !ok {
+		return nil, false
+	}
+
+	return findChild(parent, _path.Base(path)), true
+}
+
+// validatedByParent は path の保存済みの一覧が、確認済みの親ディレクトリの一覧にある ETag と一致すれば返す
+func (c *Cache) validatedByParent(path string, entry *cacheEntry) bool {
+	path = cleanPath(path)
+	if path == "/" {
+		return false
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	parent, ok := c.validated[_path.Dir(path)]
+	if !ok {
+		return false
+	}
+
+	child := findChild(parent, _path.Base(path))
+	return child != nil && child.etag != "" && child.etag == entry.ETag
+}
+
+func (c *Cache) markValidated(path string, entry *cacheEntry) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if entry.byName == nil {
+		entry.byName = make(map[string]*FileInfo, len(entry.Children))
+		for _, fi := range entry.Children {
+			entry.byName[fi.name] = fi
+		}
+	}
+
+	c.validated[cleanPath(path)] = entry
+}
+
+func (c *Cache) load(path string) *cacheEntry {
+	file := c.file(path)
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+
+	entry := cacheEntry{}
+	if err := json.Unmarshal(data, &entry); err != nil || entry.ETag == "" {
+		return nil
+	}
+
+	// Prune で消さないように、使ったものは更新日時を新しくしておく。毎回書き込まないように一日に一度だけにする
+	if fi, err := os.Stat(file); err == nil && time.Since(fi.ModTime()) > 24*time.Hour {
+		now := time.Now()
+		os.Chtimes(file, now, now)
+	}
+
+	return &entry
+}
+
+// Prune は maxAge より長く使っていないものを消す
+// 保存したものをすべて調べるので、前に調べてから一日経っていなければ何もしない
+func (c *Cache) Prune(maxAge time.Duration) error {
+	stamp := filepath.Join(c.dir, ".pruned")
+	if fi, err := os.Stat(stamp); err == nil && time.Since(fi.ModTime()) < 24*time.Hour {
+		return nil
+	}
+
+	fis, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, fi := range fis {
+		if fi.IsDir() || fi.Name() == ".pruned" || time.Since(fi.ModTime()) <= maxAge {
+			continue
+		}
+		os.Remove(filepath.Join(c.dir, fi.Name()))
+	}
+
+	return ioutil.WriteFile(stamp, nil, 0600)
+}
+
+// store は保存する。キャッシュなので失敗しても無視する
+func (c *Cache) store(path string, entry *cacheEntry) {
+	if entry.ETag == "" {
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return
+	}
+
+	// 並列で読み書きしても壊れたものを読まないように、一時ファイルに書いてから置き換える
+	f, err := ioutil.TempFile(c.dir, ".tmp-")
+	if err != nil {
+		return
+	}
+
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return
+	}
+
+	if err := os.Rename(f.Name(), c.file(path)); err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// invalidate は path を変更したときに呼ぶ
+// path とその親の保存したものを消し、確認済みの印もすべて消す
+func (c *Cache) invalidate(path string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.validated = map[string]*cacheEntry{}
+
+	os.Remove(c.file(path))
+	os.Remove(c.file(_path.Dir(cleanPath(path))))
+}
+
+func (c *Cache) file(path string) string {
+	sum := sha256.Sum256([]byte(cleanPath(path)))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// findChild は確認済みのディレクトリの一覧から name を探す。entry は markValidated したものであること
+func findChild(entry *cacheEntry, name string) *FileInfo {
+	return entry.byName[name]
+}
+
+func cleanPath(path string) string {
+	return _path.Clean("/" + path)
+}
+
+// readDirCached はキャッシュを使う ReadDir
+func (n *Nextcloud) readDirCached(ctx context.Context, path string) ([]os.FileInfo, error) {
+	if entry := n.cache.load(path); entry != nil {
+		valid := n.cache.validatedByParent(path, entry)
+
+		if !valid {
+			fi, err := n.stat(ctx, path)
+			if err != nil {
+				return nil, err
+			}
+
+			nfi, ok := fi.(*FileInfo)
+			valid = ok && nfi.etag == entry.ETag
+		}
+
+		if valid {
+			n.cache.markValidated(path, entry)
+			return fileInfos(entry.Children), nil
+		}
+	}
+
+	self, children, err := n.readDir(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &cacheEntry{
+		ETag:     self.etag,
+		Self:     self,
+		Children: children,
+	}
+
+	n.cache.store(path, entry)
+	n.cache.markValidated(path, entry)
+
+	return fileInfos(children), nil
+}
+
+// fileInfos は呼び出し側で並べ替えられてもキャッシュに影響しないように、新しいスライスにして返す
+func fileInfos(fl []*FileInfo) []os.FileInfo {
+	result := make([]os.FileInfo, 0, len(fl))
+	for _, fi := range fl {
+		result = append(result, fi)
+	}
+	return result
+}
+
+// fileInfoJSON は FileInfo を保存するときの形式
+type fileInfoJSON struct {
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	IsDir   bool        `json:"dir,omitempty"`
+	ETag    string      `json:"etag,omitempty"`
+
+	QuotaUsedBytes      int64 `json:"quota_used"`
+	QuotaAvailableBytes int64 `json:"quota_available"`
+
+	Permissions      string `json:"permissions,omitempty"`
+	ID               string `json:"id,omitempty"`
+	OwnerID          string `json:"owner_id,omitempty"`
+	OwnerDisplayName string `json:"owner_display_name,omitempty"`
+	TotalSize        int64  `json:"total_size"`
+
+	MountType string `json:"mount_type,omitempty"`
+}
+
+func (f *FileInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&fileInfoJSON{
+		Name:    f.name,
+		Size:    f.size,
+		Mode:    f.mode,
+		ModTime: f.modTime,
+		IsDir:   f.isDir,
+		ETag:    f.etag,
+
+		QuotaUsedBytes:      f.quotaUsedBytes,
+		QuotaAvailableBytes: f.quotaAvailableBytes,
+
+		Permissions:      f.permissions,
+		ID:               f.id,
+		OwnerID:          f.ownerID,
+		OwnerDisplayName: f.ownerDisplayName,
+		TotalSize:        f.totalSize,
+
+		MountType: f.mountType,
+	})
+}
+
+func (f *FileInfo) UnmarshalJSON(data []byte) error {
+	v := fileInfoJSON{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*f = FileInfo{
+		name:    v.Name,
+		size:    v.Size,
+		mode:    v.Mode,
+		modTime: v.ModTime,
+		isDir:   v.IsDir,
+		etag:    v.ETag,
+
+		quotaUsedBytes:      v.QuotaUsedBytes,
+		quotaAvailableBytes: v.QuotaAvailableBytes,
+
+		permissions:      v.Permissions,
+		id:               v.ID,
+		ownerID:          v.OwnerID,
+		ownerDisplayName: v.OwnerDisplayName,
+		totalSize:        v.TotalSize,
+
+		mountType: v.MountType,
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package nextcloud
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	_path "path"
+)
+
+// ChunkedUpload は Nextcloud の chunked upload で、一つのファイルを複数のリクエストに分けて送る
+// WriteChunk で送ったチャンクは Commit したときにサーバー上で結合される
+type ChunkedUpload struct {
+	n *Nextcloud
+
+	path   string // 結合後のファイルのパス
+	dir    string // チャンクを置く remote.php/dav/uploads 以下の一時ディレクトリ
+	userID string
+}
+
+// NewChunkedUpload は path に書き込むための chunked upload を始める
+func (n *Nextcloud) NewChunkedUpload(path string) (*ChunkedUpload, error) {
+	return n.NewChunkedUploadContext(context.Background(), path)
+}
+
+func (n *Nextcloud) NewChunkedUploadContext(ctx context.Context, path string) (*ChunkedUpload, error) {
+	if n.dav == nil {
+		return nil, &os.PathError{Op: "NewChunkedUpload", Path: path, Err: errors.New("chunked upload is not supported")}
+	}
+
+	// remote.php/dav ではユーザーID がパスに含まれるので、ルートの所有者から調べる
+	fi, err := n.StatContext(ctx, "/")
+	if err != nil {
+		return nil, err
+	}
+
+	nfi, ok := fi.(*FileInfo)
+	if !ok || nfi.OwnerID() == "" {
+		return nil, &os.PathError{Op: "NewChunkedUpload", Path: path, Err: errors.New("failed to get user id")}
+	}
+
+	id := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return nil, &os.PathError{Op: "NewChunkedUpload", Path: path, Err: err}
+	}
+
+	u := &ChunkedUpload{
+		n: n,
+
+		path:   path,
+		dir:    _path.Join("/uploads", nfi.OwnerID(), "nextcloud-cli-"+hex.EncodeToString(id)),
+		userID: nfi.OwnerID(),
+	}
+
+	if err := n.dav.MkcolContext(ctx, u.dir); err != nil {
+		return nil, &os.PathError{Op: "NewChunkedUpload", Path: path, Err: webdavError(err)}
+	}
+
+	return u, nil
+}
+
+// WriteChunk は index 番目のチャンクを送る。index は 1 から始まる連番にすること
+// 同じ index で送り直すと上書きされるので、失敗したときはそのままリトライしてよい
+func (u *ChunkedUpload) WriteChunk(index int, body io.Reader) error {
+	return u.WriteChunkContext(context.Background(), index, body)
+}
+
+func (u *ChunkedUpload) WriteChunkContext(ctx context.Context, index int, body io.Reader) error {
+	if _, err := u.n.dav.PutContext(ctx, _path.Join(u.dir, fmt.Sprintf("%05d", index)), body); err != nil {
+		return &os.PathError{Op: "WriteChunk", Path: u.path, Err: webdavError(err)}
+	}
+
+	return nil
+}
+
+// Commit は送ったチャンクを結合して path に置く
+func (u *ChunkedUpload) Commit(overwrite bool) error {
+	return u.CommitContext(context.Background(), overwrite)
+}
+
+func (u *ChunkedUpload) CommitContext(ctx context.Context, overwrite bool) error {
+	dst := _path.Join("/files", u.userID, u.path)
+	if err := u.n.dav.MoveContext(ctx, _path.Join(u.dir, ".file"), dst, overwrite); err != nil {
+		return &os.PathError{Op: "Commit", Path: u.path, Err: webdavError(err)}
+	}
+
+	u.n.invalidate(u.path)
+
+	return nil
+}
+
+// Abort は送ったチャンクを破棄する
+func (u *ChunkedUpload) Abort() error {
+	return u.AbortContext(context.Background())
+}
+
+func (u *ChunkedUpload) AbortContext(ctx context.Context) error {
+	if err := u.n.dav.DeleteContext(ctx, u.dir); err != nil {
+		return &os.PathError{Op: "Abort", Path: u.path, Err: webdavError(err)}
+	}
+
+	return nil
+}
@@ -12,16 +12,21 @@ import (
 	"github.com/kurusugawa-computer/nextcloud-cli/lib/webdav"
 )
 
-var propfind = []byte(`<d:propfind xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns">
+var propfind = []byte(`<d:propfind xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns" xmlns:nc="http://nextcloud.org/ns">
 <d:prop>
 	<d:displayname/>
 	<d:getcontentlength/>
 	<d:getlastmodified/>
 	<d:resourcetype/>
+	<d:getetag/>
+	<d:quota-used-bytes/>
+	<d:quota-available-bytes/>
 	<oc:permissions/>
 	<oc:id/>
 	<oc:owner-id/>
 	<oc:owner-display-name/>
+	<oc:size/>
+	<nc:mount-type/>
 </d:prop>
 </d:propfind>`)
 
@@ -32,6 +37,11 @@ func fileInfo(response *webdav.Response) (os.FileInfo, error) {
 		mode:    0664,
 		modTime: time.Unix(0, 0),
 		isDir:   false,
+
+		quotaUsedBytes:      -1,
+		quotaAvailableBytes: QuotaUnknown,
+
+		totalSize: -1,
 	}
 
 	href, err := url.QueryUnescape(response.Href)
@@ -79,6 +89,26 @@ func fileInfo(response *webdav.Response) (os.FileInfo, error) {
 					fi.isDir = false
 					fi.mode = 0664
 				}
+
+			case "getetag":
+				fi.etag = prop.Value
+
+			case "quota-used-bytes":
+				v, err := strconv.ParseInt(prop.Value, 10, 64)
+				if err != nil {
+					return nil, err
+				}
+
+				fi.quotaUsedBytes = v
+
+			case "quota-available-bytes":
+				// 負の値は QuotaUnlimited などの特別な意味を持つのでそのまま保持する
+				v, err := strconv.ParseInt(prop.Value, 10, 64)
+				if err != nil {
+					return nil, err
+				}
+
+				fi.quotaAvailableBytes = v
 			}
 
 		case "http://owncloud.org/ns":
@@ -94,6 +124,23 @@ func fileInfo(response *webdav.Response) (os.FileInfo, error) {
 
 			case "owner-display-name":
 				fi.ownerDisplayName = prop.Value
+
+			case "size":
+				v, err := strconv.ParseInt(prop.Value, 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				if v < 0 {
+					return nil, errors.New("size must >= 0")
+				}
+
+				fi.totalSize = v
+			}
+
+		case "http://nextcloud.org/ns":
+			switch prop.Name {
+			case "mount-type":
+				fi.mountType = prop.Value
 			}
 		}
 	}
@@ -108,14 +155,29 @@ type FileInfo struct {
 	mode    os.FileMode
 	modTime time.Time
 	isDir   bool
+	etag    string
+
+	quotaUsedBytes      int64
+	quotaAvailableBytes int64
 
 	// http://owncloud.org/ns
 	permissions      string
 	id               string
 	ownerID          string
 	ownerDisplayName string
+	totalSize        int64
+
+	// http://nextcloud.org/ns
+	mountType string
 }
 
+// d:quota-available-bytes が負の値のときの意味
+const (
+	QuotaPending   = -1 // まだ計算されていない
+	QuotaUnknown   = -2 // 不明
+	QuotaUnlimited = -3 // 無制限
+)
+
 func (f *FileInfo) Name() string {
 	return f.name
 }
@@ -155,3 +217,32 @@ func (f *FileInfo) OwnerID() string {
 func (f *FileInfo) OwnerDisplayName() string {
 	return f.ownerDisplayName
 }
+
+// ETag は d:getetag を返す。ディレクトリの場合は配下のどれかが変わると変化する
+func (f *FileInfo) ETag() string {
+	return f.etag
+}
+
+// QuotaUsedBytes は d:quota-used-bytes を返す。取得できなかったときは -1
+func (f *FileInfo) QuotaUsedBytes() int64 {
+	return f.quotaUsedBytes
+}
+
+// QuotaAvailableBytes は d:quota-available-bytes を返す。負の値は QuotaUnlimited などを表す
+func (f *FileInfo) QuotaAvailableBytes() int64 {
+	return f.quotaAvailableBytes
+}
+
+// TotalSize は oc:size を返す。ディレクトリの場合はサーバーが集計した配下の合計サイズになる
+// 取得できなかったときは Size() を返す
+func (f *FileInfo) TotalSize() int64 {
+	if f.totalSize < 0 {
+		return f.size
+	}
+	return f.totalSize
+}
+
+// MountType は nc:mount-type を返す。外部ストレージなら "external"、通常のフォルダなら ""
+func (f *FileInfo) MountType() string {
+	return f.mountType
+}
@@ -0,0 +1,351 @@
+package nextcloud
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	_path "path"
+	"sort"
+)
+
+// FS は Nextcloud を io/fs.FS として扱うためのもの
+// fs.WalkDir や http.FS などの標準のコードから、リモートのファイルを読み取り専用で使える
+type FS struct {
+	n    *Nextcloud
+	ctx  context.Context
+	root string // FS のルートにあたるリモートのパス
+}
+
+var (
+	_ fs.FS         = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+	_ fs.SubFS      = (*FS)(nil)
+)
+
+// FS は n のルートディレクトリを FS として返す
+func (n *Nextcloud) FS() *FS {
+	return n.FSContext(context.Background())
+}
+
+// FSContext は FS と同じだが、ctx がキャンセルされるとそれ以降の通信を中断する
+func (n *Nextcloud) FSContext(ctx context.Context) *FS {
+	return &FS{n: n, ctx: ctx, root: "/"}
+}
+
+// remotePath は fs.FS の流儀のパスをリモートのパスにする
+func (fsys *FS) remotePath(op string, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	return _path.Join(fsys.root, name), nil
+}
+
+// pathError は Nextcloud のメソッドが返したエラーを、fs.FS の流儀のパスのエラーにする
+func pathError(op string, name string, err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func (fsys *FS) Open(name string) (fs.File, error) {
+	path, err := fsys.remotePath("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := fsys.n.StatContext(fsys.ctx, path)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+
+	if fi.IsDir() {
+		return &dir{fsys: fsys, name: name, path: path, fi: fi}, nil
+	}
+
+	return &file{fsys: fsys, name: name, path: path, fi: fi}, nil
+}
+
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	path, err := fsys.remotePath("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := fsys.n.StatContext(fsys.ctx, path)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+
+	return fi, nil
+}
+
+// ReadDir は name の中身を名前順に返す
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	path, err := fsys.remotePath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	fl, err := fsys.n.ReadDirContext(fsys.ctx, path)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+
+	entries := make([]fs.DirEntry, 0, len(fl))
+	for _, fi := range fl {
+		entries = append(entries, dirEntry{fi})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
+
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	path, err := fsys.remotePath("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := fsys.n.ReadFileContext(fsys.ctx, path)
+	if err != nil {
+		return nil, pathError("readfile", name, err)
+	}
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, pathError("readfile", name, err)
+	}
+
+	return data, nil
+}
+
+// Sub は dir をルートにした FS を返す。dir が存在するかどうかは確認しない
+func (fsys *FS) Sub(dir string) (fs.FS, error) {
+	path, err := fsys.remotePath("sub", dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FS{n: fsys.n, ctx: fsys.ctx, root: path}, nil
+}
+
+// file は fs.File を実装する。読むときは Range リクエストで必要な部分だけを取得する
+type file struct {
+	fsys *FS
+	name string
+	path string
+	fi   fs.FileInfo
+
+	offset int64         // 次に Read する位置
+	body   io.ReadCloser // offset から読んでいる途中のレスポンス。Seek したら閉じる
+	closed bool
+}
+
+var (
+	_ io.Seeker   = (*file)(nil)
+	_ io.ReaderAt = (*file)(nil)
+)
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	return f.fi, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	if f.offset >= f.fi.Size() {
+		return 0, io.EOF
+	}
+
+	if f.body == nil {
+		body, err := f.fsys.n.ReadFileRangeContext(f.fsys.ctx, f.path, f.offset, -1)
+		if err != nil {
+			return 0, pathError("read", f.name, err)
+		}
+		f.body = body
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.fi.Size()
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+
+	f.offset = offset
+
+	return offset, nil
+}
+
+func (f *file) ReadAt(p []byte, offset int64) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	body, err := f.fsys.n.ReadFileRangeContext(f.fsys.ctx, f.path, offset, int64(len(p)))
+	if err != nil {
+		return 0, pathError("read", f.name, err)
+	}
+	defer body.Close()
+
+	n, err := io.ReadFull(body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+func (f *file) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	f.closed = true
+
+	if f.body != nil {
+		return f.body.Close()
+	}
+
+	return nil
+}
+
+// dir はディレクトリを開いたときの fs.ReadDirFile
+type dir struct {
+	fsys *FS
+	name string
+	path string
+	fi   fs.FileInfo
+
+	entries []fs.DirEntry // 最初の ReadDir で取得して、読んだ分だけ先頭から取り除く
+	read    bool
+	closed  bool
+}
+
+var _ fs.ReadDirFile = (*dir)(nil)
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "stat", Path: d.name, Err: fs.ErrClosed}
+	}
+
+	return d.fi, nil
+}
+
+func (d *dir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: fs.ErrClosed}
+	}
+
+	if !d.read {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.read = true
+	}
+
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+
+	return entries, nil
+}
+
+func (d *dir) Close() error {
+	if d.closed {
+		return &fs.PathError{Op: "close", Path: d.name, Err: fs.ErrClosed}
+	}
+
+	d.closed = true
+
+	return nil
+}
+
+// dirEntry は FileInfo をそのまま使う fs.DirEntry
+type dirEntry struct {
+	fi fs.FileInfo
+}
+
+func (e dirEntry) Name() string {
+	return e.fi.Name()
+}
+
+func (e dirEntry) IsDir() bool {
+	return e.fi.IsDir()
+}
+
+func (e dirEntry) Type() fs.FileMode {
+	return e.fi.Mode().Type()
+}
+
+func (e dirEntry) Info() (fs.FileInfo, error) {
+	return e.fi, nil
+}
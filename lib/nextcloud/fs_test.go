@@ -0,0 +1,227 @@
+package nextcloud
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	_path "path"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// fakeServer は PROPFIND と GET だけに答える、メモリ上の WebDAV サーバー
+type fakeServer struct {
+	files   map[string][]byte // パスとファイルの中身。親ディレクトリは自動で作られる
+	dirs    map[string]bool
+	modTime time.Time
+}
+
+func newFakeServer(t *testing.T, files map[string]string) *Nextcloud {
+	s := &fakeServer{
+		files:   map[string][]byte{},
+		dirs:    map[string]bool{"/": true},
+		modTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	for path, data := range files {
+		path = _path.Join("/", path)
+		s.files[path] = []byte(data)
+		for dir := _path.Dir(path); dir != "/"; dir = _path.Dir(dir) {
+			s.dirs[dir] = true
+		}
+	}
+
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	return New(srv.URL+"/", srv.Client(), nil)
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := _path.Join("/", r.URL.Path)
+
+	switch r.Method {
+	case "PROPFIND":
+		s.propfind(w, r, path)
+
+	case http.MethodGet:
+		data, ok := s.files[path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, _path.Base(path), s.modTime, bytes.NewReader(data))
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *fakeServer) propfind(w http.ResponseWriter, r *http.Request, path string) {
+	if _, ok := s.files[path]; !ok && !s.dirs[path] {
+		http.NotFound(w, r)
+		return
+	}
+
+	paths := []string{path}
+	if s.dirs[path] && r.Header.Get("Depth") == "1" {
+		var children []string
+		for p := range s.files {
+			if _path.Dir(p) == path {
+				children = append(children, p)
+			}
+		}
+		for p := range s.dirs {
+			if p != "/" && _path.Dir(p) == path {
+				children = append(children, p)
+			}
+		}
+		sort.Strings(children)
+		paths = append(paths, children...)
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteString(`<?xml version="1.0"?><d:multistatus xmlns:d="DAV:">`)
+	for _, p := range paths {
+		href := "/"
+		for _, name := range strings.Split(strings.TrimPrefix(p, "/"), "/") {
+			href = _path.Join(href, url.PathEscape(name))
+		}
+
+		fmt.Fprintf(&buf, `<d:response><d:href>%s</d:href><d:propstat><d:prop>`, escapeXML(href))
+		if p != "/" {
+			fmt.Fprintf(&buf, `<d:displayname>%s</d:displayname>`, escapeXML(_path.Base(p)))
+		}
+		fmt.Fprintf(&buf, `<d:getlastmodified>%s</d:getlastmodified>`, s.modTime.Format(http.TimeFormat))
+		if s.dirs[p] {
+			buf.WriteString(`<d:resourcetype><d:collection/></d:resourcetype>`)
+		} else {
+			fmt.Fprintf(&buf, `<d:resourcetype/><d:getcontentlength>%d</d:getcontentlength>`, len(s.files[p]))
+		}
+		buf.WriteString(`</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`)
+	}
+	buf.WriteString(`</d:multistatus>`)
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write(buf.Bytes())
+}
+
+func escapeXML(s string) string {
+	buf := strings.Builder{}
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+var testFiles = map[string]string{
+	"hello.txt":         "hello, world\n",
+	"dir/a.txt":         "aaa",
+	"dir/b c.txt":       "b and c",
+	"dir/sub/empty.txt": "",
+}
+
+func TestFS(t *testing.T) {
+	n := newFakeServer(t, testFiles)
+
+	if err := fstest.TestFS(n.FS(), "hello.txt", "dir/a.txt", "dir/b c.txt", "dir/sub/empty.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFSSub(t *testing.T) {
+	n := newFakeServer(t, testFiles)
+
+	sub, err := fs.Sub(n.FS(), "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fstest.TestFS(sub, "a.txt", "b c.txt", "sub/empty.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fs.ReadFile(sub, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "aaa" {
+		t.Errorf("ReadFile(a.txt) = %q, want %q", data, "aaa")
+	}
+
+	if _, err := fs.Sub(n.FS(), "../dir"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Sub(../dir) error = %v, want fs.ErrInvalid", err)
+	}
+}
+
+func TestFSReadFileNotExist(t *testing.T) {
+	n := newFakeServer(t, testFiles)
+
+	if _, err := fs.ReadFile(n.FS(), "missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadFile(missing.txt) error = %v, want fs.ErrNotExist", err)
+	}
+
+	if _, err := n.FS().Open("dir/missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open(dir/missing.txt) error = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestFileSeekAndReadAtEOF(t *testing.T) {
+	n := newFakeServer(t, testFiles)
+
+	f, err := n.FS().Open("hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	content := testFiles["hello.txt"]
+	size := int64(len(content))
+
+	seeker := f.(io.ReadSeeker)
+	readerAt := f.(io.ReaderAt)
+
+	if offset, err := seeker.Seek(0, io.SeekEnd); err != nil || offset != size {
+		t.Fatalf("Seek(0, SeekEnd) = %d, %v, want %d, nil", offset, err, size)
+	}
+
+	p := make([]byte, 4)
+	if n, err := seeker.Read(p); n != 0 || err != io.EOF {
+		t.Errorf("Read at EOF = %d, %v, want 0, EOF", n, err)
+	}
+
+	if n, err := readerAt.ReadAt(p, size); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt(size) = %d, %v, want 0, EOF", n, err)
+	}
+
+	if n, err := readerAt.ReadAt(p, size-2); n != 2 || err != io.EOF || string(p[:n]) != content[size-2:] {
+		t.Errorf("ReadAt(size-2) = %d, %v, %q, want 2, EOF, %q", n, err, p[:n], content[size-2:])
+	}
+
+	if n, err := readerAt.ReadAt(p, 0); n != len(p) || err != nil || string(p) != content[:len(p)] {
+		t.Errorf("ReadAt(0) = %d, %v, %q, want %d, nil, %q", n, err, p[:n], len(p), content[:len(p)])
+	}
+
+	if _, err := seeker.Seek(-6, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(seeker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != content[size-6:] {
+		t.Errorf("read after Seek(-6, SeekEnd) = %q, want %q", rest, content[size-6:])
+	}
+
+	if _, err := seeker.Seek(-1, io.SeekStart); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Seek(-1, SeekStart) error = %v, want fs.ErrInvalid", err)
+	}
+}
@@ -1,7 +1,11 @@
 package nextcloud
 
 import (
+	"context"
+	"errors"
+	"io"
 	"os"
+	_path "path"
 	"sort"
 	"strconv"
 )
@@ -18,11 +22,21 @@ import (
 //
 // 返り値: join後のファイル名 -> []元のFileInfoたち
 func (n *Nextcloud) ReadJoinedDir(path string) (map[string][][]os.FileInfo, error) {
-	fl, err := n.ReadDir(path)
+	return n.ReadJoinedDirContext(context.Background(), path)
+}
+
+func (n *Nextcloud) ReadJoinedDirContext(ctx context.Context, path string) (map[string][][]os.FileInfo, error) {
+	fl, err := n.ReadDirContext(ctx, path)
 	if err != nil {
 		return nil, err
 	}
 
+	return JoinFileInfos(fl), nil
+}
+
+// JoinFileInfos は ReadJoinedDir と同じ変換を、ReadDir などで取得済みの一覧に対して行う
+// fl は並べ替えられる
+func JoinFileInfos(fl []os.FileInfo) map[string][][]os.FileInfo {
 	// flがソート済みかわからないので、ファイル名でソートする
 	// ioutil.ReadDirはソートして返して、auto-split-joinはioutil.ReadDirを使っているので。
 	sort.Slice(fl, func(i, j int) bool {
@@ -90,7 +104,7 @@ func (n *Nextcloud) ReadJoinedDir(path string) (map[string][][]os.FileInfo, erro
 		i += n - 1
 	}
 
-	return result, nil
+	return result
 }
 
 func isFilled(v string, r rune) bool {
@@ -110,3 +124,175 @@ func splitPathExt(path string) (string, string) {
 	}
 	return path, ""
 }
+
+// JoinedFile は auto-split-join で分割されたファイルを、結合した一つのファイルとして読むためのもの
+// 分割されていないファイルの場合は Paths と FileInfos の長さが 1 になる
+type JoinedFile struct {
+	n *Nextcloud
+
+	Paths     []string
+	FileInfos []os.FileInfo
+}
+
+// OpenJoined は path のファイルを開く
+// path が存在せず path.000, path.001, ... が存在するときは、それらを結合したものとして開く
+func (n *Nextcloud) OpenJoined(path string) (*JoinedFile, error) {
+	return n.OpenJoinedContext(context.Background(), path)
+}
+
+func (n *Nextcloud) OpenJoinedContext(ctx context.Context, path string) (*JoinedFile, error) {
+	fi, err := n.StatContext(ctx, path)
+	if err == nil {
+		if fi.IsDir() {
+			return nil, &os.PathError{Op: "OpenJoined", Path: path, Err: errors.New("is a directory")}
+		}
+
+		return &JoinedFile{n: n, Paths: []string{path}, FileInfos: []os.FileInfo{fi}}, nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	fisMap, err1 := n.ReadJoinedDirContext(ctx, _path.Dir(path))
+	if err1 != nil {
+		return nil, err
+	}
+
+	fls := [][]os.FileInfo{}
+	for _, fl := range fisMap[_path.Base(path)] {
+		if len(fl) > 1 {
+			fls = append(fls, fl)
+		}
+	}
+
+	if len(fls) == 0 {
+		return nil, err
+	}
+
+	if len(fls) != 1 {
+		// 桁数の違う分割ファイルが混在していて、どれを結合すればよいかわからない
+		return nil, &os.PathError{Op: "OpenJoined", Path: path, Err: errors.New("name collision detected")}
+	}
+
+	f := &JoinedFile{n: n, Paths: []string{}, FileInfos: fls[0]}
+	for _, fi := range fls[0] {
+		f.Paths = append(f.Paths, _path.Join(_path.Dir(path), fi.Name()))
+	}
+
+	return f, nil
+}
+
+// Size は結合後のサイズを返す
+func (f *JoinedFile) Size() int64 {
+	size := int64(0)
+	for _, fi := range f.FileInfos {
+		size += fi.Size()
+	}
+	return size
+}
+
+// ETag は分割ファイルそれぞれの ETag をつなげたものを返す。いずれかが変わると変化する
+func (f *JoinedFile) ETag() string {
+	etag := ""
+	for _, fi := range f.FileInfos {
+		if nfi, ok := fi.(*FileInfo); ok {
+			etag += nfi.ETag()
+		}
+	}
+	return etag
+}
+
+// ReadRange は結合後のファイルの offset から length バイトを読む。length が負ならファイルの終わりまで読む
+// 必要な分割ファイルだけを、読む順番になってから Range リクエストで取得する
+func (f *JoinedFile) ReadRange(offset int64, length int64) io.ReadCloser {
+	return f.ReadRangeContext(context.Background(), offset, length)
+}
+
+func (f *JoinedFile) ReadRangeContext(ctx context.Context, offset int64, length int64) io.ReadCloser {
+	type part struct {
+		path   string
+		offset int64
+		length int64
+	}
+	parts := []part{}
+
+	for i, fi := range f.FileInfos {
+		if length == 0 {
+			break
+		}
+
+		if offset >= fi.Size() {
+			offset -= fi.Size()
+			continue
+		}
+
+		l := fi.Size() - offset
+		if length >= 0 && length < l {
+			l = length
+		}
+
+		parts = append(parts, part{path: f.Paths[i], offset: offset, length: l})
+
+		offset = 0
+		if length > 0 {
+			length -= l
+		}
+	}
+
+	r := &joinedReader{}
+	for _, part := range parts {
+		part := part
+		r.opens = append(r.opens, func() (io.ReadCloser, error) {
+			return f.n.ReadFileRangeContext(ctx, part.path, part.offset, part.length)
+		})
+	}
+
+	return r
+}
+
+type joinedReader struct {
+	opens   []func() (io.ReadCloser, error)
+	current io.ReadCloser
+}
+
+func (r *joinedReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.opens) == 0 {
+				return 0, io.EOF
+			}
+
+			rc, err := r.opens[0]()
+			if err != nil {
+				return 0, err
+			}
+
+			r.opens = r.opens[1:]
+			r.current = rc
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			err = r.current.Close()
+			r.current = nil
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		return n, err
+	}
+}
+
+func (r *joinedReader) Close() error {
+	r.opens = nil
+	if r.current == nil {
+		return nil
+	}
+
+	err := r.current.Close()
+	r.current = nil
+	return err
+}
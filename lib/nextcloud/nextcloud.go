@@ -1,9 +1,13 @@
 package nextcloud
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/kurusugawa-computer/nextcloud-cli/lib/webdav"
 )
@@ -12,6 +16,13 @@ func New(url string, httpClient *http.Client, authFunc webdav.AuthFunc) *Nextclo
 	nextcloud := Nextcloud{
 		URL: url,
 		w:   webdav.New(url, httpClient, authFunc),
+		dav: nil,
+	}
+
+	// chunked upload には remote.php/webdav ではなく remote.php/dav を使う
+	if base := strings.TrimSuffix(url, "/"); strings.HasSuffix(base, "/remote.php/webdav") {
+		base = strings.TrimSuffix(base, "webdav") + "dav/"
+		nextcloud.dav = webdav.New(base, httpClient, authFunc)
 	}
 
 	return &nextcloud
@@ -20,10 +31,32 @@ func New(url string, httpClient *http.Client, authFunc webdav.AuthFunc) *Nextclo
 type Nextcloud struct {
 	URL string
 	w   *webdav.WebDAV
+	dav *webdav.WebDAV // remote.php/dav。URL が remote.php/webdav でないときは nil
+
+	cache *Cache // nil ならキャッシュを使わない
 }
 
 func (n *Nextcloud) Stat(path string) (os.FileInfo, error) {
-	responses, err := n.w.Propfind(path, webdav.Depth0, propfind)
+	return n.StatContext(context.Background(), path)
+}
+
+// 名前が Context で終わるメソッドは、ctx がキャンセルされると通信を中断してエラーを返す
+// そのとき返すエラーは errors.Is(err, context.Canceled) で判定できる
+func (n *Nextcloud) StatContext(ctx context.Context, path string) (os.FileInfo, error) {
+	if n.cache != nil {
+		if fi, ok := n.cache.lookup(path); ok {
+			if fi == nil {
+				return nil, &os.PathError{Op: "Stat", Path: path, Err: os.ErrNotExist}
+			}
+			return fi, nil
+		}
+	}
+
+	return n.stat(ctx, path)
+}
+
+func (n *Nextcloud) stat(ctx context.Context, path string) (os.FileInfo, error) {
+	responses, err := n.w.PropfindContext(ctx, path, webdav.Depth0, propfind)
 	if err != nil {
 		return nil, &os.PathError{Op: "Stat", Path: path, Err: webdavError(err)}
 	}
@@ -44,54 +77,132 @@ func (n *Nextcloud) Stat(path string) (os.FileInfo, error) {
 }
 
 func (n *Nextcloud) ReadFile(path string) (io.ReadCloser, error) {
-	body, err := n.w.Get(path)
+	return n.ReadFileContext(context.Background(), path)
+}
+
+func (n *Nextcloud) ReadFileContext(ctx context.Context, path string) (io.ReadCloser, error) {
+	body, _, err := n.GetFileContext(ctx, path)
+	return body, err
+}
+
+// GetFile は ReadFile と同じだが、読んだ内容の ETag などのレスポンスヘッダーも返す
+func (n *Nextcloud) GetFile(path string) (io.ReadCloser, *webdav.Header, error) {
+	return n.GetFileContext(context.Background(), path)
+}
+
+func (n *Nextcloud) GetFileContext(ctx context.Context, path string) (io.ReadCloser, *webdav.Header, error) {
+	body, header, err := n.w.GetContext(ctx, path)
+	if err != nil {
+		return nil, nil, &os.PathError{Op: "ReadFile", Path: path, Err: webdavError(err)}
+	}
+
+	return body, header, nil
+}
+
+// ReadFileRange は offset から length バイトだけを読む。length が負ならファイルの終わりまで読む
+func (n *Nextcloud) ReadFileRange(path string, offset int64, length int64) (io.ReadCloser, error) {
+	return n.ReadFileRangeContext(context.Background(), path, offset, length)
+}
+
+func (n *Nextcloud) ReadFileRangeContext(ctx context.Context, path string, offset int64, length int64) (io.ReadCloser, error) {
+	body, _, err := n.w.GetRangeContext(ctx, path, offset, length)
 	if err != nil {
-		return nil, &os.PathError{Op: "ReadFile", Path: path, Err: webdavError(err)}
+		return nil, &os.PathError{Op: "ReadFileRange", Path: path, Err: webdavError(err)}
 	}
 
 	return body, nil
 }
 
-func (n *Nextcloud) WriteFile(path string, body io.Reader) error {
-	if err := n.w.Put(path, body); err != nil {
-		return &os.PathError{Op: "WriteFile", Path: path, Err: webdavError(err)}
+// WriteFile は path に body を書き込む
+// conds に webdav.IfNoneMatch("*") を指定して既に存在したときは os.ErrExist を返す
+// webdav.IfMatch などの条件を満たさなかったときは webdav.ErrPrecondition のエラーを返す
+func (n *Nextcloud) WriteFile(path string, body io.Reader, conds ...webdav.Condition) error {
+	return n.WriteFileContext(context.Background(), path, body, conds...)
+}
+
+func (n *Nextcloud) WriteFileContext(ctx context.Context, path string, body io.Reader, conds ...webdav.Condition) error {
+	_, err := n.PutFileContext(ctx, path, body, conds...)
+	return err
+}
+
+// PutFile は WriteFile と同じだが、書き込んだ後の ETag などのレスポンスヘッダーも返す
+func (n *Nextcloud) PutFile(path string, body io.Reader, conds ...webdav.Condition) (*webdav.Header, error) {
+	return n.PutFileContext(context.Background(), path, body, conds...)
+}
+
+func (n *Nextcloud) PutFileContext(ctx context.Context, path string, body io.Reader, conds ...webdav.Condition) (*webdav.Header, error) {
+	header, err := n.w.PutContext(ctx, path, body, conds...)
+	if err != nil {
+		return nil, &os.PathError{Op: "WriteFile", Path: path, Err: webdavError(err)}
 	}
 
-	return nil
+	n.invalidate(path)
+
+	return header, nil
 }
 
 func (n *Nextcloud) ReadDir(path string) ([]os.FileInfo, error) {
-	responses, err := n.w.Propfind(path, webdav.Depth1, propfind)
+	return n.ReadDirContext(context.Background(), path)
+}
+
+func (n *Nextcloud) ReadDirContext(ctx context.Context, path string) ([]os.FileInfo, error) {
+	if n.cache != nil {
+		return n.readDirCached(ctx, path)
+	}
+
+	_, fl, err := n.readDir(ctx, path)
 	if err != nil {
-		return nil, &os.PathError{Op: "ReadDir", Path: path, Err: webdavError(err)}
+		return nil, err
 	}
 
-	// TODO: もう少しマシな、自身を除く処理
-	responses = responses[1:]
+	return fileInfos(fl), nil
+}
+
+// readDir は path 自身と、その中身を返す
+func (n *Nextcloud) readDir(ctx context.Context, path string) (*FileInfo, []*FileInfo, error) {
+	responses, err := n.w.PropfindContext(ctx, path, webdav.Depth1, propfind)
+	if err != nil {
+		return nil, nil, &os.PathError{Op: "ReadDir", Path: path, Err: webdavError(err)}
+	}
+
+	if len(responses) <= 0 {
+		return nil, nil, &os.PathError{Op: "ReadDir", Path: path, Err: os.ErrNotExist}
+	}
 
-	fl := make([]os.FileInfo, 0, len(responses))
+	fl := make([]*FileInfo, 0, len(responses))
 	for _, response := range responses {
 		fi, err := fileInfo(response)
 		if err != nil {
-			return nil, &os.PathError{Op: "ReadDir", Path: path, Err: os.ErrInvalid}
+			return nil, nil, &os.PathError{Op: "ReadDir", Path: path, Err: os.ErrInvalid}
 		}
 
-		fl = append(fl, fi)
+		fl = append(fl, fi.(*FileInfo))
 	}
 
-	return fl, nil
+	// TODO: もう少しマシな、自身を除く処理
+	return fl[0], fl[1:], nil
 }
 
 func (n *Nextcloud) Mkdir(path string) error {
-	if err := n.w.Mkcol(path); err != nil {
+	return n.MkdirContext(context.Background(), path)
+}
+
+func (n *Nextcloud) MkdirContext(ctx context.Context, path string) error {
+	if err := n.w.MkcolContext(ctx, path); err != nil {
 		return &os.PathError{Op: "Mkdir", Path: path, Err: webdavError(err)}
 	}
 
+	n.invalidate(path)
+
 	return nil
 }
 
 func (n *Nextcloud) MkdirAll(path string) error {
-	fi, err := n.Stat(path)
+	return n.MkdirAllContext(context.Background(), path)
+}
+
+func (n *Nextcloud) MkdirAllContext(ctx context.Context, path string) error {
+	fi, err := n.StatContext(ctx, path)
 	if err == nil {
 		if fi.IsDir() {
 			return nil
@@ -110,15 +221,15 @@ func (n *Nextcloud) MkdirAll(path string) error {
 	}
 
 	if j > 1 {
-		err = n.MkdirAll(path[0 : j-1])
+		err = n.MkdirAllContext(ctx, path[0:j-1])
 		if err != nil {
 			return err
 		}
 	}
 
-	err = n.Mkdir(path)
+	err = n.MkdirContext(ctx, path)
 	if err != nil {
-		dir, err1 := n.Stat(path)
+		dir, err1 := n.StatContext(ctx, path)
 		if err1 == nil && dir.IsDir() {
 			return nil
 		}
@@ -128,9 +239,80 @@ func (n *Nextcloud) MkdirAll(path string) error {
 	return nil
 }
 
-func (n *Nextcloud) Delete(path string) error {
-	if err := n.w.Delete(path); err != nil {
+// Chtimes は更新日時を変更する
+func (n *Nextcloud) Chtimes(path string, mtime time.Time) error {
+	return n.ChtimesContext(context.Background(), path, mtime)
+}
+
+func (n *Nextcloud) ChtimesContext(ctx context.Context, path string, mtime time.Time) error {
+	payload := []byte(`<d:propertyupdate xmlns:d="DAV:">
+<d:set>
+	<d:prop>
+		<d:lastmodified>` + strconv.FormatInt(mtime.Unix(), 10) + `</d:lastmodified>
+	</d:prop>
+</d:set>
+</d:propertyupdate>`)
+
+	responses, err := n.w.ProppatchContext(ctx, path, payload)
+	if err != nil {
+		return &os.PathError{Op: "Chtimes", Path: path, Err: webdavError(err)}
+	}
+
+	n.invalidate(path)
+
+	for _, response := range responses {
+		for _, prop := range response.Props {
+			switch prop.Status.StatusCode {
+			case http.StatusOK:
+
+			case http.StatusUnauthorized, http.StatusForbidden:
+				return &os.PathError{Op: "Chtimes", Path: path, Err: os.ErrPermission}
+
+			default:
+				return &os.PathError{Op: "Chtimes", Path: path, Err: os.ErrInvalid}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Delete は path を削除する
+// conds に webdav.IfMatch などを指定すると、条件を満たさなかったときに webdav.ErrPrecondition のエラーを返す
+func (n *Nextcloud) Delete(path string, conds ...webdav.Condition) error {
+	return n.DeleteContext(context.Background(), path, conds...)
+}
+
+func (n *Nextcloud) DeleteContext(ctx context.Context, path string, conds ...webdav.Condition) error {
+	if err := n.w.DeleteContext(ctx, path, conds...); err != nil {
 		return &os.PathError{Op: "Delete", Path: path, Err: webdavError(err)}
 	}
+
+	n.invalidate(path)
+
+	return nil
+}
+
+// Move は src を dst に移動する。overwrite が false で dst が存在するときは os.ErrExist を返す
+// conds の条件は src に対して評価され、満たさなかったときは webdav.ErrPrecondition のエラーを返す
+func (n *Nextcloud) Move(src string, dst string, overwrite bool, conds ...webdav.Condition) error {
+	return n.MoveContext(context.Background(), src, dst, overwrite, conds...)
+}
+
+func (n *Nextcloud) MoveContext(ctx context.Context, src string, dst string, overwrite bool, conds ...webdav.Condition) error {
+	if err := n.w.MoveContext(ctx, src, dst, overwrite, conds...); err != nil {
+		return &os.PathError{Op: "Move", Path: src, Err: webdavError(err)}
+	}
+
+	n.invalidate(src)
+	n.invalidate(dst)
+
 	return nil
 }
+
+// invalidate は path を変更したときに、キャッシュから古い情報を消す
+func (n *Nextcloud) invalidate(path string) {
+	if n.cache != nil {
+		n.cache.invalidate(path)
+	}
+}
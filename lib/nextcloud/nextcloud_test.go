@@ -0,0 +1,48 @@
+package nextcloud
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/webdav"
+)
+
+func TestPreconditionFailed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+	}))
+	defer srv.Close()
+
+	n := New(srv.URL+"/", srv.Client(), nil)
+
+	// If-None-Match: * と Overwrite: F の 412 は、既に存在するという意味
+	err := n.WriteFile("a.txt", strings.NewReader("a"), webdav.IfNoneMatch("*"))
+	if !errors.Is(err, os.ErrExist) || webdav.IsPrecondition(err) {
+		t.Errorf("WriteFile(If-None-Match: *) error = %v, want os.ErrExist", err)
+	}
+
+	err = n.Move("a.txt", "b.txt", false)
+	if !errors.Is(err, os.ErrExist) || webdav.IsPrecondition(err) {
+		t.Errorf("Move(overwrite = false) error = %v, want os.ErrExist", err)
+	}
+
+	// If-Match の 412 は、ほかから変更されたという意味
+	err = n.WriteFile("a.txt", strings.NewReader("a"), webdav.IfMatch("etag"))
+	if errors.Is(err, os.ErrExist) || !webdav.IsPrecondition(err) {
+		t.Errorf("WriteFile(If-Match) error = %v, want webdav.ErrPrecondition", err)
+	}
+
+	err = n.Move("a.txt", "b.txt", true, webdav.IfMatch("etag"))
+	if errors.Is(err, os.ErrExist) || !webdav.IsPrecondition(err) {
+		t.Errorf("Move(If-Match) error = %v, want webdav.ErrPrecondition", err)
+	}
+
+	err = n.Delete("a.txt", webdav.IfMatch("etag"))
+	if errors.Is(err, os.ErrExist) || !webdav.IsPrecondition(err) {
+		t.Errorf("Delete(If-Match) error = %v, want webdav.ErrPrecondition", err)
+	}
+}
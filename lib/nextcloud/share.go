@@ -0,0 +1,58 @@
+package nextcloud
+
+import (
+	"net/url"
+	_path "path"
+	"regexp"
+	"strings"
+)
+
+// Share は公開リンク (https://host/s/TOKEN) で共有されたファイルやディレクトリ
+// public.php/webdav に、トークンをユーザー名、共有のパスワードをパスワードにしてアクセスする
+type Share struct {
+	Link  string // 公開リンクの URL。パスは含まない
+	URL   string // public.php/webdav の URL
+	Token string
+	Path  string // 共有の中のパス
+}
+
+var sharePathRegexp = regexp.MustCompile(`^(.*?)/(?:index\.php/)?s/([A-Za-z0-9]+)(/.*)?$`)
+
+// ParseShareURL は公開リンクを Share にする。公開リンクでなければ false を返す
+// 共有の中のパスは、リンクの後ろに付けるか (https://host/s/TOKEN/dir/file)、
+// Web 画面と同じように path クエリで指定する (https://host/s/TOKEN?path=/dir)
+func ParseShareURL(rawURL string) (*Share, bool) {
+	if !strings.HasPrefix(rawURL, "http://") && !strings.HasPrefix(rawURL, "https://") {
+		return nil, false
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, false
+	}
+
+	m := sharePathRegexp.FindStringSubmatch(u.Path)
+	if m == nil {
+		return nil, false
+	}
+
+	base, token, path := m[1], m[2], m[3]
+
+	link := *u
+	link.Path = base + "/s/" + token
+	link.RawPath = ""
+	link.RawQuery = ""
+	link.Fragment = ""
+
+	dav := link
+	dav.Path = base + "/public.php/webdav/"
+
+	share := &Share{
+		Link:  link.String(),
+		URL:   dav.String(),
+		Token: token,
+		Path:  _path.Join("/", u.Query().Get("path"), path),
+	}
+
+	return share, true
+}
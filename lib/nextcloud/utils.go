@@ -1,26 +1,30 @@
 package nextcloud
 
 import (
-	"os"
+	"errors"
 
 	"github.com/kurusugawa-computer/nextcloud-cli/lib/webdav"
 )
 
-func webdavError(err error) error {
-	switch webdav.TypeOf(err) {
-	case webdav.ErrInvalid:
-		return os.ErrInvalid
-
-	case webdav.ErrPermission:
-		return os.ErrPermission
+// remoteError は webdav.Error を os.PathError に入れるためのエラー
+// メッセージは "file does not exist" のように短くし、リクエストの詳細は errors.As で webdav.Error を取り出して調べる
+type remoteError struct {
+	err *webdav.Error
+}
 
-	case webdav.ErrExist:
-		return os.ErrExist
+func (e *remoteError) Error() string {
+	return e.err.Description()
+}
 
-	case webdav.ErrNotExist:
-		return os.ErrNotExist
+func (e *remoteError) Unwrap() error {
+	return e.err
+}
 
-	default:
-		return os.ErrInvalid
+func webdavError(err error) error {
+	var e *webdav.Error
+	if !errors.As(err, &e) {
+		return err
 	}
+
+	return &remoteError{err: e}
 }
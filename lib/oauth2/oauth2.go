@@ -0,0 +1,218 @@
+package oauth2
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Config は OAuth2 クライアントの設定
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	AuthURL      string // 認可エンドポイント
+	TokenURL     string // トークンエンドポイント
+	RedirectURL  string // 認可コードを受け取る URL。localhost で待ち受ける
+}
+
+// NextcloudConfig は Nextcloud の OAuth2 アプリを使うときの Config を返す
+// baseURL は Nextcloud のルートの URL
+func NextcloudConfig(baseURL string, clientID string, clientSecret string, redirectURL string) *Config {
+	base := strings.TrimSuffix(baseURL, "/")
+
+	return &Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      base + "/index.php/apps/oauth2/authorize",
+		TokenURL:     base + "/index.php/apps/oauth2/api/v1/token",
+		RedirectURL:  redirectURL,
+	}
+}
+
+// Token はアクセストークンとリフレッシュトークン
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	Expiry       time.Time `json:"expiry"`  // ゼロ値なら期限なし
+	UserID       string    `json:"user_id"` // Nextcloud のユーザー ID
+}
+
+// expired は token の期限が切れているか、もうすぐ切れるかどうかを返す
+func (t *Token) expired() bool {
+	return !t.Expiry.IsZero() && time.Now().Add(30*time.Second).After(t.Expiry)
+}
+
+// Authorize は authorization code flow でトークンを取得する
+// RedirectURL で待ち受けてから openURL で認可画面の URL を開き、リダイレクトされてくるまで待つ
+func (c *Config) Authorize(ctx context.Context, client *http.Client, openURL func(string) error) (*Token, error) {
+	redirect, err := url.Parse(c.RedirectURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// ほかのホストから認可コードを送られないように、待ち受けるのはループバックだけにする
+	if host := redirect.Hostname(); host != "localhost" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return nil, fmt.Errorf("redirect url must be localhost: %s", c.RedirectURL)
+		}
+	}
+
+	if redirect.Port() == "" {
+		return nil, fmt.Errorf("redirect url must have a port: %s", c.RedirectURL)
+	}
+
+	state := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, state); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", redirect.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+
+	type result struct {
+		code string
+		err  error
+	}
+	ch := make(chan result, 1)
+
+	path := redirect.Path
+	if path == "" {
+		path = "/"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		var res result
+		switch {
+		case query.Get("state") != hex.EncodeToString(state):
+			res.err = errors.New("invalid oauth2 state")
+		case query.Get("error") != "":
+			res.err = fmt.Errorf("oauth2 authorization failed: %s", query.Get("error"))
+		case query.Get("code") == "":
+			res.err = errors.New("oauth2 authorization failed: no code")
+		default:
+			res.code = query.Get("code")
+		}
+
+		if res.err != nil {
+			http.Error(w, res.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "Login succeeded. You can close this window.")
+		}
+
+		select {
+		case ch <- res:
+		default:
+		}
+	})
+
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	defer server.Close()
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", c.ClientID)
+	query.Set("redirect_uri", c.RedirectURL)
+	query.Set("state", hex.EncodeToString(state))
+
+	if err := openURL(c.AuthURL + "?" + query.Encode()); err != nil {
+		return nil, err
+	}
+
+	var res result
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res = <-ch:
+	}
+
+	if res.err != nil {
+		return nil, res.err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", res.code)
+	form.Set("redirect_uri", c.RedirectURL)
+
+	return c.token(ctx, client, form)
+}
+
+// Refresh はリフレッシュトークンで新しいトークンを取得する
+// Nextcloud はリフレッシュトークンも新しくするので、返ってきたトークンは保存しなおすこと
+func (c *Config) Refresh(ctx context.Context, client *http.Client, refreshToken string) (*Token, error) {
+	if refreshToken == "" {
+		return nil, errors.New("no refresh token")
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", refreshToken)
+
+	return c.token(ctx, client, form)
+}
+
+func (c *Config) token(ctx context.Context, client *http.Client, form url.Values) (*Token, error) {
+	form.Set("client_id", c.ClientID)
+	form.Set("client_secret", c.ClientSecret)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, fmt.Errorf("failed to get oauth2 token: %s", resp.Status)
+	}
+
+	v := struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int64  `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		UserID       string `json:"user_id"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return nil, fmt.Errorf("failed to get oauth2 token: %w", err)
+	}
+
+	if v.AccessToken == "" {
+		return nil, errors.New("failed to get oauth2 token: no access token")
+	}
+
+	token := &Token{
+		AccessToken:  v.AccessToken,
+		RefreshToken: v.RefreshToken,
+		UserID:       v.UserID,
+	}
+	if v.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(v.ExpiresIn) * time.Second)
+	}
+
+	return token, nil
+}
@@ -0,0 +1,141 @@
+package oauth2
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Transport はリクエストにアクセストークンを付ける http.RoundTripper
+// トークンの期限が切れそうなときと 401 が返ってきたときは、リフレッシュしてから送りなおす
+// 送りなおすのは一度だけで、ボディを読みなおせないリクエスト (GetBody がないもの) は送りなおさない
+type Transport struct {
+	Base   http.RoundTripper // nil なら http.DefaultTransport
+	Config *Config
+
+	// OnRefresh はトークンをリフレッシュしたときに呼ばれる。新しいトークンを保存するのに使う
+	// エラーを返しても警告を表示するだけで、リクエストは新しいトークンで続ける
+	OnRefresh func(*Token) error
+
+	m     sync.Mutex
+	token *Token
+}
+
+// NewTransport は token を使う Transport を返す
+func NewTransport(base http.RoundTripper, config *Config, token *Token, onRefresh func(*Token) error) *Transport {
+	return &Transport{
+		Base:      base,
+		Config:    config,
+		OnRefresh: onRefresh,
+
+		token: token,
+	}
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+	return t.Base
+}
+
+// Token は今使っているトークンを返す
+func (t *Transport) Token() *Token {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	return t.token
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := t.Token()
+
+	if token.expired() {
+		refreshed, err := t.refresh(req, token)
+		if err != nil {
+			return nil, err
+		}
+		token = refreshed
+	}
+
+	resp, err := t.base().RoundTrip(t.authorize(req, req.Body, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// ボディを読みなおせなければ、401 をそのまま返す
+	body := io.ReadCloser(nil)
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return resp, nil
+		}
+
+		b, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		body = b
+	}
+
+	refreshed, err := t.refresh(req, token)
+	if err != nil {
+		if body != nil {
+			body.Close()
+		}
+		return resp, nil
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	return t.base().RoundTrip(t.authorize(req, body, refreshed))
+}
+
+// authorize は req にアクセストークンを付けたコピーを返す。RoundTripper は元のリクエストを変更してはいけない
+func (t *Transport) authorize(req *http.Request, body io.ReadCloser, token *Token) *http.Request {
+	r := req.Clone(req.Context())
+	r.Body = body
+	r.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	return r
+}
+
+// refresh はトークンをリフレッシュする
+// 並行したリクエストがそれぞれリフレッシュしないように、ほかでリフレッシュ済みならそれを使う
+func (t *Transport) refresh(req *http.Request, old *Token) (*Token, error) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if t.token != old {
+		return t.token, nil
+	}
+
+	// リフレッシュのリクエストにはこの Transport を使わない
+	client := &http.Client{Transport: t.base()}
+
+	token, err := t.Config.Refresh(req.Context(), client, old.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.RefreshToken == "" {
+		token.RefreshToken = old.RefreshToken
+	}
+	if token.UserID == "" {
+		token.UserID = old.UserID
+	}
+
+	t.token = token
+
+	// 古いリフレッシュトークンはもう使えないので、保存に失敗してもリクエストは新しいトークンで続ける
+	if t.OnRefresh != nil {
+		if err := t.OnRefresh(token); err != nil {
+			fmt.Fprintln(os.Stderr, "WARNING: failed to save the refreshed token: "+err.Error())
+			fmt.Fprintln(os.Stderr, "WARNING: you may need to login again next time.")
+		}
+	}
+
+	return token, nil
+}
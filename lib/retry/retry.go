@@ -0,0 +1,221 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Policy はリトライの回数と待ち時間
+type Policy struct {
+	Max      int           // 最初の一回を除いたリトライ回数
+	MinDelay time.Duration // 一回目のリトライの待ち時間の目安。二回目以降は倍にしていく
+	MaxDelay time.Duration // 待ち時間の上限。Retry-After もこれより長くは待たない
+}
+
+// DefaultPolicy はリトライ回数だけを指定したときの Policy
+func DefaultPolicy(max int) Policy {
+	return Policy{
+		Max:      max,
+		MinDelay: 1 * time.Second,
+		MaxDelay: 30 * time.Second,
+	}
+}
+
+// Backoff は n 回目 (0 から数える) のリトライまでの待ち時間を返す
+// 指数的に増やした時間の半分から全部までの間でランダムにして、一斉にリトライしないようにする
+func (p Policy) Backoff(n int) time.Duration {
+	d := p.MinDelay
+	for i := 0; i < n && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	if d <= 0 {
+		return 0
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Sleep は d だけ待つ。ctx がキャンセルされたらすぐに ctx.Err() を返す
+func Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Permanent は err がリトライしても結果が変わらないエラーかどうかを返す
+// Transport でリトライできなかった、ボディを流しながら送るリクエストなどを上のレイヤーでリトライするときに使う
+func Permanent(err error) bool {
+	return errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, os.ErrExist) ||
+		errors.Is(err, os.ErrNotExist) ||
+		errors.Is(err, os.ErrPermission)
+}
+
+// Transport は一時的なエラーのときにリクエストを送りなおす http.RoundTripper
+//
+//	通信エラー、500, 502, 504 : 冪等なリクエストと DELETE、MKCOL のときだけ送りなおす
+//	423, 429, 503             : サーバーが処理していないので、どのリクエストでも送りなおす
+//
+// 429 と 503 で Retry-After があれば、その時間だけ待つ
+// ボディを読みなおせないリクエスト (GetBody がないもの) は送りなおさない
+type Transport struct {
+	Base   http.RoundTripper // nil なら http.DefaultTransport
+	Policy Policy
+
+	// OnRetry は送りなおす前に呼ばれる。resp と err はどちらかが nil
+	OnRetry func(req *http.Request, resp *http.Response, err error, delay time.Duration)
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+	return t.Base
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for n := 0; ; n++ {
+		r := req
+		if n > 0 {
+			r = req.Clone(req.Context())
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				r.Body = body
+			}
+		}
+
+		resp, err := t.base().RoundTrip(r)
+
+		delay, ok := t.retry(req, resp, err, n)
+		if !ok {
+			return resp, err
+		}
+
+		if t.OnRetry != nil {
+			t.OnRetry(req, resp, err, delay)
+		}
+
+		if retried, ok := req.Context().Value(retriedKey{}).(*int32); ok {
+			atomic.StoreInt32(retried, 1)
+		}
+
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if err := Sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retry は n 回目のリクエストの結果を見て、送りなおすかどうかと待ち時間を返す
+func (t *Transport) retry(req *http.Request, resp *http.Response, err error, n int) (time.Duration, bool) {
+	if n >= t.Policy.Max {
+		return 0, false
+	}
+
+	if req.Context().Err() != nil {
+		return 0, false
+	}
+
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return 0, false
+	}
+
+	if err != nil {
+		return t.Policy.Backoff(n), idempotent(req)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		if d, ok := retryAfter(resp); ok {
+			if d > t.Policy.MaxDelay {
+				d = t.Policy.MaxDelay
+			}
+			return d, true
+		}
+		return t.Policy.Backoff(n), true
+
+	case http.StatusLocked:
+		return t.Policy.Backoff(n), true
+
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return t.Policy.Backoff(n), idempotent(req)
+
+	default:
+		return 0, false
+	}
+}
+
+// idempotent は何度送っても結果が同じになるリクエストかどうかを返す
+// DELETE と MKCOL は、一回目をサーバーが処理していると二回目が 404 や 405 になるが、呼び出し側で Retried を見て成功とみなす
+// MOVE は二回目で移動元がなくなっているので含めない
+// If-Match や If-None-Match の付いた PUT なども、一回目で ETag が変わって二回目が 412 になるので含めない
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, "PROPFIND", "MKCOL":
+		return true
+	case http.MethodPut, http.MethodDelete, "PROPPATCH":
+		return req.Header.Get("If-Match") == "" && req.Header.Get("If-None-Match") == ""
+	default:
+		return false
+	}
+}
+
+type retriedKey struct{}
+
+// Retried は、返す context で送ったリクエストを Transport が送りなおしたかどうかを調べられるようにする
+// 返す関数は、一度でも送りなおしていたら true を返す
+func Retried(ctx context.Context) (context.Context, func() bool) {
+	retried := new(int32)
+	return context.WithValue(ctx, retriedKey{}, retried), func() bool {
+		return atomic.LoadInt32(retried) == 1
+	}
+}
+
+// retryAfter は Retry-After ヘッダーの秒数か日時から、待ち時間を返す
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+
+	return 0, false
+}
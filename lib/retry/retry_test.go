@@ -0,0 +1,104 @@
+package retry
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// failOnce は最初のリクエストにだけ status を返し、それ以降は 200 を返すサーバーを立てて、リクエストの数を返す関数と一緒に返す
+// status が 0 なら、最初のリクエストは応答せずに接続を切る
+func failOnce(t *testing.T, status int) (*httptest.Server, func() int) {
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&n, 1) == 1 {
+			if status == 0 {
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err == nil {
+					conn.Close()
+				}
+				return
+			}
+			w.WriteHeader(status)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() int { return int(atomic.LoadInt32(&n)) }
+}
+
+func TestTransport(t *testing.T) {
+	tests := []struct {
+		method string
+		header string // 付ける条件のヘッダー
+		status int
+		want   int // 送ったリクエストの数
+	}{
+		{http.MethodGet, "", http.StatusBadGateway, 2},
+		{"PROPFIND", "", http.StatusInternalServerError, 2},
+		{http.MethodPut, "", http.StatusGatewayTimeout, 2},
+		{http.MethodPut, "If-Match", http.StatusBadGateway, 1},
+		{http.MethodPut, "If-None-Match", http.StatusBadGateway, 1},
+		{http.MethodDelete, "", http.StatusBadGateway, 2},
+		{http.MethodDelete, "If-Match", http.StatusBadGateway, 1},
+		{http.MethodDelete, "", 0, 2},
+		{"MKCOL", "", http.StatusBadGateway, 2},
+		{"MOVE", "", http.StatusBadGateway, 1},
+		{"MOVE", "", 0, 1},
+		{http.MethodPost, "", http.StatusInternalServerError, 1},
+		{http.MethodPost, "", http.StatusServiceUnavailable, 2},
+		{"MOVE", "", http.StatusLocked, 2},
+		{http.MethodGet, "", http.StatusNotFound, 1},
+	}
+
+	for _, tt := range tests {
+		srv, count := failOnce(t, tt.status)
+
+		req, err := http.NewRequest(tt.method, srv.URL, bytes.NewReader([]byte("body")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.header != "" {
+			req.Header.Set(tt.header, `"etag"`)
+		}
+
+		client := &http.Client{Transport: &Transport{Policy: Policy{Max: 2}}}
+		if resp, err := client.Do(req); err == nil {
+			resp.Body.Close()
+		} else if tt.status != 0 {
+			t.Fatal(err)
+		}
+
+		if got := count(); got != tt.want {
+			t.Errorf("%s %s (%d): sent %d requests, want %d", tt.method, tt.header, tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestRetried(t *testing.T) {
+	for _, status := range []int{http.StatusBadGateway, http.StatusOK} {
+		srv, _ := failOnce(t, status)
+
+		ctx, retried := Retried(context.Background())
+		req, err := http.NewRequestWithContext(ctx, http.MethodDelete, srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		client := &http.Client{Transport: &Transport{Policy: Policy{Max: 2}}}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if want := status != http.StatusOK; retried() != want {
+			t.Errorf("first response %d: retried() = %v, want %v", status, retried(), want)
+		}
+	}
+}
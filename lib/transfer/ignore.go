@@ -0,0 +1,113 @@
+package transfer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	_path "path"
+	"strings"
+)
+
+type ignorePattern struct {
+	ptn      string
+	path     string
+	dir      bool
+	fileName bool
+	neg      bool
+}
+
+func readIgnoreFile(ctx *ctx, src string) ([]ignorePattern, error) {
+	path := _path.Join(src, ".nextcloudignore")
+
+	file, err := ctx.src.Open(ctx.context, path)
+	if errors.Is(err, os.ErrNotExist) {
+		// nextcloudignore なし
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error occurred while opening nextcloudignore file %#v: %w", path, err)
+	}
+	defer file.Close()
+
+	ptns := []ignorePattern{}
+
+	// ファイルを1行ずつ処理
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		s := scanner.Text()
+
+		// Empty, Comment
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+
+		p := ignorePattern{}
+
+		// Negate
+		if strings.HasPrefix(s, "!") {
+			p.neg = true
+			s = strings.TrimPrefix(s, "!")
+		}
+
+		// Dictionary Only
+		if strings.HasSuffix(s, "/") {
+			p.dir = true
+			s = strings.TrimSuffix(s, "/")
+		} else {
+			p.dir = false
+		}
+
+		if strings.Contains(s, "/") {
+			// .nextcloudignoreからの相対パスで検索
+			p.fileName = false
+			if !strings.HasPrefix(s, "/") {
+				s = "/" + s
+			}
+		} else {
+			// ファイル名で検索
+			p.fileName = true
+		}
+
+		p.ptn = s
+		p.path = src
+		ptns = append(ptns, p)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error occurred while scanning nextcloudignore file %#v: %w", path, err)
+	}
+
+	return ptns, nil
+}
+
+// ignored は path が ptns のどれかにマッチして、コピーしないものかどうかを返す
+func ignored(ptns []ignorePattern, path string, dir bool) bool {
+	ignore := false
+
+	for _, ptn := range ptns {
+		if ptn.dir && !dir {
+			// ディレクトリのパターンで、対象パスがディレクトリでない
+			continue
+		}
+
+		// パターン照合。不正なパターンはマッチしないものとする
+		if m, _ := matchPattern(ptn, path); m {
+			ignore = !ptn.neg // negeteパターンの場合はignoreをtrueにする、そうでない場合はfalseにする
+		}
+	}
+
+	return ignore
+}
+
+func matchPattern(ptn ignorePattern, path string) (bool, error) {
+	p := ptn.ptn
+	if ptn.fileName {
+		// ファイル名で検索
+		path = _path.Base(path)
+	} else {
+		// .nextcloudignoreからの相対パスで検索
+		p = _path.Join(ptn.path, ptn.ptn)
+	}
+
+	return _path.Match(p, path)
+}
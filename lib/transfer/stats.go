@@ -0,0 +1,238 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	_path "path"
+	"sync/atomic"
+	"time"
+
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/nextcloud"
+	"gopkg.in/cheggaaa/pb.v1"
+)
+
+// stats は全体の進捗を数える。atomic 経由で読み書きすべし
+type stats struct {
+	start time.Time // コピーを始めた時刻
+
+	files   int64 // コピーし終わったファイルの数
+	skipped int64 // スキップしたファイルの数
+	failed  int64 // 失敗したファイルの数
+	bytes   int64 // コピーしたバイト数。コピー中のものも含む
+
+	totalFiles   int64  // 事前に数えたファイルの数
+	totalBytes   int64  // 事前に数えたバイト数
+	skippedBytes int64  // スキップしたファイルのバイト数。残りの時間を出すときは全体から除く
+	scanned      uint32 // 数え終わったら 1
+}
+
+// scan はコピーするファイルの数とバイト数を、copyPath と同じように .nextcloudignore と join を扱いながら数える
+// コピーと並行して数えるので、c がキャンセルされたら途中でやめる。数えられなかったものは数えない
+func scan(ctx *ctx, c context.Context, srcs []string) {
+	// コピーと同じ関数を使えるように、context だけを差し替える
+	sctx := *ctx
+	sctx.context = c
+
+	for _, srcPath := range srcs {
+		if c.Err() != nil {
+			return
+		}
+		scanPath(&sctx, srcPath)
+	}
+
+	if c.Err() == nil {
+		atomic.StoreUint32(&(ctx.stats.scanned), 1)
+	}
+}
+
+func scanPath(ctx *ctx, srcPath string) {
+	if ctx.join && srcPath != "/" {
+		fl, err := ctx.src.ReadDir(ctx.context, _path.Dir(srcPath))
+		if err != nil {
+			return
+		}
+
+		fls := nextcloud.JoinFileInfos(fl)[_path.Base(srcPath)]
+		if len(fls) != 1 {
+			return
+		}
+
+		if fls[0][0].IsDir() {
+			scanDir(ctx, srcPath, nil)
+			return
+		}
+
+		ctx.stats.addTotal(fls[0])
+		return
+	}
+
+	fi, err := ctx.src.Stat(ctx.context, srcPath)
+	if err != nil {
+		return
+	}
+
+	if fi.IsDir() {
+		scanDir(ctx, srcPath, nil)
+		return
+	}
+
+	ctx.stats.addTotal([]os.FileInfo{fi})
+}
+
+func scanDir(ctx *ctx, srcPath string, igs []ignorePattern) {
+	if ctx.context.Err() != nil {
+		return
+	}
+
+	if ctx.ignore {
+		ignores, err := readIgnoreFile(ctx, srcPath)
+		if err != nil {
+			return
+		}
+		igs = append(igs[:len(igs):len(igs)], ignores...)
+	}
+
+	fl, err := ctx.src.ReadDir(ctx.context, srcPath)
+	if err != nil {
+		return
+	}
+
+	type task struct {
+		name string
+		fis  []os.FileInfo
+	}
+	tasks := []task{}
+
+	if ctx.join {
+		for name, fls := range nextcloud.JoinFileInfos(fl) {
+			if len(fls) != 1 {
+				continue
+			}
+			tasks = append(tasks, task{name: name, fis: fls[0]})
+		}
+	} else {
+		for _, fi := range fl {
+			tasks = append(tasks, task{name: fi.Name(), fis: []os.FileInfo{fi}})
+		}
+	}
+
+	for _, task := range tasks {
+		name, fis := task.name, task.fis
+
+		src := _path.Join(srcPath, name)
+		if ignored(igs, src, fis[0].IsDir()) {
+			continue
+		}
+
+		if fis[0].IsDir() {
+			scanDir(ctx, src, igs)
+			continue
+		}
+
+		ctx.stats.addTotal(fis)
+	}
+}
+
+// addTotal は fis を結合した一つのファイルを、全体の数に加える
+func (s *stats) addTotal(fis []os.FileInfo) {
+	size := int64(0)
+	for _, fi := range fis {
+		size += fi.Size()
+	}
+
+	atomic.AddInt64(&(s.totalFiles), 1)
+	atomic.AddInt64(&(s.totalBytes), size)
+}
+
+// counter はコピーしたバイト数を stats に加える io.Writer
+// リトライするときは reset で、そのときまでに加えた分を戻す
+type counter struct {
+	stats *stats
+	n     int64
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	atomic.AddInt64(&(c.stats.bytes), int64(len(p)))
+	return len(p), nil
+}
+
+func (c *counter) reset() {
+	atomic.AddInt64(&(c.stats.bytes), -c.n)
+	c.n = 0
+}
+
+// startTotalBar は全体の進捗を表示するプログレスバーを、ほかのバーより上に表示する
+// 返す関数を呼ぶと、表示を更新するのをやめてバーを終わらせる
+func startTotalBar(ctx *ctx) func() {
+	bar := ctx.pool.Get()
+	bar.SetUnits(pb.U_BYTES)
+	bar.ShowSpeed = true
+	// Total が 0 のまま始めると残りの時間を表示しなくなるので、数え終わる前から 0 より大きくしておく
+	bar.SetTotal64(1)
+	bar.Start()
+
+	update := func() {
+		total := atomic.LoadInt64(&(ctx.stats.totalBytes)) - atomic.LoadInt64(&(ctx.stats.skippedBytes))
+		if total <= 0 {
+			total = 1
+		}
+		bar.SetTotal64(total)
+		bar.Set64(atomic.LoadInt64(&(ctx.stats.bytes)))
+		bar.Prefix(totalPrefix(ctx.stats))
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			update()
+			select {
+			case <-stop:
+				return
+			case <-time.After(pb.DEFAULT_REFRESH_RATE):
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+		update()
+		bar.Finish()
+		ctx.pool.Put(bar)
+	}
+}
+
+// totalPrefix は "total 12/340 files" のような、ファイルの数の進捗を返す。数えている途中なら "340+" にする
+func totalPrefix(s *stats) string {
+	done := atomic.LoadInt64(&(s.files)) + atomic.LoadInt64(&(s.skipped)) + atomic.LoadInt64(&(s.failed))
+	total := fmt.Sprint(atomic.LoadInt64(&(s.totalFiles)))
+	if atomic.LoadUint32(&(s.scanned)) == 0 {
+		total += "+"
+	}
+
+	return fmt.Sprintf("total %d/%s files", done, total)
+}
+
+// printSummary はコピーした結果を標準エラー出力に表示する
+func printSummary(s *stats) {
+	elapsed := time.Since(s.start)
+	bytes := atomic.LoadInt64(&(s.bytes))
+
+	speed := int64(0)
+	if elapsed > 0 {
+		speed = int64(float64(bytes) / elapsed.Seconds())
+	}
+
+	fmt.Fprintf(os.Stderr, "transferred %d files (%s), skipped %d, failed %d in %s (%s)\n",
+		atomic.LoadInt64(&(s.files)),
+		pb.Format(bytes).To(pb.U_BYTES).String(),
+		atomic.LoadInt64(&(s.skipped)),
+		atomic.LoadInt64(&(s.failed)),
+		elapsed.Round(time.Millisecond),
+		pb.Format(speed).To(pb.U_BYTES).PerSec().String(),
+	)
+}
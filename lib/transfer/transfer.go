@@ -0,0 +1,1044 @@
+// Package transfer は backend.Backend から別の backend.Backend へファイルやディレクトリをコピーする
+// upload や download などのコマンドは、どちらかをローカルのディスクにしてこれを使う
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	_path "path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/backend"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/bwlimit"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/events"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/nextcloud"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/retry"
+	"github.com/thamaji/pbpool"
+	"golang.org/x/crypto/ssh/terminal"
+	"gopkg.in/cheggaaa/pb.v1"
+)
+
+type ctx struct {
+	src backend.Backend // コピー元
+	dst backend.Backend // コピー先
+
+	context context.Context // キャンセルされたら新しいコピーを始めず、コピー中のものも中断する
+
+	sem chan struct{}   // 並列数を制御するためのセマフォとして扱う chan
+	wg  *sync.WaitGroup // すべてのコピーが終わるまで待つための WaitGroup
+
+	done uint32      // エラーなどで中断していたら done == 1。atomic 経由で読み書きすべし
+	m    *sync.Mutex // err を更新するときのミューテックス
+	err  error       // 処理中に起きた最初のエラー
+
+	pool *pbpool.Pool // プログレスバーのプール
+
+	deconflictStrategy int // ファイルが衝突したときの処理方法
+
+	retry retry.Policy // 通信エラーで途中まで送ったものを送りなおすときの回数と待ち時間
+
+	join      bool  // 分割されていそうなファイルが存在したときに自動で結合するかどうか
+	splitSize int64 // このバイト数を超えないようにファイルを分割する。0なら無視
+	ignore    bool  // コピー元の .nextcloudignore に書かれたものをコピーしない
+
+	limiter *bwlimit.Limiter // 並列にコピーしているもの全体の転送速度の制限。nil なら制限しない
+
+	events *events.Writer // 進捗を JSON で書き出す。nil なら書き出さない
+	out    io.Writer      // スキップしたファイルなど、人が読むメッセージの出力先
+
+	stats *stats // 全体の進捗。プログレスバーと最後に表示する結果に使う
+
+	keepGoing   bool                // 失敗しても中断せず、ほかのものをコピーし続ける
+	failureList string              // 失敗したものを書き出すファイル。空なら書き出さない
+	sourceName  func(string) string // 失敗したものを書き出すときに、コピー元のパスを変換する
+	dir         string              // コピー先のディレクトリ。失敗したもののコピー先をここからの相対パスで記録する
+	failures    map[string]string   // 失敗したもののコピー元と、コピー先の dir からの相対パス。m でロックして読み書きする
+
+	firstFailure error // 最初に失敗したときのエラー。m でロックして読み書きする
+}
+
+type Option func(*ctx) error
+
+const (
+	DeconflictError     = "error"
+	DeconflictSkip      = "skip"
+	DeconflictOverwrite = "overwrite"
+	DeconflictNewest    = "newest"
+	DeconflictLarger    = "larger"
+)
+
+func DeconflictStrategy(strategy string) Option {
+	return func(ctx *ctx) error {
+		switch strategy {
+		case DeconflictError:
+			ctx.deconflictStrategy = 0
+
+		case DeconflictSkip:
+			ctx.deconflictStrategy = 1
+
+		case DeconflictOverwrite:
+			ctx.deconflictStrategy = 2
+
+		case DeconflictNewest:
+			ctx.deconflictStrategy = 3
+
+		case DeconflictLarger:
+			ctx.deconflictStrategy = 4
+
+		default:
+			return errors.New("invalid strategy: " + strategy)
+		}
+
+		return nil
+	}
+}
+
+// Retry はコピーの途中で失敗したときに、そのファイルを最初からコピーしなおす回数を指定する
+// 一つのリクエストのリトライは Transport がするので、ここでは流しながら送っているボディのように送りなおせないものだけを扱う
+func Retry(n int) Option {
+	return func(ctx *ctx) error {
+		if n < 0 {
+			return fmt.Errorf("invalid retry count: %d", n)
+		}
+
+		ctx.retry = retry.DefaultPolicy(n)
+
+		return nil
+	}
+}
+
+func Procs(n int) Option {
+	return func(ctx *ctx) error {
+		if n <= 0 {
+			return errors.New("procs should 1<=")
+		}
+
+		ctx.sem = make(chan struct{}, n)
+
+		return nil
+	}
+}
+
+func Context(c context.Context) Option {
+	return func(ctx *ctx) error {
+		ctx.context = c
+		return nil
+	}
+}
+
+// Join はコピー元の path.000, path.001, ... を結合して path としてコピーする
+func Join(b bool) Option {
+	return func(ctx *ctx) error {
+		ctx.join = b
+		return nil
+	}
+}
+
+// SplitSize は threshold を超えるファイルを path.000, path.001, ... に分割してコピーする
+func SplitSize(threshold string) Option {
+	return func(ctx *ctx) error {
+		var bytesize datasize.ByteSize
+		if err := bytesize.UnmarshalText([]byte(threshold)); err != nil {
+			return fmt.Errorf("failed to unmarshal %#v: %w", threshold, err)
+		}
+		ctx.splitSize = int64(bytesize.Bytes())
+		return nil
+	}
+}
+
+// BWLimit は並列にコピーしているもの全体の転送速度を制限する
+// "10M" のような速度 (バイト/秒) か、"08:00,2M 19:00,off" のような時間割で指定する
+func BWLimit(spec string) Option {
+	return func(ctx *ctx) error {
+		limiter, err := bwlimit.Parse(spec)
+		if err != nil {
+			return err
+		}
+		ctx.limiter = limiter
+		return nil
+	}
+}
+
+// Ignore はコピー元のディレクトリにある .nextcloudignore に書かれたものをコピーしない
+func Ignore(b bool) Option {
+	return func(ctx *ctx) error {
+		ctx.ignore = b
+		return nil
+	}
+}
+
+// Events は進捗を w に JSON で書き出す。プログレスバーは表示せず、人が読むメッセージは標準エラー出力に出す
+func Events(w *events.Writer) Option {
+	return func(ctx *ctx) error {
+		ctx.events = w
+		if w != nil {
+			ctx.out = os.Stderr
+		}
+		return nil
+	}
+}
+
+// KeepGoing はコピーに失敗したものがあっても中断せず、ほかのものをすべてコピーしてから ErrFailed を返す
+// 中断されたときや、コピーを始める前のエラーでは、これまでどおりすぐに終わる
+func KeepGoing(b bool) Option {
+	return func(ctx *ctx) error {
+		ctx.keepGoing = b
+		return nil
+	}
+}
+
+// FailureList は終わったときに、コピーに失敗したものを path に書き出す。失敗したものがなければ空のファイルにする
+// ReadEntries で読み込んで Do に渡せば、失敗したものだけをコピーしなおせる
+func FailureList(path string) Option {
+	return func(ctx *ctx) error {
+		ctx.failureList = path
+		return nil
+	}
+}
+
+// SourceName は失敗したものを書き出すときに、コピー元のパスを name で変換する
+// "work:/path" のように接続先を付けて、--files-from で同じところから読めるようにするのに使う
+func SourceName(name func(path string) string) Option {
+	return func(ctx *ctx) error {
+		ctx.sourceName = name
+		return nil
+	}
+}
+
+// ErrFailed は KeepGoing でコピーに失敗したものがあったときに返すエラー
+// 最初に失敗したときのエラーを Unwrap で返す
+type ErrFailed struct {
+	Entries []Entry // 失敗したもの
+	Err     error   // 最初に失敗したときのエラー
+}
+
+func (e *ErrFailed) Error() string {
+	return fmt.Sprintf("failed to copy %d files", len(e.Entries))
+}
+
+func (e *ErrFailed) Unwrap() error {
+	return e.Err
+}
+
+// Entry はコピーするもの。Dst はコピー先のディレクトリからの相対パスで、空なら Src と同じ名前にする
+type Entry struct {
+	Src string
+	Dst string
+}
+
+// Entries は srcs をそれぞれ同じ名前でコピーする Entry にする
+func Entries(srcs []string) []Entry {
+	entries := make([]Entry, 0, len(srcs))
+	for _, src := range srcs {
+		entries = append(entries, Entry{Src: src})
+	}
+	return entries
+}
+
+// ReadEntries は一行に一つ "SRC" か "SRC<TAB>DST" と書かれた r から Entry を読み込む。空の行は無視する
+func ReadEntries(r io.Reader) ([]Entry, error) {
+	entries := []Entry{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		fields := strings.SplitN(line, "\t", 2)
+		entry := Entry{Src: fields[0]}
+		if len(fields) == 2 {
+			entry.Dst = fields[1]
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// WriteEntries は entries を ReadEntries で読み込める形で w に書き出す
+func WriteEntries(w io.Writer, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	for _, entry := range entries {
+		line := entry.Src
+		if entry.Dst != "" {
+			line += "\t" + entry.Dst
+		}
+		if _, err := bw.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Do は src の entries を、dst の dir の中にコピーする
+func Do(src backend.Backend, dst backend.Backend, opts []Option, entries []Entry, dir string) error {
+	ctx, err := newCtx(src, dst, opts)
+	if err != nil {
+		return err
+	}
+
+	ctx.dir = _path.Clean(dir)
+
+	srcs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		srcs = append(srcs, entry.Src)
+	}
+
+	return ctx.run(srcs, func() {
+		if err := ctx.dst.MkdirAll(ctx.context, dir); err != nil {
+			ctx.setError(err)
+			return
+		}
+
+		for _, entry := range entries {
+			if ctx.stopped() {
+				return
+			}
+
+			name := entry.Dst
+			if name == "" {
+				name = _path.Base(entry.Src)
+			}
+			dstPath := _path.Join(dir, name)
+
+			// 失敗したものをコピーしなおすときは、コピー先がディレクトリの中のこともある
+			if parent := _path.Dir(dstPath); parent != _path.Clean(dir) {
+				if err := ctx.dst.MkdirAll(ctx.context, parent); err != nil {
+					ctx.fail(entry.Src, dstPath, err)
+					continue
+				}
+			}
+
+			copyPath(ctx, entry.Src, dstPath)
+		}
+	})
+}
+
+// DoFile は src の srcPath を、dst の dstPath にコピーする
+func DoFile(src backend.Backend, dst backend.Backend, opts []Option, srcPath string, dstPath string) error {
+	ctx, err := newCtx(src, dst, opts)
+	if err != nil {
+		return err
+	}
+
+	ctx.dir = _path.Dir(dstPath)
+
+	return ctx.run([]string{srcPath}, func() {
+		if err := ctx.dst.MkdirAll(ctx.context, _path.Dir(dstPath)); err != nil {
+			ctx.setError(err)
+			return
+		}
+
+		copyPath(ctx, srcPath, dstPath)
+	})
+}
+
+func newCtx(src backend.Backend, dst backend.Backend, opts []Option) (*ctx, error) {
+	ctx := &ctx{
+		src: src,
+		dst: dst,
+
+		context: context.Background(),
+
+		sem: make(chan struct{}, 2),
+		wg:  &sync.WaitGroup{},
+
+		done: 0,
+		m:    &sync.Mutex{},
+		err:  nil,
+
+		pool: nil,
+
+		deconflictStrategy: 0,
+
+		retry: retry.DefaultPolicy(3),
+
+		join:      false,
+		splitSize: 0,
+		ignore:    false,
+
+		events: nil,
+		out:    os.Stdout,
+
+		stats: &stats{},
+
+		keepGoing:   false,
+		failureList: "",
+		sourceName:  func(path string) string { return path },
+		dir:         ".",
+		failures:    map[string]string{},
+	}
+
+	for _, opt := range opts {
+		if err := opt(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return ctx, nil
+}
+
+// run は f でコピーを始めて、すべて終わるまで待つ。srcs は f がコピーするもので、全体の進捗を出すために数える
+func (ctx *ctx) run(srcs []string, f func()) error {
+	if ctx.events == nil && terminal.IsTerminal(int(os.Stdout.Fd())) {
+		ctx.pool = pbpool.New()
+	}
+
+	ctx.stats.start = time.Now()
+
+	// 数え終わるのを待たずにコピーを始めて、コピーが終わったら数えるのもやめる
+	scanContext, cancel := context.WithCancel(ctx.context)
+	defer cancel()
+
+	var stopTotalBar func()
+	if ctx.pool != nil {
+		go scan(ctx, scanContext, srcs)
+		stopTotalBar = startTotalBar(ctx)
+		ctx.pool.Start()
+	}
+
+	f()
+
+	ctx.wg.Wait()
+	cancel()
+
+	if ctx.pool != nil {
+		stopTotalBar()
+		ctx.pool.Update()
+		ctx.pool.Stop()
+	}
+
+	err := ctx.err
+	if err == nil && len(ctx.failures) > 0 {
+		err = &ErrFailed{Entries: ctx.failedEntries(), Err: ctx.firstFailure}
+	}
+
+	ctx.events.Summary(err)
+	printSummary(ctx.stats)
+
+	if ctx.failureList != "" {
+		if err1 := ctx.writeFailureList(); err1 != nil && err == nil {
+			err = err1
+		}
+	}
+
+	return err
+}
+
+// failedEntries は失敗したものを、コピー元の名前の順に返す
+func (ctx *ctx) failedEntries() []Entry {
+	entries := make([]Entry, 0, len(ctx.failures))
+	for src, dst := range ctx.failures {
+		entries = append(entries, Entry{Src: ctx.sourceName(src), Dst: dst})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Src < entries[j].Src
+	})
+	return entries
+}
+
+// writeFailureList は失敗したものを ctx.failureList に書き出す
+func (ctx *ctx) writeFailureList() error {
+	f, err := os.Create(ctx.failureList)
+	if err != nil {
+		return err
+	}
+
+	if err := WriteEntries(f, ctx.failedEntries()); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func (ctx *ctx) setError(err error) {
+	if atomic.LoadUint32(&(ctx.done)) == 1 {
+		return
+	}
+
+	ctx.m.Lock()
+	if ctx.err == nil {
+		ctx.err = err
+	}
+	atomic.StoreUint32(&(ctx.done), 1)
+	ctx.m.Unlock()
+}
+
+// fail は srcPath を dstPath にコピーするのに失敗したことを記録して、エラーで中断する
+// KeepGoing なら中断せずに、エラーを表示して続ける。中断されたことによるエラーなら、KeepGoing でも中断する
+func (ctx *ctx) fail(srcPath string, dstPath string, err error) {
+	ctx.m.Lock()
+	_, failed := ctx.failures[srcPath]
+	if !failed {
+		ctx.failures[srcPath] = relPath(ctx.dir, dstPath)
+		if ctx.firstFailure == nil {
+			ctx.firstFailure = err
+		}
+	}
+	ctx.m.Unlock()
+
+	// 分割したものがいくつも失敗しても、一つのファイルとして数える
+	if !failed {
+		atomic.AddInt64(&(ctx.stats.failed), 1)
+		ctx.events.Failed(srcPath, err)
+	}
+
+	if ctx.keepGoing && ctx.context.Err() == nil {
+		fmt.Fprintln(os.Stderr, "failed "+srcPath+": "+err.Error())
+		return
+	}
+
+	ctx.setError(err)
+}
+
+// relPath は dir の中にある path を、dir からの相対パスにする
+func relPath(dir string, path string) string {
+	switch dir {
+	case ".":
+		return path
+	case "/":
+		return strings.TrimPrefix(path, "/")
+	default:
+		return strings.TrimPrefix(path, dir+"/")
+	}
+}
+
+// skip は size バイトの srcPath をスキップしたことを、reason (events.SkipExists など) をつけて記録する
+func (ctx *ctx) skip(srcPath string, size int64, message string, reason string) {
+	fmt.Fprintln(ctx.out, message+": "+srcPath)
+	atomic.AddInt64(&(ctx.stats.skipped), 1)
+	atomic.AddInt64(&(ctx.stats.skippedBytes), size)
+	ctx.events.Skipped(srcPath, reason)
+}
+
+// stopped はエラーや中断で、あたらしい処理を始めるべきでないときに true を返す
+func (ctx *ctx) stopped() bool {
+	if atomic.LoadUint32(&(ctx.done)) == 1 {
+		return true
+	}
+
+	if err := ctx.context.Err(); err != nil {
+		ctx.setError(err)
+		return true
+	}
+
+	return false
+}
+
+func copyPath(ctx *ctx, srcPath string, dstPath string) {
+	if ctx.stopped() {
+		return
+	}
+
+	if ctx.join && srcPath != "/" {
+		// joinした後に同じsrcという名前になるものがないかチェックする
+		fl, err := ctx.src.ReadDir(ctx.context, _path.Dir(srcPath))
+		if err != nil {
+			ctx.fail(srcPath, dstPath, err)
+			return
+		}
+
+		fls := nextcloud.JoinFileInfos(fl)[_path.Base(srcPath)]
+		if len(fls) == 0 {
+			_, err := ctx.src.Stat(ctx.context, srcPath)
+			if err == nil {
+				err = fmt.Errorf("unexpected: %s not found in %s, but actually exists", srcPath, _path.Dir(srcPath))
+			}
+			ctx.fail(srcPath, dstPath, err)
+			return
+		}
+
+		if len(fls) != 1 {
+			ctx.fail(srcPath, dstPath, collisionError(fls))
+			return
+		}
+
+		if fls[0][0].IsDir() {
+			copyDir(ctx, srcPath, dstPath, nil)
+			return
+		}
+
+		copyFile(ctx, srcPath, joinPaths(_path.Dir(srcPath), fls[0]), fls[0], dstPath)
+		return
+	}
+
+	fi, err := ctx.src.Stat(ctx.context, srcPath)
+	if err != nil {
+		ctx.fail(srcPath, dstPath, err)
+		return
+	}
+
+	if fi.IsDir() {
+		copyDir(ctx, srcPath, dstPath, nil)
+		return
+	}
+
+	copyFile(ctx, srcPath, []string{srcPath}, []os.FileInfo{fi}, dstPath)
+}
+
+func copyDir(ctx *ctx, srcPath string, dstPath string, igs []ignorePattern) {
+	if ctx.stopped() {
+		return
+	}
+
+	if ctx.ignore {
+		ignores, err := readIgnoreFile(ctx, srcPath)
+		if err != nil {
+			ctx.fail(srcPath, dstPath, err)
+			return
+		}
+		// ファイルから取得したパターンを追加
+		igs = append(igs[:len(igs):len(igs)], ignores...)
+	}
+
+	if err := ctx.dst.MkdirAll(ctx.context, dstPath); err != nil {
+		ctx.fail(srcPath, dstPath, err)
+		return
+	}
+
+	fl, err := ctx.src.ReadDir(ctx.context, srcPath)
+	if err != nil {
+		ctx.fail(srcPath, dstPath, err)
+		return
+	}
+
+	// tasksの形に変形する
+	type task struct {
+		name string
+		fis  []os.FileInfo
+	}
+	tasks := []*task{}
+
+	if ctx.join {
+		for name, fls := range nextcloud.JoinFileInfos(fl) {
+			if len(fls) != 1 {
+				ctx.fail(_path.Join(srcPath, name), _path.Join(dstPath, name), collisionError(fls))
+				continue
+			}
+			tasks = append(tasks, &task{name: name, fis: fls[0]})
+		}
+	} else {
+		for _, fi := range fl {
+			tasks = append(tasks, &task{name: fi.Name(), fis: []os.FileInfo{fi}})
+		}
+	}
+
+	for _, task := range tasks {
+		if ctx.stopped() {
+			return
+		}
+
+		src := _path.Join(srcPath, task.name)
+		dst := _path.Join(dstPath, task.name)
+
+		if ignored(igs, src, task.fis[0].IsDir()) {
+			ctx.events.Skipped(src, events.SkipIgnored)
+			continue
+		}
+
+		if task.fis[0].IsDir() {
+			copyDir(ctx, src, dst, igs)
+			continue
+		}
+
+		copyFile(ctx, src, joinPaths(srcPath, task.fis), task.fis, dst)
+	}
+}
+
+// copyFile は srcs を結合したもの(名前は srcPath)を dstPath にコピーする
+// 分割するときは、分割したものをそれぞれ並列にコピーする
+func copyFile(ctx *ctx, srcPath string, srcs []string, fis []os.FileInfo, dstPath string) {
+	size := int64(0)
+	for _, fi := range fis {
+		size += fi.Size()
+	}
+
+	existing, existingFis, err := stat(ctx, dstPath)
+	if err != nil {
+		ctx.fail(srcPath, dstPath, err)
+		return
+	}
+
+	existingSize := int64(0)
+	for _, fi := range existingFis {
+		existingSize += fi.Size()
+	}
+
+	switch ctx.deconflictStrategy {
+	case 0: // DeconflictError
+		// 同じ形で存在していれば exclusive な Create で検出できるので、
+		// ここでは分割したものと分割していないものが混在しないかだけ調べる
+		if len(existing) > 0 {
+			ctx.fail(srcPath, dstPath, fmt.Errorf("file already exists: %s: %w", dstPath, os.ErrExist))
+			return
+		}
+
+	case 1: // DeconflictSkip
+		if len(existing) > 0 {
+			ctx.skip(srcPath, size, "skip already exists file", events.SkipExists)
+			return
+		}
+
+	case 2: // DeconflictOverwrite
+
+	case 3: // DeconflictNewest
+		if len(existing) > 0 && !fis[0].ModTime().After(existingFis[0].ModTime()) {
+			ctx.skip(srcPath, size, "skip older file", events.SkipNotNewer)
+			return
+		}
+
+	case 4: // DeconflictLarger
+		if len(existing) > 0 && size <= existingSize {
+			ctx.skip(srcPath, size, "skip not larger file", events.SkipNotLarger)
+			return
+		}
+	}
+
+	type part struct {
+		dst    string
+		offset int64
+		size   int64
+		prefix string
+	}
+	parts := []part{}
+
+	if 0 < ctx.splitSize && ctx.splitSize < size {
+		for i := int64(0); i*ctx.splitSize < size; i++ {
+			offset := i * ctx.splitSize
+			partSize := ctx.splitSize
+			if size < offset+partSize {
+				partSize = size - offset
+			}
+			parts = append(parts, part{
+				dst:    fmt.Sprintf("%s.%03d", dstPath, i),
+				offset: offset,
+				size:   partSize,
+				prefix: fmt.Sprintf("%s (%d)", dstPath, i),
+			})
+		}
+	} else {
+		parts = append(parts, part{dst: dstPath, offset: 0, size: size, prefix: srcPath})
+	}
+
+	// 今回書き込まないものが残っていると、結合したときなどに混ざってしまうので消しておく
+	for _, path := range existing {
+		keep := false
+		for _, part := range parts {
+			if part.dst == path {
+				keep = true
+				break
+			}
+		}
+		if keep {
+			continue
+		}
+
+		if err := ctx.dst.Remove(ctx.context, path); err != nil {
+			ctx.fail(srcPath, dstPath, fmt.Errorf("failed to delete %#v: %w", path, err))
+			return
+		}
+	}
+
+	// 分割したものがすべて終わったら、一つのファイルが終わったものとする
+	remaining := int32(len(parts))
+
+	for _, part := range parts {
+		part := part
+
+		select {
+		case ctx.sem <- struct{}{}:
+		case <-ctx.context.Done():
+			ctx.setError(ctx.context.Err())
+			return
+		}
+
+		if atomic.LoadUint32(&(ctx.done)) == 1 {
+			<-ctx.sem
+			return
+		}
+
+		ctx.wg.Add(1)
+		go func() {
+			defer func() {
+				ctx.wg.Done()
+				<-ctx.sem
+			}()
+
+			info := &fileInfo{FileInfo: fis[0], size: part.size}
+			if err := copyPart(ctx, srcPath, srcs, fis, part.offset, part.size, part.dst, info, part.prefix); err != nil {
+				ctx.fail(srcPath, dstPath, err)
+				return
+			}
+
+			if atomic.AddInt32(&remaining, -1) == 0 {
+				atomic.AddInt64(&(ctx.stats.files), 1)
+			}
+		}()
+	}
+}
+
+// copyPart は srcs を結合したもの (名前は srcPath) の offset から size バイトを dstPath に書き込む
+func copyPart(ctx *ctx, srcPath string, srcs []string, fis []os.FileInfo, offset int64, size int64, dstPath string, info os.FileInfo, prefix string) (err error) {
+	var bar *pbpool.ProgressBar
+
+	// 失敗したときは、途中までコピーしたバイト数を全体の進捗から除く
+	counter := &counter{stats: ctx.stats}
+	defer func() {
+		if err != nil {
+			counter.reset()
+		}
+	}()
+
+	if ctx.events != nil {
+		ctx.events.Started(srcPath, dstPath, size)
+	} else if ctx.pool == nil {
+		fmt.Fprintln(os.Stdout, prefix)
+	} else {
+		bar = ctx.pool.Get()
+		bar.SetTotal64(size)
+		bar.Prefix(prefix)
+		bar.SetUnits(pb.U_BYTES)
+		bar.Start()
+		defer func() {
+			bar.Finish()
+			ctx.pool.Put(bar)
+		}()
+	}
+
+	// exclusive にしておけば、調べてから書き込むまでの間に作られても上書きしない
+	exclusive := ctx.deconflictStrategy == 0
+
+	// イベントを書き出すときは、終わったときに書き込んだ内容のハッシュも出す
+	start := time.Now()
+	tracker := ctx.events.Track(srcPath, size)
+	hash := sha256.New()
+
+	try := func() (bool, error) {
+		if bar != nil {
+			bar.Set(0)
+		}
+		counter.reset()
+		if tracker != nil {
+			tracker.Reset()
+			hash.Reset()
+		}
+
+		r := openRange(ctx, srcs, fis, offset, size)
+		defer r.Close()
+
+		src := bwlimit.Reader(ctx.context, ctx.limiter, r)
+
+		w, err := ctx.dst.Create(ctx.context, dstPath, info, exclusive)
+		if err != nil {
+			return true, err
+		}
+
+		var dst io.Writer = io.MultiWriter(w, counter)
+		if bar != nil {
+			dst = io.MultiWriter(dst, bar)
+		}
+		if tracker != nil {
+			dst = io.MultiWriter(dst, tracker, hash)
+		}
+
+		if _, err := io.Copy(dst, src); err != nil {
+			if err1 := w.Abort(); err1 != nil {
+				return false, err
+			}
+			// 開くときのリクエストは Transport が送りなおしているので、ここではボディを読んでいる途中のエラーだけを送りなおす
+			if r.openErr != nil {
+				return false, err
+			}
+			return true, err
+		}
+
+		if err := w.Close(); err != nil {
+			return true, err
+		}
+
+		return true, nil
+	}
+
+	for n := 0; ; n++ {
+		retryable, err := try()
+		if err == nil {
+			if tracker != nil {
+				ctx.events.Finished(srcPath, dstPath, size, hex.EncodeToString(hash.Sum(nil)), time.Since(start))
+			}
+			return nil
+		}
+
+		if errors.Is(err, os.ErrExist) {
+			// exclusive に書き込めなかった。リトライしても結果は変わらない
+			return fmt.Errorf("file already exists: %s: %w", dstPath, err)
+		}
+
+		if ctx.context.Err() != nil {
+			return ctx.context.Err()
+		}
+
+		if retryable && !retry.Permanent(err) && n < ctx.retry.Max {
+			delay := ctx.retry.Backoff(n)
+			fmt.Fprintln(os.Stderr, "error! retry after "+delay.Round(time.Second).String()+"...")
+			fmt.Fprintln(os.Stderr, "  "+err.Error())
+			ctx.events.Retried(srcPath, n+1, delay, err)
+			if err := retry.Sleep(ctx.context, delay); err != nil {
+				return err
+			}
+			continue
+		}
+
+		return fmt.Errorf("failed to copy %#v: %w", prefix, err)
+	}
+}
+
+// stat はコピー先の path を調べる。path そのものか、分割された path.000, path.001, ... があればそれを返す
+func stat(ctx *ctx, path string) ([]string, []os.FileInfo, error) {
+	if fi, err := ctx.dst.Stat(ctx.context, path); err == nil {
+		return []string{path}, []os.FileInfo{fi}, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+
+	paths := []string{}
+	fis := []os.FileInfo{}
+	for i := 0; ; i++ {
+		splittedPath := fmt.Sprintf("%s.%03d", path, i)
+		fi, err := ctx.dst.Stat(ctx.context, splittedPath)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				return nil, nil, err
+			}
+			return paths, fis, nil
+		}
+		paths = append(paths, splittedPath)
+		fis = append(fis, fi)
+	}
+}
+
+// openRange は srcs を結合したものの offset から size バイトを読む Reader を返す
+// 分割ファイルは読む順番になってから、必要な範囲だけを開く
+func openRange(ctx *ctx, srcs []string, fis []os.FileInfo, offset int64, size int64) *multiReader {
+	r := &multiReader{}
+
+	for i, fi := range fis {
+		if size <= 0 {
+			break
+		}
+
+		if offset >= fi.Size() {
+			offset -= fi.Size()
+			continue
+		}
+
+		l := fi.Size() - offset
+		if size < l {
+			l = size
+		}
+
+		path, off := srcs[i], offset
+		r.opens = append(r.opens, func() (io.ReadCloser, error) {
+			return ctx.src.OpenRange(ctx.context, path, off, l)
+		})
+
+		offset = 0
+		size -= l
+	}
+
+	return r
+}
+
+type multiReader struct {
+	opens   []func() (io.ReadCloser, error)
+	current io.ReadCloser
+	openErr error // 開けなかったときのエラー
+}
+
+func (r *multiReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.opens) == 0 {
+				return 0, io.EOF
+			}
+
+			rc, err := r.opens[0]()
+			if err != nil {
+				r.openErr = err
+				return 0, err
+			}
+
+			r.opens = r.opens[1:]
+			r.current = rc
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			err = r.current.Close()
+			r.current = nil
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		return n, err
+	}
+}
+
+func (r *multiReader) Close() error {
+	r.opens = nil
+	if r.current == nil {
+		return nil
+	}
+
+	err := r.current.Close()
+	r.current = nil
+	return err
+}
+
+// fileInfo はコピー先に渡すためにサイズだけを差し替えた FileInfo
+type fileInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func joinPaths(dir string, fis []os.FileInfo) []string {
+	paths := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		paths = append(paths, _path.Join(dir, fi.Name()))
+	}
+	return paths
+}
+
+func collisionError(fls [][]os.FileInfo) error {
+	// joinした後に同じsrcという名前になるものが複数存在する
+	names := []string{}
+	for _, fis := range fls {
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+	}
+	return fmt.Errorf("name collision detected: %s", strings.Join(names, " "))
+}
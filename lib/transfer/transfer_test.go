@@ -0,0 +1,632 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	_path "path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/backend"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/events"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/nextcloud"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/retry"
+)
+
+// fakeBackend はローカルのディスクに読み書きしながら、指定したところで失敗する Backend
+type fakeBackend struct {
+	*backend.Local
+
+	// それぞれ path の n 回目 (1 から数える) の呼び出しで失敗させるときにエラーを返す
+	create func(path string, n int) error
+	open   func(path string, n int) error
+	read   func(path string, n int) error // ボディを半分読んだところで返すエラー
+
+	m       sync.Mutex
+	creates map[string]int
+	opens   map[string]int
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		Local:   backend.NewLocal(),
+		creates: map[string]int{},
+		opens:   map[string]int{},
+	}
+}
+
+func (b *fakeBackend) count(calls map[string]int, path string) int {
+	b.m.Lock()
+	defer b.m.Unlock()
+	calls[path]++
+	return calls[path]
+}
+
+func (b *fakeBackend) Create(ctx context.Context, path string, fi os.FileInfo, exclusive bool) (backend.Writer, error) {
+	n := b.count(b.creates, path)
+	if b.create != nil {
+		if err := b.create(path, n); err != nil {
+			return nil, err
+		}
+	}
+	return b.Local.Create(ctx, path, fi, exclusive)
+}
+
+func (b *fakeBackend) OpenRange(ctx context.Context, path string, offset int64, length int64) (io.ReadCloser, error) {
+	n := b.count(b.opens, path)
+	if b.open != nil {
+		if err := b.open(path, n); err != nil {
+			return nil, err
+		}
+	}
+
+	rc, err := b.Local.OpenRange(ctx, path, offset, length)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.read != nil {
+		if err := b.read(path, n); err != nil {
+			r := io.MultiReader(io.LimitReader(rc, length/2), &errReader{err})
+			return struct {
+				io.Reader
+				io.Closer
+			}{r, rc}, nil
+		}
+	}
+
+	return rc, nil
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+// noDelay は待たずにリトライする
+func noDelay(n int) Option {
+	return func(ctx *ctx) error {
+		ctx.retry = retry.Policy{Max: n}
+		return nil
+	}
+}
+
+// capture は Do が使う ctx を取り出して、終わった後の stats を調べられるようにする
+func capture(p **ctx) Option {
+	return func(ctx *ctx) error {
+		*p = ctx
+		return nil
+	}
+}
+
+func tempDir(t *testing.T) string {
+	return filepath.ToSlash(t.TempDir())
+}
+
+func writeFile(t *testing.T, path string, data string, mtime time.Time) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(filepath.FromSlash(path)), 0775); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.FromSlash(path), []byte(data), 0664); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.FromSlash(path), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.FromSlash(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(filepath.FromSlash(path))
+	return err == nil
+}
+
+func TestDeconflictStrategy(t *testing.T) {
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	tests := []struct {
+		strategy string
+		src      string
+		srcTime  time.Time
+		want     string // コピーした後のコピー先の中身
+		wantErr  error
+	}{
+		{DeconflictError, "new content", newer, "old", os.ErrExist},
+		{DeconflictSkip, "new content", newer, "old", nil},
+		{DeconflictOverwrite, "new", older, "new", nil},
+		{DeconflictNewest, "new", newer, "new", nil},
+		{DeconflictNewest, "new", older, "old", nil},
+		{DeconflictLarger, "new content", older, "new content", nil},
+		{DeconflictLarger, "ne", newer, "old", nil},
+	}
+
+	for _, tt := range tests {
+		srcDir, dstDir := tempDir(t), tempDir(t)
+		writeFile(t, srcDir+"/a.txt", tt.src, tt.srcTime)
+		writeFile(t, dstDir+"/a.txt", "old", older)
+
+		opts := []Option{DeconflictStrategy(tt.strategy), noDelay(0)}
+		err := DoFile(backend.NewLocal(), backend.NewLocal(), opts, srcDir+"/a.txt", dstDir+"/a.txt")
+		if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+			t.Errorf("%s (%q): error = %v, want %v", tt.strategy, tt.src, err, tt.wantErr)
+		}
+
+		if got := readFile(t, dstDir+"/a.txt"); got != tt.want {
+			t.Errorf("%s (%q): dst = %q, want %q", tt.strategy, tt.src, got, tt.want)
+		}
+	}
+
+	if err := DeconflictStrategy("unknown")(&ctx{}); err == nil {
+		t.Error("DeconflictStrategy(unknown) should fail")
+	}
+}
+
+func TestExclusiveCreate(t *testing.T) {
+	srcDir, dstDir := tempDir(t), tempDir(t)
+	writeFile(t, srcDir+"/a.txt", "mine", time.Now())
+
+	// 調べてから書き込むまでの間に、ほかの誰かが作ったことにする
+	dst := newFakeBackend()
+	dst.create = func(path string, n int) error {
+		writeFile(t, path, "theirs", time.Now())
+		return nil
+	}
+
+	err := DoFile(backend.NewLocal(), dst, []Option{noDelay(2)}, srcDir+"/a.txt", dstDir+"/a.txt")
+	if !errors.Is(err, os.ErrExist) {
+		t.Errorf("error = %v, want os.ErrExist", err)
+	}
+
+	if got := readFile(t, dstDir+"/a.txt"); got != "theirs" {
+		t.Errorf("dst = %q, want %q", got, "theirs")
+	}
+
+	if n := dst.creates[dstDir+"/a.txt"]; n != 1 {
+		t.Errorf("Create called %d times, want 1", n)
+	}
+}
+
+func TestSplitAndJoin(t *testing.T) {
+	srcDir, splitDir, joinDir := tempDir(t), tempDir(t), tempDir(t)
+	content := "0123456789"
+	writeFile(t, srcDir+"/a.txt", content, time.Now())
+
+	// 前に大きいファイルを分割したときの残りと、分割していないものがあっても消える
+	writeFile(t, splitDir+"/a.txt.000", "x", time.Now())
+	writeFile(t, splitDir+"/a.txt.001", "x", time.Now())
+	writeFile(t, splitDir+"/a.txt.002", "x", time.Now())
+	writeFile(t, splitDir+"/a.txt.003", "x", time.Now())
+	writeFile(t, splitDir+"/a.txt.004", "x", time.Now())
+
+	opts := []Option{SplitSize("4B"), DeconflictStrategy(DeconflictOverwrite), noDelay(0)}
+	if err := Do(backend.NewLocal(), backend.NewLocal(), opts, Entries([]string{srcDir + "/a.txt"}), splitDir); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []string{"0123", "4567", "89"} {
+		path := splitDir + "/a.txt.00" + string(rune('0'+i))
+		if got := readFile(t, path); got != want {
+			t.Errorf("%s = %q, want %q", _path.Base(path), got, want)
+		}
+	}
+	for _, name := range []string{"a.txt", "a.txt.003", "a.txt.004"} {
+		if exists(splitDir + "/" + name) {
+			t.Errorf("%s should be deleted", name)
+		}
+	}
+
+	// 分割したものをもう一度分割せずにコピーすると、分割したものは消える
+	writeFile(t, srcDir+"/b.txt", content, time.Now())
+	writeFile(t, splitDir+"/b.txt.000", "01234", time.Now())
+	writeFile(t, splitDir+"/b.txt.001", "56789", time.Now())
+
+	opts = []Option{DeconflictStrategy(DeconflictOverwrite), noDelay(0)}
+	if err := DoFile(backend.NewLocal(), backend.NewLocal(), opts, srcDir+"/b.txt", splitDir+"/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, splitDir+"/b.txt"); got != content {
+		t.Errorf("b.txt = %q, want %q", got, content)
+	}
+	if exists(splitDir+"/b.txt.000") || exists(splitDir+"/b.txt.001") {
+		t.Error("b.txt.000 and b.txt.001 should be deleted")
+	}
+
+	// ファイルを指定しても、ディレクトリを指定しても結合する
+	opts = []Option{Join(true), noDelay(0)}
+	if err := Do(backend.NewLocal(), backend.NewLocal(), opts, Entries([]string{splitDir + "/a.txt"}), joinDir); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, joinDir+"/a.txt"); got != content {
+		t.Errorf("joined a.txt = %q, want %q", got, content)
+	}
+
+	if err := Do(backend.NewLocal(), backend.NewLocal(), opts, Entries([]string{splitDir}), joinDir); err != nil {
+		t.Fatal(err)
+	}
+	dir := joinDir + "/" + _path.Base(splitDir)
+	if got := readFile(t, dir+"/a.txt"); got != content {
+		t.Errorf("joined %s/a.txt = %q, want %q", _path.Base(splitDir), got, content)
+	}
+	if exists(dir + "/a.txt.000") {
+		t.Error("a.txt.000 should not be copied when joining")
+	}
+
+	// 結合した名前と同じ名前のファイルもあると、どちらをコピーするか決められない
+	writeFile(t, splitDir+"/a.txt", content, time.Now())
+	err := Do(backend.NewLocal(), backend.NewLocal(), opts, Entries([]string{splitDir + "/a.txt"}), tempDir(t))
+	if err == nil {
+		t.Error("name collision should fail")
+	}
+}
+
+// countingWriter は書いたバイト数を数える
+type countingWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(w.n, int64(len(p)))
+	return w.ResponseWriter.Write(p)
+}
+
+func TestSplitRemote(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var sent int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/a.txt" {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch r.Method {
+		case "PROPFIND":
+			w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprintf(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:"><d:response><d:href>/a.txt</d:href><d:propstat><d:prop>`+
+				`<d:displayname>a.txt</d:displayname><d:getlastmodified>%s</d:getlastmodified><d:resourcetype/><d:getcontentlength>%d</d:getcontentlength>`+
+				`</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response></d:multistatus>`, modTime.Format(http.TimeFormat), len(content))
+
+		case http.MethodGet:
+			http.ServeContent(countingWriter{w, &sent}, r, "a.txt", modTime, strings.NewReader(content))
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer srv.Close()
+
+	src := backend.NewNextcloud(nextcloud.New(srv.URL+"/", srv.Client(), nil))
+	dir := tempDir(t)
+
+	// 分割したそれぞれのファイルで、ファイルの先頭から読みなおさない
+	opts := []Option{SplitSize("10B"), noDelay(0)}
+	if err := DoFile(src, backend.NewLocal(), opts, "a.txt", dir+"/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < len(content)/10; i++ {
+		path := fmt.Sprintf("%s/a.txt.%03d", dir, i)
+		if got := readFile(t, path); got != content[i*10:(i+1)*10] {
+			t.Errorf("%s = %q, want %q", _path.Base(path), got, content[i*10:(i+1)*10])
+		}
+	}
+
+	if got := atomic.LoadInt64(&sent); got != int64(len(content)) {
+		t.Errorf("server sent %d bytes, want %d", got, len(content))
+	}
+}
+
+func TestModTime(t *testing.T) {
+	srcDir, dstDir := tempDir(t), tempDir(t)
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, srcDir+"/a.txt", "hello", mtime)
+
+	if err := DoFile(backend.NewLocal(), backend.NewLocal(), []Option{noDelay(0)}, srcDir+"/a.txt", dstDir+"/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(filepath.FromSlash(dstDir + "/a.txt")); err != nil {
+		t.Fatal(err)
+	} else if !fi.ModTime().Equal(mtime) {
+		t.Errorf("local mtime = %v, want %v", fi.ModTime(), mtime)
+	}
+
+	// Nextcloud には PUT のヘッダーで更新日時を送り、PROPPATCH はしない
+	mu := sync.Mutex{}
+	methods := []string{}
+	header := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		methods = append(methods, r.Method)
+
+		switch r.Method {
+		case "PROPFIND":
+			http.NotFound(w, r)
+
+		case http.MethodPut:
+			io.Copy(io.Discard, r.Body)
+			header = r.Header.Get("X-OC-MTime")
+			w.WriteHeader(http.StatusCreated)
+
+		case "MKCOL":
+			w.WriteHeader(http.StatusCreated)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer srv.Close()
+
+	dst := backend.NewNextcloud(nextcloud.New(srv.URL+"/", srv.Client(), nil))
+	if err := DoFile(backend.NewLocal(), dst, []Option{noDelay(0)}, srcDir+"/a.txt", "/dir/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := fmt.Sprint(mtime.Unix()); header != want {
+		t.Errorf("X-OC-MTime = %q, want %q", header, want)
+	}
+	for _, method := range methods {
+		if method == "PROPPATCH" {
+			t.Errorf("requests = %v, want no PROPPATCH", methods)
+			break
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	srcDir, dstDir := tempDir(t), tempDir(t)
+	content := "hello, world"
+	writeFile(t, srcDir+"/a.txt", content, time.Now())
+
+	// ボディを読んでいる途中で切れたら、最初から送りなおす
+	src := newFakeBackend()
+	src.read = func(path string, n int) error {
+		if n == 1 {
+			return errors.New("connection reset")
+		}
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	var c *ctx
+	opts := []Option{noDelay(2), Events(events.NewWriter(buf)), capture(&c)}
+	if err := DoFile(src, backend.NewLocal(), opts, srcDir+"/a.txt", dstDir+"/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readFile(t, dstDir+"/a.txt"); got != content {
+		t.Errorf("dst = %q, want %q", got, content)
+	}
+	if n := src.opens[srcDir+"/a.txt"]; n != 2 {
+		t.Errorf("Open called %d times, want 2", n)
+	}
+
+	// 途中まで送った分は、進捗からもハッシュからも除かれている
+	if c.stats.bytes != int64(len(content)) {
+		t.Errorf("stats.bytes = %d, want %d", c.stats.bytes, len(content))
+	}
+
+	sum := sha256.Sum256([]byte(content))
+	finished := false
+	dec := json.NewDecoder(buf)
+	for {
+		var event struct {
+			Event  string `json:"event"`
+			Size   int64  `json:"size"`
+			SHA256 string `json:"sha256"`
+		}
+		if err := dec.Decode(&event); err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		if event.Event != "finish" {
+			continue
+		}
+		finished = true
+		if event.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("sha256 = %s, want %s", event.SHA256, hex.EncodeToString(sum[:]))
+		}
+	}
+	if !finished {
+		t.Error("finish event is not written")
+	}
+}
+
+func TestRetryCreate(t *testing.T) {
+	srcDir, dstDir := tempDir(t), tempDir(t)
+	writeFile(t, srcDir+"/a.txt", "hello", time.Now())
+
+	dst := newFakeBackend()
+	dst.create = func(path string, n int) error {
+		if n == 1 {
+			return errors.New("service unavailable")
+		}
+		return nil
+	}
+
+	if err := DoFile(backend.NewLocal(), dst, []Option{noDelay(2)}, srcDir+"/a.txt", dstDir+"/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if n := dst.creates[dstDir+"/a.txt"]; n != 2 {
+		t.Errorf("Create called %d times, want 2", n)
+	}
+}
+
+func TestRetryGiveUp(t *testing.T) {
+	srcDir := tempDir(t)
+	writeFile(t, srcDir+"/a.txt", "hello, world", time.Now())
+
+	// リトライしても切れ続けたら、最初の一回とリトライの回数だけ試してあきらめる
+	src := newFakeBackend()
+	src.read = func(path string, n int) error {
+		return errors.New("connection reset")
+	}
+
+	dstDir := tempDir(t)
+	if err := DoFile(src, backend.NewLocal(), []Option{noDelay(2)}, srcDir+"/a.txt", dstDir+"/a.txt"); err == nil {
+		t.Error("DoFile should fail")
+	}
+	if n := src.opens[srcDir+"/a.txt"]; n != 3 {
+		t.Errorf("Open called %d times, want 3", n)
+	}
+	if exists(dstDir + "/a.txt") {
+		t.Error("dst should be deleted after failure")
+	}
+
+	// 開けなかったときは Transport がリトライしているので、ここではリトライしない
+	src = newFakeBackend()
+	src.open = func(path string, n int) error {
+		return errors.New("bad gateway")
+	}
+
+	dstDir = tempDir(t)
+	if err := DoFile(src, backend.NewLocal(), []Option{noDelay(2)}, srcDir+"/a.txt", dstDir+"/a.txt"); err == nil {
+		t.Error("DoFile should fail")
+	}
+	if n := src.opens[srcDir+"/a.txt"]; n != 1 {
+		t.Errorf("Open called %d times, want 1", n)
+	}
+}
+
+func TestKeepGoing(t *testing.T) {
+	srcDir := tempDir(t)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		writeFile(t, srcDir+"/dir/"+name, name, time.Now())
+	}
+
+	dst := newFakeBackend()
+	dst.create = func(path string, n int) error {
+		if _path.Base(path) == "b.txt" {
+			return &os.PathError{Op: "create", Path: path, Err: os.ErrPermission}
+		}
+		return nil
+	}
+
+	dstDir := tempDir(t)
+	failureList := filepath.Join(t.TempDir(), "failed.txt")
+	name := func(path string) string { return "work:" + path }
+	opts := []Option{KeepGoing(true), FailureList(failureList), SourceName(name), Procs(1), noDelay(0)}
+
+	err := Do(backend.NewLocal(), dst, opts, Entries([]string{srcDir + "/dir"}), dstDir)
+
+	var failed *ErrFailed
+	if !errors.As(err, &failed) {
+		t.Fatalf("error = %v, want *ErrFailed", err)
+	}
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("error = %v, want to wrap os.ErrPermission", err)
+	}
+
+	want := []Entry{{Src: "work:" + srcDir + "/dir/b.txt", Dst: "dir/b.txt"}}
+	if !reflect.DeepEqual(failed.Entries, want) {
+		t.Errorf("Entries = %v, want %v", failed.Entries, want)
+	}
+
+	for _, name := range []string{"a.txt", "c.txt"} {
+		if got := readFile(t, dstDir+"/dir/"+name); got != name {
+			t.Errorf("%s = %q, want %q", name, got, name)
+		}
+	}
+
+	f, err := os.Open(failureList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	entries, err := ReadEntries(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("failure list = %v, want %v", entries, want)
+	}
+
+	// KeepGoing でなければ、最初に失敗したところで中断する
+	err = Do(backend.NewLocal(), dst, []Option{Procs(1), noDelay(0)}, Entries([]string{srcDir + "/dir"}), tempDir(t))
+	if !errors.Is(err, os.ErrPermission) || errors.As(err, &failed) {
+		t.Errorf("error = %v, want os.ErrPermission without *ErrFailed", err)
+	}
+}
+
+func TestRelPath(t *testing.T) {
+	tests := []struct {
+		dir  string
+		path string
+		want string
+	}{
+		{".", "a/b.txt", "a/b.txt"},
+		{"/", "/a/b.txt", "a/b.txt"},
+		{"/backup", "/backup/a/b.txt", "a/b.txt"},
+		{"backup", "backup/b.txt", "b.txt"},
+	}
+
+	for _, tt := range tests {
+		if got := relPath(tt.dir, tt.path); got != tt.want {
+			t.Errorf("relPath(%q, %q) = %q, want %q", tt.dir, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestEntries(t *testing.T) {
+	entries := []Entry{
+		{Src: "/a.txt"},
+		{Src: "work:/dir/b c.txt", Dst: "dir/b c.txt"},
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteEntries(buf, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadEntries(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("ReadEntries(WriteEntries(entries)) = %v, want %v", got, entries)
+	}
+
+	// 空の行と CRLF は無視する
+	got, err = ReadEntries(bytes.NewReader([]byte("/a.txt\r\n\r\n\nwork:/dir/b c.txt\tdir/b c.txt\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("ReadEntries = %v, want %v", got, entries)
+	}
+}
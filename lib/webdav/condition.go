@@ -0,0 +1,60 @@
+package webdav
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Condition は条件付きリクエストにするためのヘッダーなど、リクエストに付けるヘッダーを設定する
+type Condition func(*http.Request)
+
+// IfMatch はサーバー上の ETag が etag と一致するときだけリクエストを処理させる
+func IfMatch(etag string) Condition {
+	return func(r *http.Request) {
+		r.Header.Set("If-Match", quoteETag(etag))
+	}
+}
+
+// IfNoneMatch はサーバー上の ETag が etag と一致しないときだけリクエストを処理させる
+// etag に "*" を指定すると、存在しないときだけ処理させる
+func IfNoneMatch(etag string) Condition {
+	return func(r *http.Request) {
+		r.Header.Set("If-None-Match", quoteETag(etag))
+	}
+}
+
+// MTime は PUT したファイルの更新日時を mtime にさせる。Nextcloud (ownCloud) の X-OC-MTime ヘッダーを使う
+func MTime(mtime time.Time) Condition {
+	return func(r *http.Request) {
+		r.Header.Set("X-OC-MTime", strconv.FormatInt(mtime.Unix(), 10))
+	}
+}
+
+// quoteETag は getetag のようにダブルクォートで囲まれていない ETag を囲む
+func quoteETag(etag string) string {
+	if etag == "*" || strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+// Header は Put や Get のレスポンスヘッダーのうち、条件付きリクエストなどに使うもの
+type Header struct {
+	ETag   string // ETag。なければ OC-ETag
+	FileID string // OC-FileId
+}
+
+func parseHeader(h http.Header) *Header {
+	header := &Header{
+		ETag:   h.Get("ETag"),
+		FileID: h.Get("OC-FileId"),
+	}
+
+	if header.ETag == "" {
+		header.ETag = h.Get("OC-ETag")
+	}
+
+	return header
+}
@@ -1,14 +1,19 @@
 package webdav
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
 	"net/http"
 )
 
-func (n *WebDAV) Delete(path string) error {
+func (n *WebDAV) Delete(path string, conds ...Condition) error {
+	return n.DeleteContext(context.Background(), path, conds...)
+}
+
+func (n *WebDAV) DeleteContext(ctx context.Context, path string, conds ...Condition) error {
 	url := n.mkURL(path)
-	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, url, nil)
 	if err != nil {
 		return &Error{
 			Op:   http.MethodDelete,
@@ -17,17 +22,16 @@ func (n *WebDAV) Delete(path string) error {
 			Msg:  err.Error(),
 		}
 	}
+	for _, cond := range conds {
+		cond(req)
+	}
+
 	if n.AuthFunc != nil {
 		n.AuthFunc(req)
 	}
 	res, err := n.c.Do(req)
 	if err != nil {
-		return &Error{
-			Op:   http.MethodDelete,
-			URL:  url,
-			Type: ErrInvalid,
-			Msg:  err.Error(),
-		}
+		return requestError(ctx, http.MethodDelete, url, err)
 	}
 
 	defer func() {
@@ -39,25 +43,12 @@ func (n *WebDAV) Delete(path string) error {
 	case http.StatusOK, http.StatusNoContent:
 		return nil
 	case http.StatusUnauthorized, http.StatusForbidden:
-		return &Error{
-			Op:   http.MethodDelete,
-			URL:  url,
-			Type: ErrPermission,
-			Msg:  res.Status,
-		}
+		return responseError(http.MethodDelete, url, res, ErrPermission)
 	case http.StatusNotFound:
-		return &Error{
-			Op:   http.MethodDelete,
-			URL:  url,
-			Type: ErrNotExist,
-			Msg:  res.Status,
-		}
+		return responseError(http.MethodDelete, url, res, ErrNotExist)
+	case http.StatusPreconditionFailed:
+		return responseError(http.MethodDelete, url, res, ErrPrecondition)
 	default:
-		return &Error{
-			Op:   http.MethodDelete,
-			URL:  url,
-			Type: ErrInvalid,
-			Msg:  res.Status,
-		}
+		return responseError(http.MethodDelete, url, res, ErrUnknown)
 	}
 }
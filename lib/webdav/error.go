@@ -1,11 +1,29 @@
 package webdav
 
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
 const (
 	ErrUnknown = iota
 	ErrInvalid
 	ErrPermission
 	ErrExist
 	ErrNotExist
+	ErrCanceled
+	ErrPrecondition        // 412 条件付きリクエストの条件を満たさなかった
+	ErrLocked              // 423 ロックされている
+	ErrTooLarge            // 413 ファイルが大きすぎる
+	ErrInsufficientStorage // 507 容量が足りない (クォータを超えた)
+	ErrThrottled           // 429, 503 リクエストが多すぎるか、メンテナンス中
+	ErrServer              // 5xx サーバーのエラー
+	ErrNetwork             // リクエストを送れなかったか、レスポンスを受け取れなかった
 )
 
 type Error struct {
@@ -13,19 +31,170 @@ type Error struct {
 	URL  string
 	Type int
 	Msg  string
+
+	Status    int    // HTTP のステータスコード。レスポンスがなかったときは 0
+	Exception string // サーバー (Sabre) の例外のクラス名。Sabre\DAV\Exception\InsufficientStorage など
+	Message   string // サーバーが返したエラーメッセージ
+	Err       error  // 通信エラーなど、元になったエラー
 }
 
 func (e *Error) Error() string {
+	if e.Message != "" {
+		return e.Op + " " + e.URL + ": " + e.Msg + ": " + e.Message
+	}
 	return e.Op + " " + e.URL + ": " + e.Msg
 }
 
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is は errors.Is(err, os.ErrNotExist) などで、Type に対応する os のエラーと比べられるようにする
+// ErrPrecondition は既に存在するという意味ではないので os.ErrExist にはしない。IsPrecondition で調べること
+func (e *Error) Is(target error) bool {
+	switch e.Type {
+	case ErrInvalid:
+		return target == os.ErrInvalid
+	case ErrPermission:
+		return target == os.ErrPermission
+	case ErrExist:
+		return target == os.ErrExist
+	case ErrNotExist:
+		return target == os.ErrNotExist
+	default:
+		return false
+	}
+}
+
+// Description は Type ごとの、ユーザーに見せるための説明を返す
+// サーバーがエラーメッセージを返していれば、それも付ける
+func (e *Error) Description() string {
+	var desc string
+	switch e.Type {
+	case ErrPermission:
+		desc = os.ErrPermission.Error()
+	case ErrExist:
+		desc = os.ErrExist.Error()
+	case ErrNotExist:
+		desc = os.ErrNotExist.Error()
+	case ErrCanceled:
+		desc = context.Canceled.Error()
+		if e.Err != nil {
+			desc = e.Err.Error()
+		}
+	case ErrPrecondition:
+		desc = "file has been changed (precondition failed)"
+	case ErrLocked:
+		desc = "file is locked"
+	case ErrTooLarge:
+		desc = "file too large"
+	case ErrInsufficientStorage:
+		desc = "insufficient storage (quota exceeded)"
+	case ErrThrottled:
+		desc = "server is busy or in maintenance: " + e.Msg
+	case ErrServer:
+		desc = "server error: " + e.Msg
+	case ErrNetwork:
+		desc = "network error: " + e.Msg
+	default:
+		desc = os.ErrInvalid.Error() + ": " + e.Msg
+	}
+
+	if e.Message != "" {
+		desc += ": " + e.Message
+	}
+
+	return desc
+}
+
+// preconditionType は 412 が返ってきたときのエラーの種類を、送ったリクエストのヘッダーから決める
+// If-Match を付けずに If-None-Match: * や Overwrite: F を付けたときは、既に存在するという意味なので ErrExist にする
+func preconditionType(req *http.Request) int {
+	if req.Header.Get("If-Match") != "" {
+		return ErrPrecondition
+	}
+
+	if req.Header.Get("If-None-Match") == "*" || req.Header.Get("Overwrite") == "F" {
+		return ErrExist
+	}
+
+	return ErrPrecondition
+}
+
+// requestError はリクエストを送れなかったときのエラーを作る
+// ctx がキャンセルされていたりタイムアウトしていたら ErrCanceled にする
+func requestError(ctx context.Context, op string, url string, err error) *Error {
+	if ctx.Err() != nil {
+		return &Error{Op: op, URL: url, Type: ErrCanceled, Msg: ctx.Err().Error(), Err: ctx.Err()}
+	}
+
+	return &Error{Op: op, URL: url, Type: ErrNetwork, Msg: err.Error(), Err: err}
+}
+
+// responseError はエラーのレスポンスから Error を作る
+// t が ErrUnknown ならステータスコードから Type を決める
+// Nextcloud (Sabre) が返す XML からは、例外のクラス名とメッセージを取り出す
+//
+//	<d:error xmlns:d="DAV:" xmlns:s="http://sabredav.org/ns">
+//	  <s:exception>Sabre\DAV\Exception\InsufficientStorage</s:exception>
+//	  <s:message>Insufficient space in /files/user, 1024 required, 0 available</s:message>
+//	</d:error>
+func responseError(op string, url string, resp *http.Response, t int) *Error {
+	if t == ErrUnknown {
+		t = statusType(resp.StatusCode)
+	}
+
+	e := &Error{Op: op, URL: url, Type: t, Msg: resp.Status, Status: resp.StatusCode}
+
+	if strings.Contains(resp.Header.Get("Content-Type"), "xml") {
+		v := struct {
+			XMLName   xml.Name `xml:"DAV: error"`
+			Exception string   `xml:"http://sabredav.org/ns exception"`
+			Message   string   `xml:"http://sabredav.org/ns message"`
+		}{}
+		if err := xml.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&v); err == nil {
+			e.Exception = strings.TrimSpace(v.Exception)
+			e.Message = strings.TrimSpace(v.Message)
+		}
+	}
+
+	return e
+}
+
+// statusType はステータスコードから Type を決める
+// MKCOL の 405 のようにメソッドによって意味が変わるものは、呼び出し側で指定すること
+func statusType(status int) int {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrPermission
+	case http.StatusNotFound, http.StatusGone:
+		return ErrNotExist
+	case http.StatusPreconditionFailed:
+		return ErrPrecondition
+	case http.StatusRequestEntityTooLarge:
+		return ErrTooLarge
+	case http.StatusLocked:
+		return ErrLocked
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return ErrThrottled
+	case http.StatusInsufficientStorage:
+		return ErrInsufficientStorage
+	}
+
+	if status >= 500 {
+		return ErrServer
+	}
+
+	return ErrInvalid
+}
+
 func TypeOf(err error) int {
 	if err == nil {
 		return -1
 	}
 
-	e, ok := err.(*Error)
-	if !ok {
+	var e *Error
+	if !errors.As(err, &e) {
 		return ErrUnknown
 	}
 
@@ -51,3 +220,35 @@ func IsExist(err error) bool {
 func IsNotExist(err error) bool {
 	return TypeEqual(err, ErrNotExist)
 }
+
+func IsCanceled(err error) bool {
+	return TypeEqual(err, ErrCanceled)
+}
+
+func IsPrecondition(err error) bool {
+	return TypeEqual(err, ErrPrecondition)
+}
+
+func IsLocked(err error) bool {
+	return TypeEqual(err, ErrLocked)
+}
+
+func IsTooLarge(err error) bool {
+	return TypeEqual(err, ErrTooLarge)
+}
+
+func IsInsufficientStorage(err error) bool {
+	return TypeEqual(err, ErrInsufficientStorage)
+}
+
+func IsThrottled(err error) bool {
+	return TypeEqual(err, ErrThrottled)
+}
+
+func IsServer(err error) bool {
+	return TypeEqual(err, ErrServer)
+}
+
+func IsNetwork(err error) bool {
+	return TypeEqual(err, ErrNetwork)
+}
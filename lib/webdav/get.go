@@ -1,16 +1,58 @@
 package webdav
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
-func (n *WebDAV) Get(path string) (io.ReadCloser, error) {
+func (n *WebDAV) Get(path string) (io.ReadCloser, *Header, error) {
+	return n.GetContext(context.Background(), path)
+}
+
+func (n *WebDAV) GetContext(ctx context.Context, path string) (io.ReadCloser, *Header, error) {
+	return n.get(ctx, path, nil)
+}
+
+// GetRange は offset から length バイトだけを Range リクエストで取得する
+// length が負のときはファイルの終わりまで取得する
+func (n *WebDAV) GetRange(path string, offset int64, length int64) (io.ReadCloser, *Header, error) {
+	return n.GetRangeContext(context.Background(), path, offset, length)
+}
+
+func (n *WebDAV) GetRangeContext(ctx context.Context, path string, offset int64, length int64) (io.ReadCloser, *Header, error) {
+	if length == 0 {
+		return emptyReadCloser(), &Header{}, nil
+	}
+
+	return n.get(ctx, path, &byteRange{offset: offset, length: length})
+}
+
+type byteRange struct {
+	offset int64
+	length int64 // 負ならファイルの終わりまで
+}
+
+func (r *byteRange) String() string {
+	v := "bytes=" + strconv.FormatInt(r.offset, 10) + "-"
+	if r.length > 0 {
+		v += strconv.FormatInt(r.offset+r.length-1, 10)
+	}
+	return v
+}
+
+func (n *WebDAV) get(ctx context.Context, path string, rng *byteRange) (io.ReadCloser, *Header, error) {
 	url := n.mkURL(path)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, &Error{Op: http.MethodGet, URL: url, Type: ErrInvalid, Msg: err.Error()}
+		return nil, nil, &Error{Op: http.MethodGet, URL: url, Type: ErrInvalid, Msg: err.Error()}
+	}
+
+	if rng != nil {
+		req.Header.Set("Range", rng.String())
 	}
 
 	if n.AuthFunc != nil {
@@ -19,9 +61,11 @@ func (n *WebDAV) Get(path string) (io.ReadCloser, error) {
 
 	resp, err := n.c.Do(req)
 	if err != nil {
-		return nil, &Error{Op: http.MethodGet, URL: url, Type: ErrInvalid, Msg: err.Error()}
+		return nil, nil, requestError(ctx, http.MethodGet, url, err)
 	}
 
+	header := parseHeader(resp.Header)
+
 	rc := readCloser{
 		Reader: resp.Body,
 		close: func() error {
@@ -30,21 +74,47 @@ func (n *WebDAV) Get(path string) (io.ReadCloser, error) {
 		},
 	}
 
-	if resp.StatusCode == http.StatusOK {
-		return rc, nil
+	switch {
+	case resp.StatusCode == http.StatusOK && rng == nil:
+		return rc, header, nil
+
+	case resp.StatusCode == http.StatusPartialContent && rng != nil:
+		return rc, header, nil
+
+	case resp.StatusCode == http.StatusOK && rng != nil:
+		// Range を無視して全体が返ってきたので、必要な部分だけを切り出す
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, rng.offset); err != nil {
+			rc.Close()
+			if err == io.EOF {
+				return emptyReadCloser(), header, nil
+			}
+			return nil, nil, &Error{Op: http.MethodGet, URL: url, Type: ErrInvalid, Msg: err.Error()}
+		}
+
+		if rng.length > 0 {
+			// 残りを読み捨てないように Close では Body を直接閉じる
+			return readCloser{Reader: io.LimitReader(resp.Body, rng.length), close: resp.Body.Close}, header, nil
+		}
+		return rc, header, nil
+
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && rng != nil:
+		// 空のファイルや、ファイルの終わりより後ろを要求したとき
+		rc.Close()
+		return emptyReadCloser(), header, nil
 	}
 
-	rc.Close()
+	// エラーのときはサーバーのエラーメッセージを読んでから閉じる
+	defer rc.Close()
 
 	switch resp.StatusCode {
 	case http.StatusUnauthorized, http.StatusForbidden:
-		return nil, &Error{Op: http.MethodGet, URL: url, Type: ErrPermission, Msg: resp.Status}
+		return nil, nil, responseError(http.MethodGet, url, resp, ErrPermission)
 
 	case http.StatusNotFound:
-		return nil, &Error{Op: http.MethodGet, URL: url, Type: ErrNotExist, Msg: resp.Status}
+		return nil, nil, responseError(http.MethodGet, url, resp, ErrNotExist)
 
 	default:
-		return nil, &Error{Op: http.MethodGet, URL: url, Type: ErrInvalid, Msg: resp.Status}
+		return nil, nil, responseError(http.MethodGet, url, resp, ErrUnknown)
 	}
 }
 
@@ -56,3 +126,7 @@ type readCloser struct {
 func (r readCloser) Close() error {
 	return r.close()
 }
+
+func emptyReadCloser() io.ReadCloser {
+	return ioutil.NopCloser(strings.NewReader(""))
+}
@@ -1,16 +1,21 @@
 package webdav
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
 	"net/http"
 )
 
 func (n *WebDAV) Mkcol(path string) error {
+	return n.MkcolContext(context.Background(), path)
+}
+
+func (n *WebDAV) MkcolContext(ctx context.Context, path string) error {
 	const MethodMkcol = "MKCOL"
 
 	url := n.mkURL(path)
-	req, err := http.NewRequest(MethodMkcol, url, nil)
+	req, err := http.NewRequestWithContext(ctx, MethodMkcol, url, nil)
 	if err != nil {
 		return &Error{Op: MethodMkcol, URL: url, Type: ErrInvalid, Msg: err.Error()}
 	}
@@ -21,7 +26,7 @@ func (n *WebDAV) Mkcol(path string) error {
 
 	resp, err := n.c.Do(req)
 	if err != nil {
-		return &Error{Op: MethodMkcol, URL: url, Type: ErrInvalid, Msg: err.Error()}
+		return requestError(ctx, MethodMkcol, url, err)
 	}
 	defer func() {
 		io.Copy(ioutil.Discard, resp.Body)
@@ -33,18 +38,18 @@ func (n *WebDAV) Mkcol(path string) error {
 		return nil
 
 	case http.StatusUnauthorized, http.StatusForbidden:
-		return &Error{Op: MethodMkcol, URL: url, Type: ErrPermission, Msg: resp.Status}
+		return responseError(MethodMkcol, url, resp, ErrPermission)
 
 	case http.StatusMethodNotAllowed:
-		return &Error{Op: MethodMkcol, URL: url, Type: ErrExist, Msg: resp.Status}
+		return responseError(MethodMkcol, url, resp, ErrExist)
 
 	case http.StatusConflict:
-		return &Error{Op: MethodMkcol, URL: url, Type: ErrNotExist, Msg: resp.Status}
+		return responseError(MethodMkcol, url, resp, ErrNotExist)
 
-	case http.StatusUnsupportedMediaType, http.StatusInsufficientStorage:
-		return &Error{Op: MethodMkcol, URL: url, Type: ErrInvalid, Msg: resp.Status}
+	case http.StatusUnsupportedMediaType:
+		return responseError(MethodMkcol, url, resp, ErrInvalid)
 
 	default:
-		return &Error{Op: MethodMkcol, URL: url, Type: ErrInvalid, Msg: resp.Status}
+		return responseError(MethodMkcol, url, resp, ErrUnknown)
 	}
 }
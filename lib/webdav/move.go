@@ -0,0 +1,65 @@
+package webdav
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Move は src を dst に移動する。dst も同じ WebDAV 上のパスで指定する
+// conds の条件は src に対して評価される
+func (n *WebDAV) Move(src string, dst string, overwrite bool, conds ...Condition) error {
+	return n.MoveContext(context.Background(), src, dst, overwrite, conds...)
+}
+
+func (n *WebDAV) MoveContext(ctx context.Context, src string, dst string, overwrite bool, conds ...Condition) error {
+	const MethodMove = "MOVE"
+
+	url := n.mkURL(src)
+	req, err := http.NewRequestWithContext(ctx, MethodMove, url, nil)
+	if err != nil {
+		return &Error{Op: MethodMove, URL: url, Type: ErrInvalid, Msg: err.Error()}
+	}
+
+	req.Header.Set("Destination", n.mkURL(dst))
+	if overwrite {
+		req.Header.Set("Overwrite", "T")
+	} else {
+		req.Header.Set("Overwrite", "F")
+	}
+
+	for _, cond := range conds {
+		cond(req)
+	}
+
+	if n.AuthFunc != nil {
+		n.AuthFunc(req)
+	}
+
+	resp, err := n.c.Do(req)
+	if err != nil {
+		return requestError(ctx, MethodMove, url, err)
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusNoContent:
+		return nil
+
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return responseError(MethodMove, url, resp, ErrPermission)
+
+	case http.StatusPreconditionFailed:
+		return responseError(MethodMove, url, resp, preconditionType(req))
+
+	case http.StatusConflict, http.StatusNotFound:
+		return responseError(MethodMove, url, resp, ErrNotExist)
+
+	default:
+		return responseError(MethodMove, url, resp, ErrUnknown)
+	}
+}
@@ -2,6 +2,7 @@ package webdav
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
 	"errors"
 	"fmt"
@@ -39,10 +40,14 @@ const (
 )
 
 func (n *WebDAV) Propfind(path string, depth string, payload []byte) ([]*Response, error) {
+	return n.PropfindContext(context.Background(), path, depth, payload)
+}
+
+func (n *WebDAV) PropfindContext(ctx context.Context, path string, depth string, payload []byte) ([]*Response, error) {
 	const MethodPropfind = "PROPFIND"
 
 	url := n.mkURL(path)
-	req, err := http.NewRequest(MethodPropfind, url, bytes.NewReader(payload))
+	req, err := http.NewRequestWithContext(ctx, MethodPropfind, url, bytes.NewReader(payload))
 	if err != nil {
 		return nil, &Error{Op: MethodPropfind, URL: url, Type: ErrInvalid, Msg: err.Error()}
 	}
@@ -61,7 +66,7 @@ func (n *WebDAV) Propfind(path string, depth string, payload []byte) ([]*Respons
 
 	resp, err := n.c.Do(req)
 	if err != nil {
-		return nil, &Error{Op: MethodPropfind, URL: url, Type: ErrInvalid, Msg: err.Error()}
+		return nil, requestError(ctx, MethodPropfind, url, err)
 	}
 	defer func() {
 		io.Copy(ioutil.Discard, resp.Body)
@@ -70,46 +75,55 @@ func (n *WebDAV) Propfind(path string, depth string, payload []byte) ([]*Respons
 
 	switch resp.StatusCode {
 	case http.StatusOK, http.StatusMultiStatus:
-		responses := []*Response{}
-
-		d := xml.NewDecoder(resp.Body)
-		for {
-			token, err := d.Token()
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				return nil, &Error{Op: MethodPropfind, URL: url, Type: ErrInvalid, Msg: err.Error()}
-			}
+		responses, err := parseMultistatus(resp.Body)
+		if err != nil {
+			return nil, &Error{Op: MethodPropfind, URL: url, Type: ErrInvalid, Msg: err.Error()}
+		}
 
-			start, ok := token.(xml.StartElement)
-			if !ok {
-				continue
-			}
+		return responses, nil
 
-			if start.Name.Local != "response" {
-				continue
-			}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, responseError(MethodPropfind, url, resp, ErrPermission)
 
-			response, err := parseResponse(d, &start)
-			if err != nil {
-				return nil, &Error{Op: MethodPropfind, URL: url, Type: ErrInvalid, Msg: err.Error()}
-			}
+	case http.StatusNotFound:
+		return nil, responseError(MethodPropfind, url, resp, ErrNotExist)
+
+	default:
+		return nil, responseError(MethodPropfind, url, resp, ErrUnknown)
+	}
+}
+
+func parseMultistatus(r io.Reader) ([]*Response, error) {
+	responses := []*Response{}
 
-			responses = append(responses, response)
+	d := xml.NewDecoder(r)
+	for {
+		token, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
 		}
 
-		return responses, nil
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
 
-	case http.StatusForbidden:
-		return nil, &Error{Op: MethodPropfind, URL: url, Type: ErrPermission, Msg: resp.Status}
+		if start.Name.Local != "response" {
+			continue
+		}
 
-	case http.StatusNotFound:
-		return nil, &Error{Op: MethodPropfind, URL: url, Type: ErrNotExist, Msg: resp.Status}
+		response, err := parseResponse(d, &start)
+		if err != nil {
+			return nil, err
+		}
 
-	default:
-		return nil, &Error{Op: MethodPropfind, URL: url, Type: ErrInvalid, Msg: resp.Status}
+		responses = append(responses, response)
 	}
+
+	return responses, nil
 }
 
 type response struct {
@@ -0,0 +1,64 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// Proppatch はプロパティを変更する
+// プロパティごとの結果は Response の Prop の Status に入っている
+func (n *WebDAV) Proppatch(path string, payload []byte) ([]*Response, error) {
+	return n.ProppatchContext(context.Background(), path, payload)
+}
+
+func (n *WebDAV) ProppatchContext(ctx context.Context, path string, payload []byte) ([]*Response, error) {
+	const MethodProppatch = "PROPPATCH"
+
+	url := n.mkURL(path)
+	req, err := http.NewRequestWithContext(ctx, MethodProppatch, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, &Error{Op: MethodProppatch, URL: url, Type: ErrInvalid, Msg: err.Error()}
+	}
+
+	req.Header.Add("Content-Type", "text/xml; charset=UTF-8")
+	req.Header.Add("Content-Length", strconv.Itoa(len(payload)))
+
+	req.Header.Add("Accept", "application/xml, text/xml")
+	req.Header.Add("Accept-Charset", "utf-8")
+
+	if n.AuthFunc != nil {
+		n.AuthFunc(req)
+	}
+
+	resp, err := n.c.Do(req)
+	if err != nil {
+		return nil, requestError(ctx, MethodProppatch, url, err)
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusMultiStatus:
+		responses, err := parseMultistatus(resp.Body)
+		if err != nil {
+			return nil, &Error{Op: MethodProppatch, URL: url, Type: ErrInvalid, Msg: err.Error()}
+		}
+
+		return responses, nil
+
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, responseError(MethodProppatch, url, resp, ErrPermission)
+
+	case http.StatusNotFound:
+		return nil, responseError(MethodProppatch, url, resp, ErrNotExist)
+
+	default:
+		return nil, responseError(MethodProppatch, url, resp, ErrUnknown)
+	}
+}
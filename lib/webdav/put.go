@@ -1,16 +1,35 @@
 package webdav
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
 	"net/http"
 )
 
-func (n *WebDAV) Put(path string, body io.Reader) error {
+func (n *WebDAV) Put(path string, body io.Reader, conds ...Condition) (*Header, error) {
+	return n.PutContext(context.Background(), path, body, conds...)
+}
+
+func (n *WebDAV) PutContext(ctx context.Context, path string, body io.Reader, conds ...Condition) (*Header, error) {
 	url := n.mkURL(path)
-	req, err := http.NewRequest(http.MethodPut, url, body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, body)
 	if err != nil {
-		return &Error{Op: http.MethodPut, URL: url, Type: ErrInvalid, Msg: err.Error()}
+		return nil, &Error{Op: http.MethodPut, URL: url, Type: ErrInvalid, Msg: err.Error()}
+	}
+
+	// 先頭に戻せる body なら、Transport がリトライするときに送りなおせるようにする
+	if seeker, ok := body.(io.ReadSeeker); ok && req.GetBody == nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return ioutil.NopCloser(seeker), nil
+		}
+	}
+
+	for _, cond := range conds {
+		cond(req)
 	}
 
 	if n.AuthFunc != nil {
@@ -19,7 +38,7 @@ func (n *WebDAV) Put(path string, body io.Reader) error {
 
 	resp, err := n.c.Do(req)
 	if err != nil {
-		return &Error{Op: http.MethodPut, URL: url, Type: ErrInvalid, Msg: err.Error()}
+		return nil, requestError(ctx, http.MethodPut, url, err)
 	}
 	defer func() {
 		io.Copy(ioutil.Discard, resp.Body)
@@ -28,15 +47,19 @@ func (n *WebDAV) Put(path string, body io.Reader) error {
 
 	switch resp.StatusCode {
 	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
-		return nil
+		return parseHeader(resp.Header), nil
 
 	case http.StatusUnauthorized, http.StatusForbidden:
-		return &Error{Op: http.MethodPut, URL: url, Type: ErrPermission, Msg: resp.Status}
+		return nil, responseError(http.MethodPut, url, resp, ErrPermission)
 
 	case http.StatusConflict, http.StatusNotFound:
-		return &Error{Op: http.MethodPut, URL: url, Type: ErrNotExist, Msg: resp.Status}
+		return nil, responseError(http.MethodPut, url, resp, ErrNotExist)
+
+	case http.StatusPreconditionFailed:
+		// If-Match や If-None-Match の条件を満たさなかった
+		return nil, responseError(http.MethodPut, url, resp, preconditionType(req))
 
 	default:
-		return &Error{Op: http.MethodPut, URL: url, Type: ErrInvalid, Msg: resp.Status}
+		return nil, responseError(http.MethodPut, url, resp, ErrUnknown)
 	}
 }
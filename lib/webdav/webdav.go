@@ -42,3 +42,12 @@ func BasicAuth(username, password, appname, version string) AuthFunc {
 }
 
 type AuthFunc func(*http.Request)
+
+// UserAgent は User-Agent だけを付ける AuthFunc を返す
+// OAuth2 のように、認証ヘッダーを http.Client の Transport で付けるときに使う
+func UserAgent(appname, version string) AuthFunc {
+	return func(r *http.Request) {
+		userAgent := fmt.Sprintf("%s/%s", appname, version)
+		r.Header.Set("User-Agent", userAgent)
+	}
+}
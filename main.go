@@ -2,29 +2,57 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/kurusugawa-computer/nextcloud-cli/cmd/cat"
+	"github.com/kurusugawa-computer/nextcloud-cli/cmd/cp"
 	"github.com/kurusugawa-computer/nextcloud-cli/cmd/credits"
 	"github.com/kurusugawa-computer/nextcloud-cli/cmd/download"
+	"github.com/kurusugawa-computer/nextcloud-cli/cmd/du"
+	"github.com/kurusugawa-computer/nextcloud-cli/cmd/edit"
 	"github.com/kurusugawa-computer/nextcloud-cli/cmd/find"
 	"github.com/kurusugawa-computer/nextcloud-cli/cmd/get"
+	"github.com/kurusugawa-computer/nextcloud-cli/cmd/head"
 	"github.com/kurusugawa-computer/nextcloud-cli/cmd/list"
+	"github.com/kurusugawa-computer/nextcloud-cli/cmd/mkdir"
 	"github.com/kurusugawa-computer/nextcloud-cli/cmd/open"
+	"github.com/kurusugawa-computer/nextcloud-cli/cmd/put"
+	"github.com/kurusugawa-computer/nextcloud-cli/cmd/quota"
 	"github.com/kurusugawa-computer/nextcloud-cli/cmd/rm"
+	"github.com/kurusugawa-computer/nextcloud-cli/cmd/tail"
+	"github.com/kurusugawa-computer/nextcloud-cli/cmd/touch"
 	"github.com/kurusugawa-computer/nextcloud-cli/cmd/upload"
 	"github.com/kurusugawa-computer/nextcloud-cli/credentials"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/config"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/events"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/httplog"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/loginflow"
 	"github.com/kurusugawa-computer/nextcloud-cli/lib/nextcloud"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/oauth2"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/retry"
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/transfer"
 	"github.com/kurusugawa-computer/nextcloud-cli/lib/webdav"
+	_open "github.com/skratchdot/open-golang/open"
+	"github.com/thamaji/cachedir"
 	"golang.org/x/crypto/ssh/terminal"
 	"golang.org/x/sync/singleflight"
 	"gopkg.in/urfave/cli.v2"
@@ -42,18 +70,147 @@ func main() {
 		defaultProcs = 1
 	}
 
-	app := &cli.App{
-		Name:                  appname,
-		Usage:                 "NextCloud CLI",
-		ArgsUsage:             " ",
-		Version:               version,
-		Flags:                 []cli.Flag{},
+	app := newApp(defaultProcs)
+	useConfig(app, app.Commands, nil)
+
+	err := app.Run(humanReadableArgs(app, os.Args))
+
+	if harLog != nil {
+		if err := harLog.WriteFile(harFile); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to write har: "+err.Error())
+		}
+	}
+
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Fprintln(os.Stderr, "interrupted")
+			os.Exit(exitInterrupted)
+		}
+		fmt.Fprintln(os.Stderr, err.Error())
+		if webdav.IsPermission(err) {
+			var e *webdav.Error
+			if errors.As(err, &e) && e.Status == http.StatusUnauthorized {
+				fmt.Fprintln(os.Stderr, "authentication failed, try: "+appname+" login")
+			}
+		}
+		os.Exit(exitCode(err))
+	}
+}
+
+// newApp は nextcloud-cli の App を作る。defaultProcs は --procs の既定値
+func newApp(defaultProcs int) *cli.App {
+	// -v は --debug に使うので、--version は長い名前だけにする
+	cli.VersionFlag = &cli.BoolFlag{
+		Name:  "version",
+		Usage: "print the version",
+	}
+
+	return &cli.App{
+		Name:      appname,
+		Usage:     "NextCloud CLI",
+		ArgsUsage: " ",
+		Version:   version,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "no-cache",
+				Usage: "do not use the metadata cache",
+				Value: false,
+			},
+			&cli.StringFlag{
+				Name:    "profile",
+				Usage:   "use the credentials saved as `PROFILE`",
+				EnvVars: []string{"NEXTCLOUD_PROFILE"},
+			},
+			&cli.StringFlag{
+				Name:    "share-password",
+				Usage:   "set the password of public share links",
+				EnvVars: []string{"NEXTCLOUD_SHARE_PASSWORD"},
+			},
+			&cli.StringFlag{
+				Name:    "credentials-file",
+				Usage:   "read url, username and password from JSON `FILE` instead of the saved credentials",
+				EnvVars: []string{"NEXTCLOUD_CREDENTIALS_FILE"},
+			},
+			&cli.IntFlag{
+				Name:    "retry",
+				Usage:   "set max retry count of requests failed with network errors, 5xx, 423 or 429",
+				Value:   3,
+				EnvVars: []string{"NEXTCLOUD_RETRY"},
+			},
+			&cli.StringFlag{
+				Name:    "ca-cert",
+				Usage:   "trust the CA certificates in PEM `FILE` in addition to the system ones",
+				EnvVars: []string{"NEXTCLOUD_CA_CERT"},
+			},
+			&cli.StringFlag{
+				Name:  "client-cert",
+				Usage: "use the client certificate in PEM `FILE` (mutual TLS)",
+			},
+			&cli.StringFlag{
+				Name:  "client-key",
+				Usage: "use the private key of the client certificate in PEM `FILE`",
+			},
+			&cli.BoolFlag{
+				Name:  "insecure",
+				Usage: "do not verify the server certificate (DANGEROUS)",
+			},
+			&cli.StringFlag{
+				Name:    "proxy",
+				Usage:   "use the proxy `URL` (\"direct\" to use no proxy, default: HTTPS_PROXY etc.)",
+				EnvVars: []string{"NEXTCLOUD_PROXY"},
+			},
+			&cli.StringFlag{
+				Name:  "timeout",
+				Usage: "set the timeout of connecting and TLS handshake (e.g. 30s)",
+			},
+			&cli.StringFlag{
+				Name:  "idle-timeout",
+				Usage: "set the time to close idle connections (e.g. 90s)",
+			},
+			&cli.IntFlag{
+				Name:  "max-conns",
+				Usage: "set the max number of connections to the server (0 is unlimited)",
+			},
+			&cli.BoolFlag{
+				Name:  "http2",
+				Usage: "use HTTP/2",
+			},
+			&cli.BoolFlag{
+				Name:    "debug",
+				Aliases: []string{"v"},
+				Usage:   "print HTTP requests and responses to stderr (Authorization is redacted)",
+				EnvVars: []string{"NEXTCLOUD_CLI_DEBUG"},
+			},
+			&cli.BoolFlag{
+				Name:  "debug-body",
+				Usage: "with --debug, also print XML bodies such as PROPFIND requests and responses",
+			},
+			&cli.StringFlag{
+				Name:  "har",
+				Usage: "write HTTP requests and responses to `FILE` in HAR format for bug reports",
+			},
+			&cli.StringFlag{
+				Name:    "config",
+				Usage:   "read default flag values from TOML `FILE` (default: $XDG_CONFIG_HOME/nextcloud-cli/config.toml)",
+				EnvVars: []string{"NEXTCLOUD_CONFIG"},
+			},
+		},
+		Before: func(ctx *cli.Context) error {
+			// 設定ファイルのエラーは、config で直せるようにコマンドを実行するときに返す
+			confErr = loadConfig(ctx)
+
+			if ctx.String("har") != "" {
+				harLog = httplog.NewHAR(appname, version)
+				harFile = ctx.String("har")
+			}
+			return nil
+		},
 		EnableShellCompletion: true,
 		Commands: []*cli.Command{
 			{
 				Name:        "login",
 				Usage:       "Login to NextCloud",
-				Description: "",
+				Description: "Without --username and --password, login in a browser and save an app password (Login Flow v2)",
 				ArgsUsage:   "URL",
 				Flags: []cli.Flag{
 					&cli.StringFlag{
@@ -66,73 +223,239 @@ func main() {
 						Aliases: []string{"p"},
 						Usage:   "set nextcloud password",
 					},
+					&cli.StringFlag{
+						Name:  "profile",
+						Usage: "save the credentials as `PROFILE`",
+					},
+					&cli.StringFlag{
+						Name:  "oauth",
+						Usage: "login with OAuth2 using the client `CLIENT_ID` registered in Nextcloud",
+					},
+					&cli.StringFlag{
+						Name:    "oauth-secret",
+						Usage:   "set the OAuth2 client secret",
+						EnvVars: []string{"NEXTCLOUD_OAUTH_SECRET"},
+					},
+					&cli.StringFlag{
+						Name:  "oauth-redirect-url",
+						Usage: "set the OAuth2 redirection URL registered for the client (must be localhost)",
+						Value: "http://localhost:8765/",
+					},
+					&cli.BoolFlag{
+						Name:  "no-browser",
+						Usage: "print the login URL instead of opening a browser",
+						Value: false,
+					},
+					&cli.BoolFlag{
+						Name:  "passphrase",
+						Usage: "encrypt the saved password with a passphrase (read from NEXTCLOUD_PASSPHRASE or prompted)",
+						Value: false,
+					},
+					&cli.StringFlag{
+						Name:    "credential-helper",
+						Usage:   "save the password with a git compatible credential `HELPER` instead of a file",
+						EnvVars: []string{"NEXTCLOUD_CREDENTIAL_HELPER"},
+					},
 				},
 				Action: func(ctx *cli.Context) error {
 					if ctx.Args().Len() <= 0 {
 						return cli.ShowSubcommandHelp(ctx)
 					}
 
-					u, err := url.Parse(ctx.Args().First())
+					profile := profile(ctx)
+					if err := credentials.ValidProfile(profile); err != nil {
+						return err
+					}
+
+					var credential *credentials.Credential
+					if ctx.IsSet("oauth") {
+						c, err := oauthLogin(ctx, ctx.Args().First())
+						if err != nil {
+							return err
+						}
+						credential = c
+					} else if ctx.IsSet("username") || ctx.IsSet("password") {
+						c, err := newCredential(ctx, ctx.Args().First(), "username", "password")
+						if err != nil {
+							return err
+						}
+						credential = c
+					} else {
+						c, err := loginFlow(ctx, ctx.Args().First())
+						if err != nil {
+							return err
+						}
+						credential = c
+					}
+
+					connection, err := loginConnection(ctx)
 					if err != nil {
-						return errors.New("invalid argument : url\n" + err.Error())
+						return err
 					}
+					credential.Connection = connection
 
-					if !strings.HasSuffix(strings.TrimSuffix(u.Path, "/"), "remote.php/webdav") {
-						u.Path = path.Join(u.Path, "/remote.php/webdav/")
+					nextcloud, err := newNextcloud(ctx, credential, nil)
+					if err != nil {
+						return err
 					}
 
-					username := ctx.String("username")
-					if !ctx.IsSet("username") {
-						if !terminal.IsTerminal(int(os.Stdin.Fd())) {
-							return errors.New("stdin is not a terminal")
-						}
+					if _, err := nextcloud.Stat("/"); err != nil {
+						return fmt.Errorf("failed to login NextCloud: %s: %w", credential.URL, err)
+					}
 
-						fmt.Print("Enter username: ")
-						if _, err := fmt.Fscanln(os.Stdin, &username); err != nil {
+					opts := []credentials.Option{}
+					if ctx.IsSet("credential-helper") {
+						opts = append(opts, credentials.Helper(ctx.String("credential-helper")))
+					} else if ctx.Bool("passphrase") {
+						passphrase, err := newPassphrase()
+						if err != nil {
 							return err
 						}
+						opts = append(opts, credentials.Passphrase(passphrase))
 					}
 
-					password := ctx.String("password")
-					if !ctx.IsSet("password") {
-						if !terminal.IsTerminal(int(os.Stdin.Fd())) {
-							return errors.New("stdin is not a terminal")
-						}
-
-						fmt.Print("Enter password: ")
-						bytes, err := terminal.ReadPassword(int(os.Stdin.Fd()))
-						fmt.Println()
+					return credentials.SaveProfile(appname, profile, credential, opts...)
+				},
+			},
+			{
+				Name:        "logout",
+				Usage:       "Logout from NextCloud",
+				Description: "",
+				ArgsUsage:   " ",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "profile",
+						Usage: "remove the credentials saved as `PROFILE`",
+					},
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "remove all profiles and cached data",
+						Value: false,
+					},
+					&cli.BoolFlag{
+						Name:  "revoke",
+						Usage: "also delete the app password on the server",
+						Value: false,
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					profiles := []string{profile(ctx)}
+					if ctx.Bool("all") {
+						p, err := credentials.Profiles(appname)
 						if err != nil {
 							return err
 						}
-
-						password = string(bytes)
+						profiles = p
 					}
 
-					credential := credentials.Credential{
-						URL:      u.String(),
-						Username: username,
-						Password: credentials.Password(password),
+					if ctx.Bool("revoke") {
+						for _, profile := range profiles {
+							credential, _, err := loadCredential(ctx, profile)
+							if err != nil {
+								return err
+							}
+
+							if credential.OAuth2 != nil {
+								return errors.New("cannot revoke oauth2 login: remove the access of the client in Nextcloud settings")
+							}
+
+							client, err := httpClient(ctx, credential.Connection)
+							if err != nil {
+								return err
+							}
+
+							if err := loginflow.Revoke(interruptContext(), client, credential.URL, credential.Username, credential.Password.String(), userAgent()); err != nil {
+								return err
+							}
+						}
 					}
 
-					auth := webdav.BasicAuth(credential.Username, credential.Password.String(), appname, version)
-					nextcloud := nextcloud.New(credential.URL, httpClient(), auth)
+					if ctx.Bool("all") {
+						return credentials.Clean(appname)
+					}
 
-					if _, err := nextcloud.Stat("/"); err != nil {
-						return errors.New("failed to login NextCloud: " + credential.URL)
+					if err := credentials.RemoveProfile(appname, profiles[0]); err != nil && !errors.Is(err, os.ErrNotExist) {
+						return err
 					}
 
-					return credentials.Save(appname, &credential)
+					return nil
 				},
 			},
 			{
-				Name:        "logout",
-				Usage:       "Logout from NextCloud",
+				Name:        "profiles",
+				Usage:       "Manage saved login profiles",
 				Description: "",
 				ArgsUsage:   " ",
-				Flags:       []cli.Flag{},
-				Action: func(ctx *cli.Context) error {
-					return credentials.Clean(appname)
+				Subcommands: []*cli.Command{
+					{
+						Name:        "list",
+						Aliases:     []string{"ls"},
+						Usage:       "List saved profiles",
+						Description: "The selected profile is marked with *",
+						ArgsUsage:   " ",
+						Flags:       []cli.Flag{},
+						Action: func(ctx *cli.Context) error {
+							profiles, err := credentials.Profiles(appname)
+							if err != nil {
+								return err
+							}
+
+							current := profile(ctx)
+							for _, profile := range profiles {
+								credential, err := credentials.LoadProfile(appname, profile, credentials.SkipPassword())
+								if err != nil {
+									return err
+								}
+
+								mark := " "
+								if profile == current {
+									mark = "*"
+								}
+
+								fmt.Println(mark, profile, credential.Username, credential.URL)
+							}
+
+							return nil
+						},
+					},
+					{
+						Name:        "use",
+						Usage:       "Select the profile used when --profile is not specified",
+						Description: "",
+						ArgsUsage:   "PROFILE",
+						Flags:       []cli.Flag{},
+						Action: func(ctx *cli.Context) error {
+							if ctx.Args().Len() != 1 {
+								return cli.ShowSubcommandHelp(ctx)
+							}
+
+							return credentials.UseProfile(appname, ctx.Args().First())
+						},
+					},
+					{
+						Name:        "remove",
+						Aliases:     []string{"rm"},
+						Usage:       "Remove saved profiles",
+						Description: "",
+						ArgsUsage:   "PROFILE [PROFILE...]",
+						Flags:       []cli.Flag{},
+						Action: func(ctx *cli.Context) error {
+							if ctx.Args().Len() < 1 {
+								return cli.ShowSubcommandHelp(ctx)
+							}
+
+							for _, profile := range ctx.Args().Slice() {
+								if err := credentials.RemoveProfile(appname, profile); err != nil {
+									if errors.Is(err, os.ErrNotExist) {
+										return errors.New("profile not found: " + profile)
+									}
+									return err
+								}
+							}
+
+							return nil
+						},
+					},
 				},
 			},
 			{
@@ -150,20 +473,22 @@ func main() {
 					},
 				},
 				Action: func(ctx *cli.Context) error {
-					credential, err := credentials.Load(appname)
-					if err != nil {
-						credentials.Clean(appname)
-						return errors.New("you need to login")
-					}
-
-					auth := webdav.BasicAuth(credential.Username, credential.Password.String(), appname, version)
-					nextcloud := nextcloud.New(credential.URL, httpClient(), auth)
-
 					args := ctx.Args().Slice()
 					if len(args) <= 0 {
 						args = []string{"/"}
 					}
 
+					remote, args, err := remotePaths(ctx, args)
+					if err != nil {
+						return err
+					}
+
+					nextcloud, credential, err := connect(ctx, remote)
+					if err != nil {
+						return err
+					}
+					useCache(ctx, nextcloud, credential)
+
 					return list.Do(nextcloud, ctx.Bool("long"), args...)
 				},
 			},
@@ -198,15 +523,6 @@ Actions
 					},
 				},
 				Action: func(ctx *cli.Context) error {
-					credential, err := credentials.Load(appname)
-					if err != nil {
-						credentials.Clean(appname)
-						return errors.New("you need to login")
-					}
-
-					auth := webdav.BasicAuth(credential.Username, credential.Password.String(), appname, version)
-					nextcloud := nextcloud.New(credential.URL, httpClient(), auth)
-
 					args := ctx.Args().Slice()
 					if len(args) <= 0 {
 						args = []string{"/"}
@@ -220,7 +536,19 @@ Actions
 						}
 					}
 
+					remote, files, err := remotePaths(ctx, files)
+					if err != nil {
+						return err
+					}
+
+					nextcloud, credential, err := connect(ctx, remote)
+					if err != nil {
+						return err
+					}
+					useCache(ctx, nextcloud, credential)
+
 					opts := []find.Option{
+						find.Context(interruptContext()),
 						find.MaxDepth(ctx.Int("maxdepth")),
 						find.MinDepth(ctx.Int("mindepth")),
 					}
@@ -228,15 +556,28 @@ Actions
 				},
 			},
 			{
-				Name:        "open",
-				Usage:       "Open remote files or directories in your webbrowser",
-				Description: "",
-				ArgsUsage:   "[Dir...]",
+				Name:        "du",
+				Usage:       "Estimate remote directory space usage",
+				Description: "The sizes are calculated by NextCloud, so the files are not walked one by one",
+				ArgsUsage:   "[FILE...]",
 				Flags: []cli.Flag{
-					&cli.StringFlag{
-						Name:        "application",
-						Usage:       "set webbrowser application",
-						DefaultText: "default webbrowser",
+					&cli.BoolFlag{
+						Name:    "summarize",
+						Aliases: []string{"s"},
+						Usage:   "display only a total for each argument",
+						Value:   false,
+					},
+					&cli.BoolFlag{
+						Name:    "human-readable",
+						Aliases: []string{}, // -h は humanReadableArgs で置き換える
+						Usage:   "print sizes in human readable format (e.g., 1K 234M 2G), or -h",
+						Value:   false,
+					},
+					&cli.IntFlag{
+						Name:    "max-depth",
+						Aliases: []string{"d"},
+						Usage:   "print the total for a directory only if it is N or fewer levels below the argument",
+						Value:   -1,
 					},
 				},
 				Action: func(ctx *cli.Context) error {
@@ -245,310 +586,2171 @@ Actions
 						args = []string{"/"}
 					}
 
-					credential, err := credentials.Load(appname)
+					remote, args, err := remotePaths(ctx, args)
 					if err != nil {
-						credentials.Clean(appname)
-						return errors.New("you need to login")
+						return err
 					}
 
-					auth := webdav.BasicAuth(credential.Username, credential.Password.String(), appname, version)
-					nextcloud := nextcloud.New(credential.URL, httpClient(), auth)
+					nextcloud, credential, err := connect(ctx, remote)
+					if err != nil {
+						return err
+					}
+					useCache(ctx, nextcloud, credential)
 
-					opts := []open.Option{
-						open.AppName(ctx.String("application")),
+					opts := []du.Option{
+						du.Summarize(ctx.Bool("summarize")),
+						du.HumanReadable(ctx.Bool("human-readable")),
+						du.MaxDepth(ctx.Int("max-depth")),
 					}
-					return open.Do(nextcloud, opts, args)
+					return du.Do(nextcloud, opts, args)
 				},
 			},
 			{
-				Name:        "download",
-				Usage:       "Download remote files or directories",
+				Name:        "quota",
+				Aliases:     []string{"df"},
+				Usage:       "Show used/free/total space of the account and external storages",
 				Description: "",
-				ArgsUsage: `SOURCE [SOURCE...]
-	When you specify a directory, it downloads the files and directories within the directory but not the directory itself.`,
+				ArgsUsage:   " ",
 				Flags: []cli.Flag{
-					&cli.StringFlag{
-						Name:    "out",
-						Aliases: []string{"o"},
-						Usage:   "set output directory",
-						Value:   ".",
-					},
-					&cli.IntFlag{
-						Name:    "retry",
-						Aliases: []string{},
-						Usage:   "set max retry count",
-						Value:   5,
-					},
-					&cli.StringFlag{
-						Name:    "deconflict",
-						Aliases: []string{},
-						Usage:   "set deconflict strategy (skip/overwrite/newest/larger/error)",
-						Value:   "error",
-					},
-					&cli.IntFlag{
-						Name:    "procs",
-						Aliases: []string{},
-						Usage:   "set maximum number of processes",
-						Value:   defaultProcs,
+					&cli.BoolFlag{
+						Name:    "human-readable",
+						Aliases: []string{}, // -h は humanReadableArgs で置き換える
+						Usage:   "print sizes in human readable format (e.g., 1K 234M 2G), or -h",
+						Value:   false,
 					},
 					&cli.BoolFlag{
-						Name:    "join",
+						Name:    "json",
 						Aliases: []string{},
-						Usage:   "set true for automatic join",
+						Usage:   "output in JSON format",
 						Value:   false,
 					},
 				},
 				Action: func(ctx *cli.Context) error {
-					if ctx.Args().Len() < 1 {
-						return cli.ShowSubcommandHelp(ctx)
+					// パスを取らないので、プロファイル名: ではアカウントを指定できない
+					if ctx.Args().Len() > 0 {
+						return errors.New("quota takes no arguments, use --profile to choose the account: " + ctx.Args().First())
 					}
 
-					credential, err := credentials.Load(appname)
+					nextcloud, _, err := connect(ctx, profileFlag(ctx))
 					if err != nil {
-						credentials.Clean(appname)
-						return errors.New("you need to login")
+						return err
 					}
 
-					auth := webdav.BasicAuth(credential.Username, credential.Password.String(), appname, version)
-					nextcloud := nextcloud.New(credential.URL, httpClient(), auth)
-
-					opts := []download.Option{
-						download.Retry(ctx.Int("retry"), 30*time.Second),
-						download.DeconflictStrategy(ctx.String("deconflict")),
-						download.Procs(ctx.Int("procs")),
-						download.Join(ctx.Bool("join")),
+					opts := []quota.Option{
+						quota.HumanReadable(ctx.Bool("human-readable")),
+						quota.JSON(ctx.Bool("json")),
 					}
-					return download.Do(nextcloud, opts, ctx.Args().Slice(), ctx.String("out"))
+					return quota.Do(nextcloud, opts)
 				},
 			},
 			{
-				Name:        "get",
-				Usage:       "Download a single remote file or directory",
-				Description: "The directory is downloaded as tar",
-				ArgsUsage: "REMOTE_PATH LOCAL_PATH" + "\n\n" +
-					"	 LOCAL_PATH : Specify a path for destination/fileName",
+				Name:        "open",
+				Usage:       "Open remote files or directories in your webbrowser",
+				Description: "",
+				ArgsUsage:   "[Dir...]",
 				Flags: []cli.Flag{
-					&cli.IntFlag{
-						Name:    "retry",
-						Aliases: []string{},
-						Usage:   "set max retry count",
-						Value:   5,
-					},
 					&cli.StringFlag{
-						Name:    "deconflict",
-						Aliases: []string{},
-						Usage:   "set deconflict strategy (error/overwrite)",
-						Value:   "error",
-					},
-					&cli.BoolFlag{
-						Name:    "join",
-						Aliases: []string{},
-						Usage:   "set true for automatic join",
-						Value:   false,
+						Name:        "application",
+						Usage:       "set webbrowser application",
+						DefaultText: "default webbrowser",
 					},
 				},
 				Action: func(ctx *cli.Context) error {
-					if ctx.Args().Len() != 2 {
-						fmt.Println("only two arguments are allowed")
-						return cli.ShowSubcommandHelp(ctx)
+					args := ctx.Args().Slice()
+					if len(args) <= 0 {
+						args = []string{"/"}
 					}
 
-					credential, err := credentials.Load(appname)
+					remote, args, err := remotePaths(ctx, args)
 					if err != nil {
-						credentials.Clean(appname)
-						return errors.New("you need to login")
+						return err
 					}
 
-					auth := webdav.BasicAuth(credential.Username, credential.Password.String(), appname, version)
-					nextcloud := nextcloud.New(credential.URL, httpClient(), auth)
-
-					opts := []get.Option{
-						get.Retry(ctx.Int("retry"), 30*time.Second),
-						get.DeconflictStrategy(ctx.String("deconflict")),
-						get.Join(ctx.Bool("join")),
+					nextcloud, _, err := connect(ctx, remote)
+					if err != nil {
+						return err
 					}
 
-					return get.Do(nextcloud, opts, ctx.Args().Get(0), path.Dir(ctx.Args().Get(1)), path.Base(ctx.Args().Get(1)))
+					opts := []open.Option{
+						open.AppName(ctx.String("application")),
+					}
+					return open.Do(nextcloud, opts, args)
 				},
 			},
 			{
-				Name:        "upload",
-				Usage:       "Upload local files or directories",
-				Description: "",
+				Name:        "cat",
+				Usage:       "Print remote files to standard output",
+				Description: "Split files (FILE.000, FILE.001, ...) are joined automatically",
 				ArgsUsage:   "FILE [FILE...]",
-				Flags: []cli.Flag{
-					&cli.StringFlag{
-						Name:    "out",
-						Aliases: []string{"o"},
-						Usage:   "set output directory",
-						Value:   "/",
-					},
-					&cli.IntFlag{
-						Name:    "retry",
-						Aliases: []string{},
-						Usage:   "set max retry count",
-						Value:   5,
-					},
-					&cli.StringFlag{
-						Name:    "deconflict",
-						Aliases: []string{},
-						Usage:   "set deconflict strategy (skip/overwrite/newest/larger/error)",
-						Value:   "error",
-					},
-					&cli.IntFlag{
-						Name:    "procs",
-						Aliases: []string{},
-						Usage:   "set maximum number of processes",
-						Value:   defaultProcs,
-					},
-					&cli.StringFlag{
-						Name:    "split-size",
-						Aliases: []string{"s"},
-						Usage:   "set splitting threshold",
-						Value:   "",
-					},
-				},
+				Flags:       []cli.Flag{},
 				Action: func(ctx *cli.Context) error {
 					if ctx.Args().Len() < 1 {
 						return cli.ShowSubcommandHelp(ctx)
 					}
 
-					credential, err := credentials.Load(appname)
+					remote, files, err := remotePaths(ctx, ctx.Args().Slice())
 					if err != nil {
-						credentials.Clean(appname)
-						return errors.New("you need to login")
+						return err
 					}
 
-					auth := webdav.BasicAuth(credential.Username, credential.Password.String(), appname, version)
-					nextcloud := nextcloud.New(credential.URL, httpClient(), auth)
-
-					opts := []upload.Option{
-						upload.Retry(ctx.Int("retry"), 30*time.Second),
-						upload.DeconflictStrategy(ctx.String("deconflict")),
-						upload.Procs(ctx.Int("procs")),
-						upload.SplitSize(ctx.String("split-size")),
+					nextcloud, _, err := connect(ctx, remote)
+					if err != nil {
+						return err
 					}
-					return upload.Do(nextcloud, opts, ctx.Args().Slice(), ctx.String("out"))
+
+					return cat.Do(nextcloud, []cat.Option{}, files)
 				},
 			},
 			{
-				Name:        "rm",
-				Usage:       "Remove remote files or directories",
-				Description: "",
+				Name:        "head",
+				Usage:       "Print the first part of remote files",
+				Description: "Only the needed bytes are fetched",
 				ArgsUsage:   "FILE [FILE...]",
 				Flags: []cli.Flag{
-					&cli.IntFlag{
-						Name:    "retry",
-						Aliases: []string{},
-						Usage:   "set max retry count",
-						Value:   5,
+					&cli.Int64Flag{
+						Name:    "lines",
+						Aliases: []string{"n"},
+						Usage:   "print the first N lines",
+						Value:   10,
+					},
+					&cli.Int64Flag{
+						Name:    "bytes",
+						Aliases: []string{"c"},
+						Usage:   "print the first N bytes",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if ctx.Args().Len() < 1 {
+						return cli.ShowSubcommandHelp(ctx)
+					}
+
+					remote, files, err := remotePaths(ctx, ctx.Args().Slice())
+					if err != nil {
+						return err
+					}
+
+					nextcloud, _, err := connect(ctx, remote)
+					if err != nil {
+						return err
+					}
+
+					opts := []head.Option{
+						head.Lines(ctx.Int64("lines")),
+					}
+					if ctx.IsSet("bytes") {
+						opts = append(opts, head.Bytes(ctx.Int64("bytes")))
+					}
+					return head.Do(nextcloud, opts, files)
+				},
+			},
+			{
+				Name:        "tail",
+				Usage:       "Print the last part of remote files",
+				Description: "Only the needed bytes are fetched",
+				ArgsUsage:   "FILE [FILE...]",
+				Flags: []cli.Flag{
+					&cli.Int64Flag{
+						Name:    "lines",
+						Aliases: []string{"n"},
+						Usage:   "print the last N lines",
+						Value:   10,
+					},
+					&cli.Int64Flag{
+						Name:    "bytes",
+						Aliases: []string{"c"},
+						Usage:   "print the last N bytes",
 					},
 					&cli.BoolFlag{
-						Name:    "force",
+						Name:    "follow",
 						Aliases: []string{"f"},
-						Usage:   "never prompt",
+						Usage:   "output appended data as the file grows",
 						Value:   false,
 					},
+					&cli.DurationFlag{
+						Name:    "sleep-interval",
+						Aliases: []string{"s"},
+						Usage:   "with --follow, check the file for changes at this interval",
+						Value:   1 * time.Second,
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if ctx.Args().Len() < 1 {
+						return cli.ShowSubcommandHelp(ctx)
+					}
+
+					remote, files, err := remotePaths(ctx, ctx.Args().Slice())
+					if err != nil {
+						return err
+					}
+
+					nextcloud, _, err := connect(ctx, remote)
+					if err != nil {
+						return err
+					}
+
+					opts := []tail.Option{
+						tail.Lines(ctx.Int64("lines")),
+						tail.Follow(ctx.Bool("follow")),
+						tail.SleepInterval(ctx.Duration("sleep-interval")),
+					}
+					if ctx.IsSet("bytes") {
+						opts = append(opts, tail.Bytes(ctx.Int64("bytes")))
+					}
+					return tail.Do(nextcloud, opts, files)
+				},
+			},
+			{
+				Name:        "edit",
+				Usage:       "Edit a remote file with $VISUAL or $EDITOR",
+				Description: "The file is uploaded only if it was changed and was not modified on the server while editing",
+				ArgsUsage:   "FILE",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "editor",
+						Aliases:     []string{},
+						Usage:       "set editor command",
+						DefaultText: "$VISUAL or $EDITOR",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if ctx.Args().Len() != 1 {
+						return cli.ShowSubcommandHelp(ctx)
+					}
+
+					remote, file := splitRemote(ctx, ctx.Args().First())
+					nextcloud, _, err := connect(ctx, remote)
+					if err != nil {
+						return err
+					}
+
+					opts := []edit.Option{}
+					if ctx.IsSet("editor") {
+						opts = append(opts, edit.Editor(ctx.String("editor")))
+					}
+					return edit.Do(nextcloud, opts, file)
+				},
+			},
+			{
+				Name:        "download",
+				Usage:       "Download remote files or directories",
+				Description: "",
+				ArgsUsage: `SOURCE [SOURCE...]
+	When you specify a directory, it downloads the files and directories within the directory but not the directory itself.`,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "out",
+						Aliases: []string{"o"},
+						Usage:   "set output directory",
+						Value:   ".",
+					},
+					&cli.IntFlag{
+						Name:        "retry",
+						Aliases:     []string{},
+						Usage:       "set max retry count",
+						DefaultText: "the global --retry",
+					},
+					&cli.StringFlag{
+						Name:    "bwlimit",
+						Aliases: []string{},
+						Usage:   "limit the total bandwidth, e.g. 10M (bytes/s) or a timetable \"08:00,2M 19:00,off\"",
+						Value:   "",
+					},
+					&cli.StringFlag{
+						Name:    "progress",
+						Aliases: []string{},
+						Usage:   "set progress output (auto/json), json prints newline-delimited JSON events to stdout",
+						Value:   "auto",
+					},
+					&cli.StringFlag{
+						Name:    "deconflict",
+						Aliases: []string{},
+						Usage:   "set deconflict strategy (skip/overwrite/newest/larger/error)",
+						Value:   "error",
+					},
+					&cli.IntFlag{
+						Name:    "procs",
+						Aliases: []string{},
+						Usage:   "set maximum number of processes",
+						Value:   defaultProcs,
+					},
 					&cli.BoolFlag{
-						Name:    "recursive",
-						Aliases: []string{"r"},
-						Usage:   "remove directories and their contents recursively",
+						Name:    "join",
+						Aliases: []string{},
+						Usage:   "set true for automatic join",
 						Value:   false,
 					},
 					&cli.BoolFlag{
-						Name:    "verbose",
-						Aliases: []string{"v"},
-						Usage:   "explain what is being done",
+						Name:    "keep-going",
+						Aliases: []string{"k"},
+						Usage:   "keep copying the other files when some fail, and exit with an error at the end",
 						Value:   false,
 					},
+					&cli.StringFlag{
+						Name:    "failures",
+						Aliases: []string{},
+						Usage:   "write the files that failed to `FILE`, which can be passed to --files-from to retry only them",
+						Value:   "",
+					},
+					&cli.StringFlag{
+						Name:    "files-from",
+						Aliases: []string{},
+						Usage:   "read the files to copy from `FILE` (\"SOURCE\" or \"SOURCE<TAB>DESTINATION\" per line, - for stdin)",
+						Value:   "",
+					},
 				},
 				Action: func(ctx *cli.Context) error {
-					if ctx.Args().Len() < 1 {
+					if ctx.Args().Len() < 1 && ctx.String("files-from") == "" {
 						return cli.ShowSubcommandHelp(ctx)
 					}
 
-					credential, err := credentials.Load(appname)
+					remote, entries, err := remoteEntries(ctx)
+					if err != nil {
+						return err
+					}
+
+					events, err := progressEvents(ctx)
 					if err != nil {
-						credentials.Clean(appname)
-						return errors.New("you need to login")
+						return err
 					}
 
-					auth := webdav.BasicAuth(credential.Username, credential.Password.String(), appname, version)
-					nextcloud := nextcloud.New(credential.URL, httpClient(), auth)
+					nextcloud, credential, err := connect(ctx, remote)
+					if err != nil {
+						return err
+					}
+					useCache(ctx, nextcloud, credential)
 
-					opts := []rm.Option{
-						rm.Retry(ctx.Int("retry"), 3*time.Second),
-						rm.Recursive(ctx.Bool("recursive")),
-						rm.Force(ctx.Bool("force")),
-						rm.Verbose(ctx.Bool("verbose")),
+					opts := []transfer.Option{
+						transfer.Context(interruptContext()),
+						transfer.Retry(retryCount(ctx)),
+						transfer.BWLimit(ctx.String("bwlimit")),
+						transfer.DeconflictStrategy(ctx.String("deconflict")),
+						transfer.Procs(ctx.Int("procs")),
+						transfer.Join(ctx.Bool("join")),
+						transfer.Events(events),
+						transfer.KeepGoing(ctx.Bool("keep-going")),
+						transfer.FailureList(ctx.String("failures")),
+						transfer.SourceName(remoteName(remote)),
 					}
-					return rm.Do(nextcloud, opts, ctx.Args().Slice())
+					return download.Do(nextcloud, opts, entries, ctx.String("out"))
 				},
 			},
 			{
-				Name:        "credits",
-				Usage:       "Show CREDITS",
-				Description: "",
-				ArgsUsage:   " ",
-				Flags:       []cli.Flag{},
+				Name:        "get",
+				Usage:       "Download a single remote file or directory",
+				Description: "The directory is downloaded as tar",
+				ArgsUsage: "REMOTE_PATH LOCAL_PATH" + "\n\n" +
+					"	 LOCAL_PATH : Specify a path for destination/fileName",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:        "retry",
+						Aliases:     []string{},
+						Usage:       "set max retry count",
+						DefaultText: "the global --retry",
+					},
+					&cli.StringFlag{
+						Name:    "bwlimit",
+						Aliases: []string{},
+						Usage:   "limit the total bandwidth, e.g. 10M (bytes/s) or a timetable \"08:00,2M 19:00,off\"",
+						Value:   "",
+					},
+					&cli.StringFlag{
+						Name:    "progress",
+						Aliases: []string{},
+						Usage:   "set progress output (auto/json), json prints newline-delimited JSON events to stdout",
+						Value:   "auto",
+					},
+					&cli.StringFlag{
+						Name:    "deconflict",
+						Aliases: []string{},
+						Usage:   "set deconflict strategy (error/overwrite)",
+						Value:   "error",
+					},
+					&cli.BoolFlag{
+						Name:    "join",
+						Aliases: []string{},
+						Usage:   "set true for automatic join",
+						Value:   false,
+					},
+				},
 				Action: func(ctx *cli.Context) error {
-					return credits.Do()
+					if ctx.Args().Len() != 2 {
+						fmt.Println("only two arguments are allowed")
+						return cli.ShowSubcommandHelp(ctx)
+					}
+
+					remote, src := splitRemote(ctx, ctx.Args().Get(0))
+
+					events, err := progressEvents(ctx)
+					if err != nil {
+						return err
+					}
+
+					nextcloud, credential, err := connect(ctx, remote)
+					if err != nil {
+						return err
+					}
+					useCache(ctx, nextcloud, credential)
+
+					opts := []get.Option{
+						get.Context(interruptContext()),
+						get.Retry(retryCount(ctx)),
+						get.BWLimit(ctx.String("bwlimit")),
+						get.DeconflictStrategy(ctx.String("deconflict")),
+						get.Join(ctx.Bool("join")),
+						get.Events(events),
+					}
+
+					return get.Do(nextcloud, opts, src, path.Dir(ctx.Args().Get(1)), path.Base(ctx.Args().Get(1)))
 				},
 			},
-		},
-	}
+			{
+				Name:        "upload",
+				Usage:       "Upload local files or directories",
+				Description: "",
+				ArgsUsage:   "FILE [FILE...]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "out",
+						Aliases: []string{"o"},
+						Usage:   "set output directory",
+						Value:   "/",
+					},
+					&cli.IntFlag{
+						Name:        "retry",
+						Aliases:     []string{},
+						Usage:       "set max retry count",
+						DefaultText: "the global --retry",
+					},
+					&cli.StringFlag{
+						Name:    "bwlimit",
+						Aliases: []string{},
+						Usage:   "limit the total bandwidth, e.g. 10M (bytes/s) or a timetable \"08:00,2M 19:00,off\"",
+						Value:   "",
+					},
+					&cli.StringFlag{
+						Name:    "progress",
+						Aliases: []string{},
+						Usage:   "set progress output (auto/json), json prints newline-delimited JSON events to stdout",
+						Value:   "auto",
+					},
+					&cli.StringFlag{
+						Name:    "deconflict",
+						Aliases: []string{},
+						Usage:   "set deconflict strategy (skip/overwrite/newest/larger/error)",
+						Value:   "error",
+					},
+					&cli.IntFlag{
+						Name:    "procs",
+						Aliases: []string{},
+						Usage:   "set maximum number of processes",
+						Value:   defaultProcs,
+					},
+					&cli.StringFlag{
+						Name:    "split-size",
+						Aliases: []string{"s"},
+						Usage:   "set splitting threshold",
+						Value:   "",
+					},
+					&cli.BoolFlag{
+						Name:    "keep-going",
+						Aliases: []string{"k"},
+						Usage:   "keep copying the other files when some fail, and exit with an error at the end",
+						Value:   false,
+					},
+					&cli.StringFlag{
+						Name:    "failures",
+						Aliases: []string{},
+						Usage:   "write the files that failed to `FILE`, which can be passed to --files-from to retry only them",
+						Value:   "",
+					},
+					&cli.StringFlag{
+						Name:    "files-from",
+						Aliases: []string{},
+						Usage:   "read the files to copy from `FILE` (\"SOURCE\" or \"SOURCE<TAB>DESTINATION\" per line, - for stdin)",
+						Value:   "",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if ctx.Args().Len() < 1 && ctx.String("files-from") == "" {
+						return cli.ShowSubcommandHelp(ctx)
+					}
 
-	if err := app.Run(os.Args); err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
-		os.Exit(1)
-	}
-}
+					entries, err := filesFrom(ctx)
+					if err != nil {
+						return err
+					}
 
-func httpClient() *http.Client {
-	dialer := &net.Dialer{
-		Timeout:   30 * time.Second,
-		KeepAlive: 30 * time.Second,
-		DualStack: true,
-	}
-	cache := sync.Map{}
-	group := singleflight.Group{}
+					remote, dst := splitRemote(ctx, ctx.String("out"))
 
-	return &http.Client{
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: func(ctx context.Context, network string, addr string) (conn net.Conn, err error) {
-				i := strings.LastIndex(addr, ":")
-				host := addr[:i]
-				port := addr[i:]
-
-				addrs, ok := cache.Load(host)
-				if !ok {
-					addrs, err, _ = group.Do(host, func() (interface{}, error) {
-						return net.DefaultResolver.LookupHost(ctx, host)
-					})
-					if err == context.DeadlineExceeded {
-						group.Forget(host)
-						return
-					}
-
-					cache.Store(host, addrs)
-				}
+					events, err := progressEvents(ctx)
+					if err != nil {
+						return err
+					}
 
-				for _, addr := range addrs.([]string) {
-					conn, err = dialer.Dial(network, addr+port)
-					if err == nil {
-						return
+					nextcloud, _, err := connect(ctx, remote)
+					if err != nil {
+						return err
 					}
-				}
 
-				return
+					opts := []transfer.Option{
+						transfer.Context(interruptContext()),
+						transfer.Retry(retryCount(ctx)),
+						transfer.BWLimit(ctx.String("bwlimit")),
+						transfer.DeconflictStrategy(ctx.String("deconflict")),
+						transfer.Procs(ctx.Int("procs")),
+						transfer.SplitSize(ctx.String("split-size")),
+						transfer.Events(events),
+						transfer.KeepGoing(ctx.Bool("keep-going")),
+						transfer.FailureList(ctx.String("failures")),
+					}
+					return upload.Do(nextcloud, opts, entries, dst)
+				},
 			},
-			MaxIdleConns:          100,
-			MaxIdleConnsPerHost:   50,
-			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-			DisableKeepAlives:     false,
-		},
-	}
+			{
+				Name:        "copy",
+				Aliases:     []string{"cp"},
+				Usage:       "Copy remote files or directories to another directory, account or server",
+				Description: "Prefix paths with PROFILE: (e.g. work:/Projects) to copy between saved profiles. With --to-url, files are copied to the given server",
+				ArgsUsage:   "FILE [FILE...]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "out",
+						Aliases: []string{"o"},
+						Usage:   "set output directory",
+						Value:   "/",
+					},
+					&cli.StringFlag{
+						Name:  "to-url",
+						Usage: "set destination nextcloud url",
+					},
+					&cli.StringFlag{
+						Name:  "to-username",
+						Usage: "set destination nextcloud username",
+					},
+					&cli.StringFlag{
+						Name:  "to-password",
+						Usage: "set destination nextcloud password",
+					},
+					&cli.IntFlag{
+						Name:        "retry",
+						Aliases:     []string{},
+						Usage:       "set max retry count",
+						DefaultText: "the global --retry",
+					},
+					&cli.StringFlag{
+						Name:    "deconflict",
+						Aliases: []string{},
+						Usage:   "set deconflict strategy (skip/overwrite/newest/larger/error)",
+						Value:   "error",
+					},
+					&cli.IntFlag{
+						Name:    "procs",
+						Aliases: []string{},
+						Usage:   "set maximum number of processes",
+						Value:   defaultProcs,
+					},
+					&cli.BoolFlag{
+						Name:    "join",
+						Aliases: []string{},
+						Usage:   "set true for automatic join",
+						Value:   false,
+					},
+					&cli.StringFlag{
+						Name:    "split-size",
+						Aliases: []string{"s"},
+						Usage:   "set splitting threshold",
+						Value:   "",
+					},
+					&cli.BoolFlag{
+						Name:    "keep-going",
+						Aliases: []string{"k"},
+						Usage:   "keep copying the other files when some fail, and exit with an error at the end",
+						Value:   false,
+					},
+					&cli.StringFlag{
+						Name:    "failures",
+						Aliases: []string{},
+						Usage:   "write the files that failed to `FILE`, which can be passed to --files-from to retry only them",
+						Value:   "",
+					},
+					&cli.StringFlag{
+						Name:    "files-from",
+						Aliases: []string{},
+						Usage:   "read the files to copy from `FILE` (\"SOURCE\" or \"SOURCE<TAB>DESTINATION\" per line, - for stdin)",
+						Value:   "",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if ctx.Args().Len() < 1 && ctx.String("files-from") == "" {
+						return cli.ShowSubcommandHelp(ctx)
+					}
+
+					srcRemote, entries, err := remoteEntries(ctx)
+					if err != nil {
+						return err
+					}
+
+					src, credential, err := connect(ctx, srcRemote)
+					if err != nil {
+						return err
+					}
+					useCache(ctx, src, credential)
+
+					dstRemote, out := splitRemote(ctx, ctx.String("out"))
+
+					dst := src
+					if ctx.IsSet("to-url") {
+						credential, err := newCredential(ctx, ctx.String("to-url"), "to-username", "to-password")
+						if err != nil {
+							return err
+						}
+
+						dst, err = newNextcloud(ctx, credential, nil)
+						if err != nil {
+							return err
+						}
+					} else if dstRemote != srcRemote {
+						dst, _, err = connect(ctx, dstRemote)
+						if err != nil {
+							return err
+						}
+					}
+
+					opts := []transfer.Option{
+						transfer.Context(interruptContext()),
+						transfer.Retry(retryCount(ctx)),
+						transfer.DeconflictStrategy(ctx.String("deconflict")),
+						transfer.Procs(ctx.Int("procs")),
+						transfer.Join(ctx.Bool("join")),
+						transfer.SplitSize(ctx.String("split-size")),
+						transfer.KeepGoing(ctx.Bool("keep-going")),
+						transfer.FailureList(ctx.String("failures")),
+						transfer.SourceName(remoteName(srcRemote)),
+					}
+					return cp.Do(src, dst, opts, entries, out)
+				},
+			},
+			{
+				Name:        "put",
+				Usage:       "Upload standard input or a local file to an exact remote path",
+				Description: "Large or unknown-length input is sent with chunked upload",
+				ArgsUsage:   "[- | LOCAL_PATH] REMOTE_PATH",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:        "retry",
+						Aliases:     []string{},
+						Usage:       "set max retry count",
+						DefaultText: "the global --retry",
+					},
+					&cli.StringFlag{
+						Name:    "deconflict",
+						Aliases: []string{},
+						Usage:   "set deconflict strategy (skip/overwrite/newest/larger/error)",
+						Value:   "error",
+					},
+					&cli.StringFlag{
+						Name:    "chunk-size",
+						Aliases: []string{},
+						Usage:   "set chunk size for chunked upload",
+						Value:   "10MB",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					var src, dst string
+					switch ctx.Args().Len() {
+					case 1:
+						src, dst = put.Stdin, ctx.Args().Get(0)
+					case 2:
+						src, dst = ctx.Args().Get(0), ctx.Args().Get(1)
+					default:
+						return cli.ShowSubcommandHelp(ctx)
+					}
+
+					remote, dst := splitRemote(ctx, dst)
+					nextcloud, _, err := connect(ctx, remote)
+					if err != nil {
+						return err
+					}
+
+					opts := []put.Option{
+						put.DeconflictStrategy(ctx.String("deconflict")),
+						put.ChunkSize(ctx.String("chunk-size")),
+					}
+					return put.Do(nextcloud, opts, src, dst)
+				},
+			},
+			{
+				Name:        "mkdir",
+				Usage:       "Make remote directories",
+				Description: "",
+				ArgsUsage:   "DIR [DIR...]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "parents",
+						Aliases: []string{"p"},
+						Usage:   "no error if existing, make parent directories as needed",
+						Value:   false,
+					},
+					&cli.BoolFlag{
+						Name:    "verbose",
+						Aliases: []string{"v"},
+						Usage:   "print a message for each created directory",
+						Value:   false,
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if ctx.Args().Len() < 1 {
+						return cli.ShowSubcommandHelp(ctx)
+					}
+
+					remote, files, err := remotePaths(ctx, ctx.Args().Slice())
+					if err != nil {
+						return err
+					}
+
+					nextcloud, _, err := connect(ctx, remote)
+					if err != nil {
+						return err
+					}
+
+					opts := []mkdir.Option{
+						mkdir.Parents(ctx.Bool("parents")),
+						mkdir.Verbose(ctx.Bool("verbose")),
+					}
+					return mkdir.Do(nextcloud, opts, files)
+				},
+			},
+			{
+				Name:        "touch",
+				Usage:       "Change remote file timestamps, creating empty files if they do not exist",
+				Description: "",
+				ArgsUsage:   "FILE [FILE...]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "date",
+						Aliases:     []string{"d"},
+						Usage:       "use DATE (YYYY-MM-dd[ HH:mm[:ss]], RFC3339 or @UNIXTIME) instead of current time",
+						DefaultText: "current time",
+					},
+					&cli.BoolFlag{
+						Name:    "no-create",
+						Aliases: []string{"c"},
+						Usage:   "do not create any files",
+						Value:   false,
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if ctx.Args().Len() < 1 {
+						return cli.ShowSubcommandHelp(ctx)
+					}
+
+					remote, files, err := remotePaths(ctx, ctx.Args().Slice())
+					if err != nil {
+						return err
+					}
+
+					nextcloud, _, err := connect(ctx, remote)
+					if err != nil {
+						return err
+					}
+
+					opts := []touch.Option{
+						touch.NoCreate(ctx.Bool("no-create")),
+					}
+					if ctx.IsSet("date") {
+						opts = append(opts, touch.Date(ctx.String("date")))
+					}
+					return touch.Do(nextcloud, opts, files)
+				},
+			},
+			{
+				Name:        "rm",
+				Usage:       "Remove remote files or directories",
+				Description: "",
+				ArgsUsage:   "FILE [FILE...]",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:        "retry",
+						Aliases:     []string{},
+						Usage:       "set max retry count",
+						DefaultText: "the global --retry",
+					},
+					&cli.BoolFlag{
+						Name:    "force",
+						Aliases: []string{"f"},
+						Usage:   "never prompt",
+						Value:   false,
+					},
+					&cli.BoolFlag{
+						Name:    "recursive",
+						Aliases: []string{"r"},
+						Usage:   "remove directories and their contents recursively",
+						Value:   false,
+					},
+					&cli.BoolFlag{
+						Name:    "verbose",
+						Aliases: []string{"v"},
+						Usage:   "explain what is being done",
+						Value:   false,
+					},
+					&cli.StringFlag{
+						Name:    "progress",
+						Aliases: []string{},
+						Usage:   "set progress output (auto/json), json prints newline-delimited JSON events to stdout",
+						Value:   "auto",
+					},
+					&cli.StringFlag{
+						Name:    "failures",
+						Aliases: []string{},
+						Usage:   "write the files that could not be removed to `FILE`, which can be passed to --files-from to retry only them",
+						Value:   "",
+					},
+					&cli.StringFlag{
+						Name:    "files-from",
+						Aliases: []string{},
+						Usage:   "read the files to remove from `FILE` (one per line, - for stdin)",
+						Value:   "",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if ctx.Args().Len() < 1 && ctx.String("files-from") == "" {
+						return cli.ShowSubcommandHelp(ctx)
+					}
+
+					entries, err := filesFrom(ctx)
+					if err != nil {
+						return err
+					}
+
+					srcs := make([]string, 0, len(entries))
+					for _, entry := range entries {
+						srcs = append(srcs, entry.Src)
+					}
+
+					remote, targets, err := remotePaths(ctx, srcs)
+					if err != nil {
+						return err
+					}
+
+					events, err := progressEvents(ctx)
+					if err != nil {
+						return err
+					}
+
+					nextcloud, _, err := connect(ctx, remote)
+					if err != nil {
+						return err
+					}
+
+					opts := []rm.Option{
+						rm.Context(interruptContext()),
+						rm.Recursive(ctx.Bool("recursive")),
+						rm.Force(ctx.Bool("force")),
+						rm.Verbose(ctx.Bool("verbose")),
+						rm.Events(events),
+						rm.FailureList(ctx.String("failures")),
+						rm.TargetName(remoteName(remote)),
+					}
+					return rm.Do(nextcloud, opts, targets)
+				},
+			},
+			{
+				Name:        "cache",
+				Usage:       "Manage the metadata cache",
+				Description: "",
+				ArgsUsage:   " ",
+				Subcommands: []*cli.Command{
+					{
+						Name:        "clear",
+						Usage:       "Remove all cached metadata",
+						Description: "",
+						ArgsUsage:   " ",
+						Flags:       []cli.Flag{},
+						Action: func(ctx *cli.Context) error {
+							dir, err := cachedir.Dir()
+							if err != nil {
+								return err
+							}
+
+							return nextcloud.NewCache(filepath.Join(dir, appname, "metadata")).Clear()
+						},
+					},
+				},
+			},
+			{
+				Name:        "config",
+				Usage:       "Manage default flag values in the config file",
+				Description: "KEY is FLAG for global flags, COMMAND.FLAG for command flags, and profile.PROFILE.FLAG or profile.PROFILE.COMMAND.FLAG for each profile (e.g. upload.procs)",
+				ArgsUsage:   " ",
+				Subcommands: []*cli.Command{
+					{
+						Name:        "list",
+						Aliases:     []string{"ls"},
+						Usage:       "List values in the config file",
+						Description: "",
+						ArgsUsage:   " ",
+						Flags:       []cli.Flag{},
+						Action: func(ctx *cli.Context) error {
+							if errors.Is(confErr, config.ErrInvalid) {
+								return confErr
+							}
+
+							return conf.Walk(func(keys []string, value interface{}) error {
+								fmt.Println(strings.Join(keys, ".") + " = " + config.Format(value))
+								return nil
+							})
+						},
+					},
+					{
+						Name:        "get",
+						Usage:       "Print a value in the config file",
+						Description: "",
+						ArgsUsage:   "KEY",
+						Flags:       []cli.Flag{},
+						Action: func(ctx *cli.Context) error {
+							if ctx.Args().Len() != 1 {
+								return cli.ShowSubcommandHelp(ctx)
+							}
+
+							if errors.Is(confErr, config.ErrInvalid) {
+								return confErr
+							}
+
+							keys, _, err := configKey(rootApp(ctx), ctx.Args().First())
+							if err != nil {
+								return err
+							}
+
+							value, ok := conf.Lookup(keys...)
+							if !ok {
+								return errors.New("config not set: " + ctx.Args().First())
+							}
+
+							fmt.Println(configString(value))
+							return nil
+						},
+					},
+					{
+						Name:        "set",
+						Usage:       "Set a value in the config file",
+						Description: "",
+						ArgsUsage:   "KEY VALUE",
+						Flags:       []cli.Flag{},
+						Action: func(ctx *cli.Context) error {
+							if ctx.Args().Len() != 2 {
+								return cli.ShowSubcommandHelp(ctx)
+							}
+
+							keys, flag, err := configKey(rootApp(ctx), ctx.Args().First())
+							if err != nil {
+								return err
+							}
+
+							value, err := configValue(flag, ctx.Args().Get(1))
+							if err != nil {
+								return err
+							}
+
+							if err := repairConfig(); err != nil {
+								return err
+							}
+
+							if err := conf.Set(value, keys...); err != nil {
+								return err
+							}
+
+							return conf.Save()
+						},
+					},
+					{
+						Name:        "unset",
+						Usage:       "Remove a value from the config file",
+						Description: "",
+						ArgsUsage:   "KEY",
+						Flags:       []cli.Flag{},
+						Action: func(ctx *cli.Context) error {
+							if ctx.Args().Len() != 1 {
+								return cli.ShowSubcommandHelp(ctx)
+							}
+
+							// 間違えて書いたキーも消せるように、フラグに対応しなければ . で区切ったものを使う
+							keys, _, err := configKey(rootApp(ctx), ctx.Args().First())
+							if err != nil {
+								keys = strings.Split(ctx.Args().First(), ".")
+							}
+
+							if err := repairConfig(); err != nil {
+								return err
+							}
+
+							if !conf.Unset(keys...) {
+								return nil
+							}
+
+							return conf.Save()
+						},
+					},
+				},
+			},
+			{
+				Name:        "credits",
+				Usage:       "Show CREDITS",
+				Description: "",
+				ArgsUsage:   " ",
+				Flags:       []cli.Flag{},
+				Action: func(ctx *cli.Context) error {
+					return credits.Do()
+				},
+			},
+		},
+	}
+}
+
+// --har を指定したときに、やりとりを記録して終了するときに harFile に書き込む
+var (
+	harLog  *httplog.HAR
+	harFile string
+)
+
+// eventWriter は --progress json のときに進捗を書き出す。リクエストを送りなおしたことも書き出すので、ほかと同じように持っておく
+var eventWriter *events.Writer
+
+// humanReadableArgs は --human-readable があるコマンド (du や quota) の -h を --human-readable にする
+// urfave/cli は -h という名前のフラグが true だとヘルプを表示してしまうので、-h を別名にできない
+// -sh のようにまとめて指定されたときは -s --human-readable に分ける
+func humanReadableArgs(app *cli.App, args []string) []string {
+	// グローバルなフラグを飛ばして、コマンドの名前を探す
+	i := 1
+	for i < len(args) && strings.HasPrefix(args[i], "-") && args[i] != "--" {
+		name := strings.TrimLeft(args[i], "-")
+		if !strings.Contains(name, "=") && takesValue(app.Flags, name) {
+			i++
+		}
+		i++
+	}
+
+	if i >= len(args) {
+		return args
+	}
+
+	command := app.Command(args[i])
+	if command == nil || !hasFlag(command.Flags, "human-readable") {
+		return args
+	}
+
+	result := append([]string{}, args[:i+1]...)
+	for j := i + 1; j < len(args); j++ {
+		arg := args[j]
+		if arg == "--" {
+			return append(result, args[j:]...)
+		}
+
+		switch {
+		case arg == "-h":
+			result = append(result, "--human-readable")
+
+		case shortFlags(command.Flags, arg) != nil:
+			for _, name := range shortFlags(command.Flags, arg) {
+				if name == "h" {
+					result = append(result, "--human-readable")
+				} else {
+					result = append(result, "-"+name)
+				}
+			}
+
+		default:
+			result = append(result, arg)
+
+			// フラグの値が -h でも置き換えない
+			name := strings.TrimLeft(arg, "-")
+			if strings.HasPrefix(arg, "-") && !strings.Contains(name, "=") && takesValue(command.Flags, name) && j+1 < len(args) {
+				j++
+				result = append(result, args[j])
+			}
+		}
+	}
+
+	return result
+}
+
+// shortFlags は -sh のようにまとめて指定された、値を取らない 1 文字のフラグの名前を返す
+// h を含まないときや、arg がそのような指定でないときは nil を返す
+func shortFlags(flags []cli.Flag, arg string) []string {
+	if len(arg) < 3 || arg[0] != '-' || arg[1] == '-' || !strings.Contains(arg, "h") || hasFlag(flags, arg[1:]) {
+		return nil
+	}
+
+	names := []string{}
+	for _, r := range arg[1:] {
+		name := string(r)
+		if name != "h" && (!hasFlag(flags, name) || takesValue(flags, name)) {
+			return nil
+		}
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// hasFlag は flags に name という名前のフラグがあるかどうかを返す
+func hasFlag(flags []cli.Flag, name string) bool {
+	for _, flag := range flags {
+		for _, n := range flag.Names() {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// takesValue は flags の name が値を取るフラグかどうかを返す
+func takesValue(flags []cli.Flag, name string) bool {
+	for _, flag := range flags {
+		for _, n := range flag.Names() {
+			if n != name {
+				continue
+			}
+			_, ok := flag.(*cli.BoolFlag)
+			return !ok
+		}
+	}
+	return false
+}
+
+// progressEvents は --progress に従って、進捗を JSON で書き出す events.Writer を返す。書き出さないときは nil を返す
+func progressEvents(ctx *cli.Context) (*events.Writer, error) {
+	switch ctx.String("progress") {
+	case "auto":
+		return nil, nil
+	case "json":
+		eventWriter = events.NewWriter(os.Stdout)
+		return eventWriter, nil
+	default:
+		return nil, errors.New("invalid progress: " + ctx.String("progress"))
+	}
+}
+
+// conf は設定ファイル。app.Before で読み込む。読めなかったときは空の設定にして、confErr にエラーを入れておく
+var (
+	conf    *config.Config
+	confErr error
+)
+
+// loadConfig は --config (NEXTCLOUD_CONFIG) か既定の場所の設定ファイルを読み込んで、グローバルなフラグに値を設定する
+// ホームディレクトリがわからないなど既定の場所が決まらないときは、設定ファイルを使わない
+func loadConfig(ctx *cli.Context) error {
+	path := ctx.String("config")
+	if path == "" {
+		path, _ = config.DefaultPath(appname)
+	}
+
+	c, err := config.Load(path)
+	if err != nil {
+		conf = config.New(path)
+		return err
+	}
+	conf = c
+
+	return applyConfig(ctx, ctx.App.Flags, []string{"profile", profile(ctx)}, nil)
+}
+
+// useConfig はコマンドを実行する前に、設定ファイルからコマンドのフラグに値を設定するようにする
+// サブコマンドの値は、親のコマンドの名前を並べたテーブル (profiles.list など) から探す
+func useConfig(app *cli.App, commands []*cli.Command, parents []string) {
+	for _, command := range commands {
+		// 設定ファイルが間違っていても config で直せるように、config には使わない
+		if len(parents) == 0 && command.Name == "config" {
+			continue
+		}
+
+		names := append(append([]string{}, parents...), command.Name)
+		useConfig(app, command.Subcommands, names)
+
+		if command.Action == nil {
+			continue
+		}
+
+		action, flags := command.Action, command.Flags
+		command.Action = func(ctx *cli.Context) error {
+			if confErr != nil {
+				return confErr
+			}
+
+			if err := checkConfig(app); err != nil {
+				return err
+			}
+
+			if err := applyConfig(ctx, flags, append([]string{"profile", profile(ctx)}, names...), names); err != nil {
+				return err
+			}
+
+			return action(ctx)
+		}
+	}
+}
+
+// applyConfig は flags のうち、フラグでも環境変数でも指定されなかったものに設定ファイルの値を設定する
+// 値は tables の順に探して、最初に見つかったものを使う
+func applyConfig(ctx *cli.Context, flags []cli.Flag, tables ...[]string) error {
+	for _, flag := range flags {
+		name := flag.Names()[0]
+		if !configurable(name) || ctx.IsSet(name) {
+			continue
+		}
+
+		for _, table := range tables {
+			keys := append(append([]string{}, table...), name)
+			value, ok := conf.Lookup(keys...)
+			if !ok {
+				continue
+			}
+
+			if err := ctx.Set(name, configString(value)); err != nil {
+				return errors.New(conf.Path() + ": invalid value for " + strings.Join(keys, ".") + ": " + config.Format(value))
+			}
+			break
+		}
+	}
+
+	return nil
+}
+
+// repairConfig は TOML として読めなかった設定ファイルを PATH.bak に移して、空の設定から書きなおせるようにする
+func repairConfig() error {
+	if !errors.Is(confErr, config.ErrInvalid) {
+		return nil
+	}
+
+	backup := conf.Path() + ".bak"
+	if err := os.Rename(conf.Path(), backup); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, confErr.Error())
+	fmt.Fprintln(os.Stderr, "moved the invalid config file to "+backup)
+	confErr = nil
+
+	return nil
+}
+
+// checkConfig は設定ファイルに、フラグに対応しないキーがないか調べる
+func checkConfig(app *cli.App) error {
+	return conf.Walk(func(keys []string, value interface{}) error {
+		if configFlag(app, keys) == nil {
+			return errors.New(conf.Path() + ": unknown config key: " + strings.Join(keys, "."))
+		}
+		return nil
+	})
+}
+
+// rootApp は nextcloud-cli の App を返す
+// サブコマンドの ctx.App は親のコマンドから作られたものなので、ほかのコマンドのフラグがない
+func rootApp(ctx *cli.Context) *cli.App {
+	lineage := ctx.Lineage()
+	return lineage[len(lineage)-1].App
+}
+
+// configurable は設定ファイルで値を指定できるフラグかどうかを返す
+// プロファイルは profiles use で選ぶ
+func configurable(name string) bool {
+	switch name {
+	case "help", "version", "config", "profile":
+		return false
+	default:
+		return true
+	}
+}
+
+// configKey は upload.procs や profile.work.upload.procs のようなキーを、テーブルの名前に分けて対応するフラグと一緒に返す
+// プロファイルの名前には . が使えるので、後ろがフラグに対応するところで区切る
+func configKey(app *cli.App, key string) ([]string, cli.Flag, error) {
+	parts := strings.Split(key, ".")
+
+	if parts[0] == "profile" {
+		for i := 2; i < len(parts); i++ {
+			keys := append([]string{"profile", strings.Join(parts[1:i], ".")}, parts[i:]...)
+			if flag := configFlag(app, keys); flag != nil {
+				return keys, flag, nil
+			}
+		}
+	} else if flag := configFlag(app, parts); flag != nil {
+		return parts, flag, nil
+	}
+
+	return nil, nil, errors.New("unknown config key: " + key)
+}
+
+// configFlag はテーブルの名前を並べた keys に対応するフラグを返す。なければ nil を返す
+func configFlag(app *cli.App, keys []string) cli.Flag {
+	if len(keys) >= 3 && keys[0] == "profile" {
+		if credentials.ValidProfile(keys[1]) != nil {
+			return nil
+		}
+		keys = keys[2:]
+	}
+
+	flags, commands := app.Flags, app.Commands
+	for _, key := range keys[:len(keys)-1] {
+		var command *cli.Command
+		for _, c := range commands {
+			if c.Name == key {
+				command = c
+			}
+		}
+		if command == nil || command.Name == "config" {
+			return nil
+		}
+
+		flags, commands = command.Flags, command.Subcommands
+	}
+
+	name := keys[len(keys)-1]
+	if !configurable(name) {
+		return nil
+	}
+
+	for _, flag := range flags {
+		if flag.Names()[0] == name {
+			return flag
+		}
+	}
+
+	return nil
+}
+
+// configValue は config set で指定された文字列を、フラグの型に合わせた設定ファイルの値にする
+func configValue(flag cli.Flag, s string) (interface{}, error) {
+	invalid := errors.New("invalid value for " + flag.Names()[0] + ": " + s)
+
+	switch flag.(type) {
+	case *cli.BoolFlag:
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, invalid
+		}
+		return v, nil
+	case *cli.IntFlag:
+		v, err := strconv.ParseInt(s, 0, 64)
+		if err != nil {
+			return nil, invalid
+		}
+		return v, nil
+	case *cli.DurationFlag:
+		if _, err := time.ParseDuration(s); err != nil {
+			return nil, invalid
+		}
+		return s, nil
+	default:
+		return s, nil
+	}
+}
+
+// configString は設定ファイルの値をフラグに渡す文字列にする
+func configString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return config.Format(value)
+}
+
+// 終了コード
+// スクリプトから失敗の理由で処理を分けられるように、サーバーのエラーの種類ごとに分ける
+const (
+	exitError               = 1   // 下のどれにも当てはまらないエラー
+	exitNotExist            = 2   // ファイルが存在しない
+	exitPermission          = 3   // 認証に失敗したか、権限がない
+	exitExist               = 4   // ファイルが既に存在する
+	exitLocked              = 5   // ファイルがロックされている
+	exitInsufficientStorage = 6   // 容量が足りないか、ファイルが大きすぎる
+	exitThrottled           = 7   // リクエストが多すぎるか、サーバーがメンテナンス中
+	exitServer              = 8   // サーバーのエラー
+	exitNetwork             = 9   // 接続できなかった
+	exitPrecondition        = 10  // ほかから変更されていて、条件付きリクエストの条件を満たさなかった
+	exitInterrupted         = 130 // SIGINT などで中断した
+)
+
+// exitCode は err の種類に応じた終了コードを返す
+func exitCode(err error) int {
+	switch webdav.TypeOf(err) {
+	case webdav.ErrLocked:
+		return exitLocked
+	case webdav.ErrInsufficientStorage, webdav.ErrTooLarge:
+		return exitInsufficientStorage
+	case webdav.ErrThrottled:
+		return exitThrottled
+	case webdav.ErrServer:
+		return exitServer
+	case webdav.ErrNetwork:
+		return exitNetwork
+	case webdav.ErrPrecondition:
+		return exitPrecondition
+	}
+
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return exitNotExist
+	case errors.Is(err, os.ErrPermission):
+		return exitPermission
+	case errors.Is(err, os.ErrExist):
+		return exitExist
+	default:
+		return exitError
+	}
+}
+
+// interruptContext は SIGINT か SIGTERM を受け取るとキャンセルされる context を返す
+// キャンセルした後はシグナルの扱いを元に戻すので、もう一度送ればすぐに終了する
+func interruptContext() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx
+}
+
+// newCredential は URL とフラグからログイン情報を作る
+// フラグで指定されなかったユーザー名とパスワードは端末から入力してもらう
+func newCredential(ctx *cli.Context, rawURL string, usernameFlag string, passwordFlag string) (*credentials.Credential, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.New("invalid argument : url\n" + err.Error())
+	}
+
+	username := ctx.String(usernameFlag)
+	if !ctx.IsSet(usernameFlag) {
+		if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+			return nil, errors.New("stdin is not a terminal")
+		}
+
+		fmt.Print("Enter username: ")
+		if _, err := fmt.Fscanln(os.Stdin, &username); err != nil {
+			return nil, err
+		}
+	}
+
+	password := ctx.String(passwordFlag)
+	if !ctx.IsSet(passwordFlag) {
+		if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+			return nil, errors.New("stdin is not a terminal")
+		}
+
+		fmt.Print("Enter password: ")
+		bytes, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		if err != nil {
+			return nil, err
+		}
+
+		password = string(bytes)
+	}
+
+	credential := &credentials.Credential{
+		URL:      webdavURL(u.String()),
+		Username: username,
+		Password: credentials.Password(password),
+	}
+
+	return credential, nil
+}
+
+// profile は --profile か NEXTCLOUD_PROFILE で指定されたプロファイルを返す
+// 指定されていなければ profiles use で選択したプロファイルを返す
+func profile(ctx *cli.Context) string {
+	if profile := profileFlag(ctx); profile != "" {
+		return profile
+	}
+
+	return credentials.CurrentProfile(appname)
+}
+
+// profileFlag は --profile か NEXTCLOUD_PROFILE で指定されたプロファイルを返す。指定されていなければ空を返す
+// login などはサブコマンドにも --profile があるので、親のコンテキストまでさかのぼって探す
+func profileFlag(ctx *cli.Context) string {
+	for _, c := range ctx.Lineage() {
+		if profile := c.String("profile"); profile != "" {
+			return profile
+		}
+	}
+
+	return ""
+}
+
+// retryCount は --retry の値を返す
+// upload などはサブコマンドにも --retry があるので、サブコマンドで指定されていなければ全体の --retry (NEXTCLOUD_RETRY) を使う
+func retryCount(ctx *cli.Context) int {
+	lineage := ctx.Lineage()
+	for _, c := range lineage {
+		if c.IsSet("retry") {
+			return c.Int("retry")
+		}
+	}
+
+	return lineage[len(lineage)-1].Int("retry")
+}
+
+// connect はログイン情報を探して Nextcloud に接続する
+// remote は splitRemote で取り出したもので、公開リンクのときはログインせずにその共有にアクセスする
+// プロファイルのときはそのプロファイルを使う。空のときは次の順に探す
+//
+//  1. --credentials-file (NEXTCLOUD_CREDENTIALS_FILE) で指定したファイル
+//  2. 環境変数 NEXTCLOUD_URL, NEXTCLOUD_USER, NEXTCLOUD_PASSWORD (NEXTCLOUD_PASSWORD_FILE)
+//  3. login で保存したプロファイルのうち、profiles use で選択したもの
+//
+// 1 と 2 でユーザー名かパスワードがなければ、~/.netrc から URL のホストに合うものを使う
+func connect(ctx *cli.Context, remote string) (*nextcloud.Nextcloud, *credentials.Credential, error) {
+	if share, ok := nextcloud.ParseShareURL(remote); ok {
+		credential := &credentials.Credential{
+			URL:      share.URL,
+			Username: share.Token,
+			Password: credentials.Password(ctx.String("share-password")),
+		}
+		n, err := newNextcloud(ctx, credential, nil)
+		return n, credential, err
+	}
+
+	profile := remote
+	credential, save, err := loadCredential(ctx, profile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	n, err := newNextcloud(ctx, credential, save)
+	return n, credential, err
+}
+
+// loadCredential は connect と同じ順にログイン情報を探す
+// 返す関数は、OAuth2 のトークンをリフレッシュしたときに保存しなおすためのもの。プロファイルでなければ nil
+func loadCredential(ctx *cli.Context, profile string) (*credentials.Credential, func(*credentials.Credential) error, error) {
+	if profile == "" {
+		if path := ctx.String("credentials-file"); path != "" {
+			credential, err := credentials.ReadFile(path)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			credential.URL = webdavURL(credential.URL)
+			return credential, nil, nil
+		}
+
+		credential, err := credentials.FromEnv()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if credential != nil {
+			credential.URL = webdavURL(credential.URL)
+			return credential, nil, nil
+		}
+
+		profile = credentials.CurrentProfile(appname)
+	}
+
+	// 保存しなおすときにもう一度入力しなくていいように、パスフレーズは覚えておく
+	var passphrase []byte
+	passphraseFunc := credentials.PassphraseFunc(func() ([]byte, error) {
+		if passphrase != nil {
+			return passphrase, nil
+		}
+
+		p, err := readPassphrase(profile)
+		if err != nil {
+			return nil, err
+		}
+		passphrase = p
+
+		return passphrase, nil
+	})
+
+	credential, err := credentials.LoadProfile(appname, profile, passphraseFunc)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+		if profile != credentials.DefaultProfile {
+			return nil, nil, errors.New("you need to login: profile " + profile)
+		}
+		return nil, nil, errors.New("you need to login")
+	}
+
+	save := func(credential *credentials.Credential) error {
+		return credentials.UpdateProfile(appname, profile, credential, passphraseFunc)
+	}
+
+	return credential, save, nil
+}
+
+// newNextcloud は credential でログインする Nextcloud クライアントを作る
+// OAuth2 のときはアクセストークンを付ける Transport を使い、トークンをリフレッシュしたら save で保存する
+func newNextcloud(ctx *cli.Context, credential *credentials.Credential, save func(*credentials.Credential) error) (*nextcloud.Nextcloud, error) {
+	client, err := httpClient(ctx, credential.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	if credential.OAuth2 == nil {
+		auth := webdav.BasicAuth(credential.Username, credential.Password.String(), appname, version)
+		return nextcloud.New(credential.URL, client, auth), nil
+	}
+
+	config := &oauth2.Config{
+		ClientID:     credential.OAuth2.ClientID,
+		ClientSecret: credential.OAuth2.ClientSecret,
+		TokenURL:     credential.OAuth2.TokenURL,
+	}
+
+	token := &oauth2.Token{
+		AccessToken:  credential.OAuth2.AccessToken,
+		RefreshToken: credential.OAuth2.RefreshToken,
+		Expiry:       credential.OAuth2.Expiry,
+		UserID:       credential.Username,
+	}
+
+	client.Transport = oauth2.NewTransport(client.Transport, config, token, func(token *oauth2.Token) error {
+		credential.OAuth2.AccessToken = token.AccessToken
+		credential.OAuth2.RefreshToken = token.RefreshToken
+		credential.OAuth2.Expiry = token.Expiry
+
+		if save == nil {
+			return nil
+		}
+		return save(credential)
+	})
+
+	return nextcloud.New(credential.URL, client, webdav.UserAgent(appname, version)), nil
+}
+
+// loginFlow は Login Flow v2 でブラウザからログインしてもらい、アプリパスワードを取得する
+// 2 要素認証や SSO を使っているサーバーでも、パスワードを入力せずにログインできる
+func loginFlow(ctx *cli.Context, rawURL string) (*credentials.Credential, error) {
+	c := interruptContext()
+
+	client, err := httpClient(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	flow, err := loginflow.Start(c, client, rawURL, userAgent())
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintln(os.Stderr, "Open the following URL in your browser to login:")
+	fmt.Fprintln(os.Stderr, flow.LoginURL)
+
+	if !ctx.Bool("no-browser") {
+		if err := _open.Start(flow.LoginURL); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to open browser:", err)
+		}
+	}
+
+	result, err := flow.Wait(c, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	credential := &credentials.Credential{
+		URL:      webdavURL(result.Server),
+		Username: result.LoginName,
+		Password: credentials.Password(result.AppPassword),
+	}
+
+	return credential, nil
+}
+
+// oauthLogin は OAuth2 の authorization code flow でログインする
+// --oauth-redirect-url で待ち受けて、ブラウザで許可されたらトークンを取得する
+func oauthLogin(ctx *cli.Context, rawURL string) (*credentials.Credential, error) {
+	base, err := loginflow.BaseURL(rawURL)
+	if err != nil {
+		return nil, errors.New("invalid argument : url\n" + err.Error())
+	}
+
+	config := oauth2.NextcloudConfig(base, ctx.String("oauth"), ctx.String("oauth-secret"), ctx.String("oauth-redirect-url"))
+
+	client, err := httpClient(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := config.Authorize(interruptContext(), client, func(authURL string) error {
+		fmt.Fprintln(os.Stderr, "Open the following URL in your browser to login:")
+		fmt.Fprintln(os.Stderr, authURL)
+
+		if !ctx.Bool("no-browser") {
+			if err := _open.Start(authURL); err != nil {
+				fmt.Fprintln(os.Stderr, "failed to open browser:", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	credential := &credentials.Credential{
+		URL:      webdavURL(base),
+		Username: token.UserID,
+		OAuth2: &credentials.OAuth2{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			TokenURL:     config.TokenURL,
+			AccessToken:  token.AccessToken,
+			RefreshToken: token.RefreshToken,
+			Expiry:       token.Expiry,
+		},
+	}
+
+	return credential, nil
+}
+
+func userAgent() string {
+	return appname + "/" + version
+}
+
+// newPassphrase は保存するパスワードを暗号化するパスフレーズを、NEXTCLOUD_PASSPHRASE か端末から入力してもらう
+// 端末から入力するときは、打ち間違いがないように二回入力してもらう
+func newPassphrase() ([]byte, error) {
+	if passphrase := os.Getenv("NEXTCLOUD_PASSPHRASE"); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, errors.New("stdin is not a terminal")
+	}
+
+	fmt.Print("Enter passphrase: ")
+	passphrase, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(passphrase) <= 0 {
+		return nil, errors.New("empty passphrase")
+	}
+
+	fmt.Print("Enter same passphrase again: ")
+	again, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return nil, err
+	}
+
+	if string(passphrase) != string(again) {
+		return nil, errors.New("passphrases do not match")
+	}
+
+	return passphrase, nil
+}
+
+// readPassphrase は profile のパスワードを復号するパスフレーズを、NEXTCLOUD_PASSPHRASE か端末から入力してもらう
+func readPassphrase(profile string) ([]byte, error) {
+	if passphrase := os.Getenv("NEXTCLOUD_PASSPHRASE"); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, errors.New("passphrase is required: set NEXTCLOUD_PASSPHRASE")
+	}
+
+	fmt.Fprintf(os.Stderr, "Enter passphrase for profile %s: ", profile)
+	passphrase, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+
+	return passphrase, err
+}
+
+// splitRemote はリモートのパスを、接続先とパスに分ける
+// 接続先は "work:/path" のように前に付けたプロファイルか、公開リンク (https://host/s/TOKEN/path) のリンク
+// どちらでもなければ全体をパスとして扱い、接続先は profileFlag に従う
+func splitRemote(ctx *cli.Context, arg string) (string, string) {
+	if share, ok := nextcloud.ParseShareURL(arg); ok {
+		return share.Link, share.Path
+	}
+
+	if i := strings.Index(arg, ":"); i > 0 {
+		if profile := arg[:i]; credentials.HasProfile(appname, profile) {
+			return profile, arg[i+1:]
+		}
+	}
+
+	return profileFlag(ctx), arg
+}
+
+// remotePaths は args から splitRemote で接続先を取り除く
+// 一度に扱えるのは一つの接続先だけなので、違うものが混ざっていたらエラーにする
+func remotePaths(ctx *cli.Context, args []string) (string, []string, error) {
+	remote := ""
+	paths := make([]string, 0, len(args))
+	for i, arg := range args {
+		r, path := splitRemote(ctx, arg)
+		if i > 0 && r != remote {
+			return "", nil, errors.New("cannot use different profiles or shares at once: " + args[0] + ", " + arg)
+		}
+
+		remote = r
+		paths = append(paths, path)
+	}
+
+	return remote, paths, nil
+}
+
+// remoteName は remote のパスを、splitRemote で remote とパスに分けられる名前にする関数を返す
+// 保存したプロファイルでない (環境変数のログイン情報など) ときは、パスをそのまま使う
+func remoteName(remote string) func(path string) string {
+	if share, ok := nextcloud.ParseShareURL(remote); ok {
+		return func(path string) string {
+			return share.Link + "?path=" + url.QueryEscape(path)
+		}
+	}
+
+	if remote != "" && credentials.HasProfile(appname, remote) {
+		return func(path string) string {
+			return remote + ":" + path
+		}
+	}
+
+	return func(path string) string {
+		return path
+	}
+}
+
+// filesFrom は引数と、--files-from のファイルに書かれたものを返す
+func filesFrom(ctx *cli.Context) ([]transfer.Entry, error) {
+	entries := transfer.Entries(ctx.Args().Slice())
+
+	path := ctx.String("files-from")
+	if path == "" {
+		return entries, nil
+	}
+
+	r := os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	fromFile, err := transfer.ReadEntries(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return append(entries, fromFile...), nil
+}
+
+// remoteEntries は filesFrom のコピー元を remotePaths と同じように、プロファイルや共有リンクとパスに分ける
+func remoteEntries(ctx *cli.Context) (string, []transfer.Entry, error) {
+	entries, err := filesFrom(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	srcs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		srcs = append(srcs, entry.Src)
+	}
+
+	remote, paths, err := remotePaths(ctx, srcs)
+	if err != nil {
+		return "", nil, err
+	}
+
+	for i := range entries {
+		entries[i].Src = paths[i]
+	}
+
+	return remote, entries, nil
+}
+
+// webdavURL は Nextcloud の URL に remote.php/webdav が付いていなければ付ける
+func webdavURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	if !strings.HasSuffix(strings.TrimSuffix(u.Path, "/"), "remote.php/webdav") {
+		u.Path = path.Join(u.Path, "/remote.php/webdav/")
+	}
+
+	return u.String()
+}
+
+// useCache は --no-cache が指定されていなければ、ログイン先ごとのメタデータキャッシュを使うようにする
+func useCache(ctx *cli.Context, n *nextcloud.Nextcloud, credential *credentials.Credential) {
+	if ctx.Bool("no-cache") {
+		return
+	}
+
+	dir, err := cachedir.Dir()
+	if err != nil {
+		return
+	}
+
+	sum := sha256.Sum256([]byte(credential.URL + "\n" + credential.Username))
+	cache := nextcloud.NewCache(filepath.Join(dir, appname, "metadata", hex.EncodeToString(sum[:])))
+
+	// 消したディレクトリのものが溜まり続けないように、しばらく使っていないものは消す。キャッシュなので失敗しても無視する
+	cache.Prune(cacheMaxAge)
+
+	n.SetCache(cache)
+}
+
+// cacheMaxAge はメタデータキャッシュを使わなくなってから消すまでの時間
+const cacheMaxAge = 30 * 24 * time.Hour
+
+// httpClient は Nextcloud に接続する http.Client を作る
+// 接続の設定は saved (login で保存したもの) に、コマンドラインで指定したものを上書きして使う
+// 一時的なエラーは --retry 回まで Transport で送りなおす。--retry はコマンドごとに指定したものを優先する
+func httpClient(ctx *cli.Context, saved *credentials.Connection) (*http.Client, error) {
+	connection := mergeConnection(ctx, saved)
+
+	timeout := 30 * time.Second
+	tlsTimeout := 10 * time.Second
+	if connection.Timeout != "" {
+		d, err := time.ParseDuration(connection.Timeout)
+		if err != nil || d <= 0 {
+			return nil, errors.New("invalid timeout: " + connection.Timeout)
+		}
+		timeout, tlsTimeout = d, d
+	}
+
+	idleTimeout := 90 * time.Second
+	if connection.IdleTimeout != "" {
+		d, err := time.ParseDuration(connection.IdleTimeout)
+		if err != nil || d <= 0 {
+			return nil, errors.New("invalid idle timeout: " + connection.IdleTimeout)
+		}
+		idleTimeout = d
+	}
+
+	if connection.MaxConns < 0 {
+		return nil, fmt.Errorf("invalid max conns: %d", connection.MaxConns)
+	}
+
+	proxy, err := proxyFunc(connection.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := tlsConfig(connection)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		DualStack: true,
+	}
+	cache := sync.Map{}
+	group := singleflight.Group{}
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: func(ctx context.Context, network string, addr string) (conn net.Conn, err error) {
+			i := strings.LastIndex(addr, ":")
+			host := addr[:i]
+			port := addr[i:]
+
+			addrs, ok := cache.Load(host)
+			if !ok {
+				addrs, err, _ = group.Do(host, func() (interface{}, error) {
+					return net.DefaultResolver.LookupHost(ctx, host)
+				})
+				if err == context.DeadlineExceeded {
+					group.Forget(host)
+					return
+				}
+
+				cache.Store(host, addrs)
+			}
+
+			for _, addr := range addrs.([]string) {
+				conn, err = dialer.Dial(network, addr+port)
+				if err == nil {
+					return
+				}
+			}
+
+			return
+		},
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     connection.HTTP2, // DialContext を指定しているので、これがなければ HTTP/1.1 だけを使う
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   50,
+		MaxConnsPerHost:       connection.MaxConns,
+		IdleConnTimeout:       idleTimeout,
+		TLSHandshakeTimeout:   tlsTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		DisableKeepAlives:     false,
+	}
+
+	if connection.MaxConns > 0 && connection.MaxConns < transport.MaxIdleConnsPerHost {
+		transport.MaxIdleConnsPerHost = connection.MaxConns
+	}
+
+	// --debug のログは、リトライする Transport の下に置いて送りなおしたものも一つずつ出す
+	var base http.RoundTripper = transport
+	if ctx.Bool("debug") || harLog != nil {
+		logger := &httplog.Transport{Base: transport, HAR: harLog}
+		if ctx.Bool("debug") {
+			logger.Out = os.Stderr
+			logger.DumpBody = ctx.Bool("debug-body")
+		}
+		base = logger
+	}
+
+	client := &http.Client{
+		Transport: &retry.Transport{
+			Base:   base,
+			Policy: retry.DefaultPolicy(retryCount(ctx)),
+			OnRetry: func(req *http.Request, resp *http.Response, err error, delay time.Duration) {
+				if err == nil {
+					err = errors.New(resp.Status)
+				}
+				fmt.Fprintln(os.Stderr, "error! retry after "+delay.Round(time.Second).String()+"...")
+				fmt.Fprintln(os.Stderr, "  "+req.Method+" "+req.URL.Redacted()+": "+err.Error())
+				eventWriter.RequestRetried(req.Method, req.URL.Redacted(), delay, err)
+			},
+		},
+	}
+	return client, nil
+}
+
+// mergeConnection は saved にコマンドラインで指定した接続の設定を上書きしたものを返す
+func mergeConnection(ctx *cli.Context, saved *credentials.Connection) *credentials.Connection {
+	connection := credentials.Connection{}
+	if saved != nil {
+		connection = *saved
+	}
+
+	if ctx.IsSet("ca-cert") {
+		connection.CACert = ctx.String("ca-cert")
+	}
+	if ctx.IsSet("client-cert") {
+		connection.ClientCert = ctx.String("client-cert")
+	}
+	if ctx.IsSet("client-key") {
+		connection.ClientKey = ctx.String("client-key")
+	}
+	if ctx.IsSet("insecure") {
+		connection.Insecure = ctx.Bool("insecure")
+	}
+	if ctx.IsSet("proxy") {
+		connection.Proxy = ctx.String("proxy")
+	}
+	if ctx.IsSet("timeout") {
+		connection.Timeout = ctx.String("timeout")
+	}
+	if ctx.IsSet("idle-timeout") {
+		connection.IdleTimeout = ctx.String("idle-timeout")
+	}
+	if ctx.IsSet("max-conns") {
+		connection.MaxConns = ctx.Int("max-conns")
+	}
+	if ctx.IsSet("http2") {
+		connection.HTTP2 = ctx.Bool("http2")
+	}
+
+	return &connection
+}
+
+// loginConnection は login で保存する接続の設定を返す。何も指定されていなければ nil を返す
+// 別のディレクトリから使っても同じファイルを読めるように、証明書のパスは絶対パスにしておく
+func loginConnection(ctx *cli.Context) (*credentials.Connection, error) {
+	connection := mergeConnection(ctx, nil)
+	if *connection == (credentials.Connection{}) {
+		return nil, nil
+	}
+
+	for _, path := range []*string{&connection.CACert, &connection.ClientCert, &connection.ClientKey} {
+		if *path == "" {
+			continue
+		}
+
+		abs, err := filepath.Abs(*path)
+		if err != nil {
+			return nil, err
+		}
+		*path = abs
+	}
+
+	return connection, nil
+}
+
+// proxyFunc は --proxy の値から http.Transport の Proxy を作る
+// 空なら HTTPS_PROXY などの環境変数に従い、"direct" ならプロキシを使わない
+func proxyFunc(proxy string) (func(*http.Request) (*url.URL, error), error) {
+	switch proxy {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case "direct":
+		return nil, nil
+	}
+
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		return nil, errors.New("invalid proxy url: " + proxy)
+	}
+
+	return http.ProxyURL(u), nil
+}
+
+// insecureWarning は --insecure の警告を一度だけ表示するための Once
+var insecureWarning sync.Once
+
+// tlsConfig は CA 証明書やクライアント証明書を使う tls.Config を作る。何も指定されていなければ nil を返す
+func tlsConfig(connection *credentials.Connection) (*tls.Config, error) {
+	if connection.CACert == "" && connection.ClientCert == "" && connection.ClientKey == "" && !connection.Insecure {
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+
+	if connection.CACert != "" {
+		pem, err := ioutil.ReadFile(connection.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca cert: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in ca cert: " + connection.CACert)
+		}
+		config.RootCAs = pool
+	}
+
+	if connection.ClientCert != "" || connection.ClientKey != "" {
+		if connection.ClientCert == "" || connection.ClientKey == "" {
+			return nil, errors.New("both --client-cert and --client-key are required")
+		}
+
+		cert, err := tls.LoadX509KeyPair(connection.ClientCert, connection.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client cert: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if connection.Insecure {
+		insecureWarning.Do(func() {
+			fmt.Fprintln(os.Stderr, "WARNING: TLS certificate verification is disabled (--insecure).")
+			fmt.Fprintln(os.Stderr, "WARNING: your password and files can be intercepted by anyone on the network.")
+		})
+		config.InsecureSkipVerify = true
+	}
+
+	return config, nil
 }
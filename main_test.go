@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kurusugawa-computer/nextcloud-cli/lib/webdav"
+	"gopkg.in/urfave/cli.v2"
+)
+
+// runCommand は name のコマンドの Action を action に差し替えて args を実行する
+// 設定ファイルは dir の config.toml を使う
+func runCommand(t *testing.T, dir string, name string, args []string, action cli.ActionFunc) {
+	t.Helper()
+
+	app := newApp(1)
+	for _, command := range app.Commands {
+		if command.Name == name {
+			command.Action = action
+		}
+	}
+	useConfig(app, app.Commands, nil)
+
+	os.Setenv("NEXTCLOUD_CONFIG", filepath.Join(dir, "config.toml"))
+	defer os.Unsetenv("NEXTCLOUD_CONFIG")
+
+	if err := app.Run(append([]string{appname}, args...)); err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+}
+
+func TestRetryCount(t *testing.T) {
+	os.Unsetenv("NEXTCLOUD_RETRY")
+
+	tests := []struct {
+		args   []string // コマンドの名前を "CMD" にしたもの
+		env    string   // NEXTCLOUD_RETRY
+		config string
+		want   int
+	}{
+		{[]string{"CMD", "a"}, "", "", 3},
+		{[]string{"--retry", "0", "CMD", "a"}, "", "", 0},
+		{[]string{"CMD", "a"}, "1", "", 1},
+		{[]string{"CMD", "--retry", "2", "a"}, "", "", 2},
+		{[]string{"--retry", "0", "CMD", "--retry", "2", "a"}, "1", "", 2},
+		{[]string{"CMD", "a"}, "", "retry = 4\n", 4},
+		{[]string{"CMD", "a"}, "", "[CMD]\nretry = 6\n", 6},
+		{[]string{"--retry", "0", "CMD", "a"}, "", "[CMD]\nretry = 6\n", 6},
+	}
+
+	for _, name := range []string{"download", "get", "upload", "copy", "put", "rm"} {
+		for _, tt := range tests {
+			dir := t.TempDir()
+
+			config := []byte(strings.ReplaceAll(tt.config, "CMD", name))
+			if err := os.WriteFile(filepath.Join(dir, "config.toml"), config, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.env != "" {
+				os.Setenv("NEXTCLOUD_RETRY", tt.env)
+			}
+
+			args := []string{}
+			for _, arg := range tt.args {
+				args = append(args, strings.ReplaceAll(arg, "CMD", name))
+			}
+
+			got := -1
+			runCommand(t, dir, name, args, func(ctx *cli.Context) error {
+				got = retryCount(ctx)
+				return nil
+			})
+
+			os.Unsetenv("NEXTCLOUD_RETRY")
+
+			if got != tt.want {
+				t.Errorf("%v (NEXTCLOUD_RETRY=%q, config %q): retry = %d, want %d", args, tt.env, tt.config, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	remote := func(typ int) error {
+		return &os.PathError{Op: "WriteFile", Path: "a.txt", Err: &webdav.Error{Op: "PUT", Type: typ}}
+	}
+
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errors.New("error"), exitError},
+		{remote(webdav.ErrNotExist), exitNotExist},
+		{remote(webdav.ErrExist), exitExist},
+		{fmt.Errorf("file already exists: a.txt: %w", os.ErrExist), exitExist},
+		{remote(webdav.ErrPrecondition), exitPrecondition},
+		{fmt.Errorf("a.txt was modified: %w", remote(webdav.ErrPrecondition)), exitPrecondition},
+		{remote(webdav.ErrLocked), exitLocked},
+	}
+
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestHumanReadableArgs(t *testing.T) {
+	app := newApp(1)
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"du", "-h", "a"}, []string{"du", "--human-readable", "a"}},
+		{[]string{"du", "-sh", "a"}, []string{"du", "-s", "--human-readable", "a"}},
+		{[]string{"du", "-hs", "a"}, []string{"du", "--human-readable", "-s", "a"}},
+		{[]string{"du", "-d", "1", "-h"}, []string{"du", "-d", "1", "--human-readable"}},
+		{[]string{"du", "--", "-h"}, []string{"du", "--", "-h"}},
+		{[]string{"du", "-s", "--", "-sh"}, []string{"du", "-s", "--", "-sh"}},
+		{[]string{"du", "-xh", "a"}, []string{"du", "-xh", "a"}},
+		{[]string{"df", "-h"}, []string{"df", "--human-readable"}},
+		{[]string{"--retry", "3", "du", "-sh", "a"}, []string{"--retry", "3", "du", "-s", "--human-readable", "a"}},
+		{[]string{"--profile=work", "--no-cache", "quota", "-h"}, []string{"--profile=work", "--no-cache", "quota", "--human-readable"}},
+		{[]string{"--profile", "du", "list", "-h"}, []string{"--profile", "du", "list", "-h"}},
+		{[]string{"list", "-h"}, []string{"list", "-h"}},
+		{[]string{"-h"}, []string{"-h"}},
+	}
+
+	for _, tt := range tests {
+		got := humanReadableArgs(app, append([]string{appname}, tt.args...))
+		if want := append([]string{appname}, tt.want...); !reflect.DeepEqual(got, want) {
+			t.Errorf("humanReadableArgs(%q) = %q, want %q", tt.args, got[1:], tt.want)
+		}
+	}
+}